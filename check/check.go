@@ -1,10 +1,13 @@
 package check
 
 import (
+	"errors"
+	"fmt"
 	"genspark2api/common"
 	"genspark2api/common/config"
 	logger "genspark2api/common/loggger"
 	"github.com/samber/lo"
+	"net/url"
 	"regexp"
 	"strings"
 )
@@ -12,37 +15,56 @@ import (
 func CheckEnvVariable() {
 	logger.SysLog("environment variable checking...")
 
-	if config.GSCookie == "" {
-		logger.FatalLog("环境变量 GS_COOKIE 未设置")
+	if config.GSCookie == "" && config.GSCookieFile == "" {
+		logger.FatalLog("环境变量 GS_COOKIE 及 GS_COOKIE_FILE 均未设置")
+	}
+	for _, proxyUrl := range strings.Split(config.ProxyUrl, ",") {
+		proxyUrl = strings.TrimSpace(proxyUrl)
+		if proxyUrl == "" {
+			continue
+		}
+		u, err := url.Parse(proxyUrl)
+		if err != nil || u.Host == "" || !lo.Contains([]string{"http", "https", "socks5", "socks5h"}, u.Scheme) {
+			logger.FatalLog("环境变量 PROXY_URL 设置有误,仅支持 http/https/socks5/socks5h 代理")
+		}
+	}
+	// 内存中的用量记录无上限且重启后丢失
+	if config.UsageLedgerEnable == 1 && !config.StatePersistent() {
+		logger.FatalLog("环境变量 USAGE_LEDGER_ENABLE=1 时需配置 STATE_DB_PATH 或 REDIS_CONN_STRING")
+	}
+	if config.ProjectCleanupEnable == 1 && config.ProjectCleanupInterval <= 0 {
+		logger.FatalLog("环境变量 PROJECT_CLEANUP_INTERVAL 需大于0")
+	}
+	err := config.UpdateReloadable(func(next *config.Reloadable) error {
+		if err := parseConfig(next); err != nil {
+			return err
+		}
+		common.SetModelEncoders(next.Models.Encoders())
+		return nil
+	})
+	if err != nil {
+		logger.FatalLog(err.Error())
+	}
+	if config.JwtEnabled() && config.IsJwtURL() && config.JwtJwksRefreshInterval <= 0 {
+		logger.FatalLog("环境变量 JWT_JWKS_REFRESH_INTERVAL 需大于0")
+	}
+	if err := config.ReloadIPPolicy(); err != nil {
+		logger.FatalLog(fmt.Sprintf("IP规则设置有误: %v", err))
+	}
+	if cfg := config.Current(); cfg.KeyRateLimitRPM < 0 || cfg.KeyRateLimitTPM < 0 || cfg.KeyMaxStreams < 0 {
+		logger.FatalLog("环境变量 KEY_RATE_LIMIT_RPM、KEY_RATE_LIMIT_TPM、KEY_MAX_STREAMS 不能小于0")
 	}
 	if config.YesCaptchaClientKey == "" {
 		//logger.SysLog("环境变量 YES_CAPTCHA_CLIENT_KEY 未设置，将无法使用 YesCaptcha 过谷歌验证，导致无法调用文生图模型 \n ClientKey获取地址：https://yescaptcha.com/i/021iAE")
 	}
-	if config.ModelChatMapStr != "" {
-		pattern := `^([a-zA-Z0-9\-\/]+=([a-zA-Z0-9\-\.]+))(,[a-zA-Z0-9\-\/]+=([a-zA-Z0-9\-\.]+))*`
-		match, _ := regexp.MatchString(pattern, config.ModelChatMapStr)
-		if !match {
-			logger.FatalLog("环境变量 MODEL_CHAT_MAP 设置有误")
-		} else {
-			modelChatMap := make(map[string]string)
-			pairs := strings.Split(config.ModelChatMapStr, ",")
-
-			for _, pair := range pairs {
-				kv := strings.Split(pair, "=")
-				if !lo.Contains(common.DefaultOpenaiModelList, kv[0]) {
-					logger.FatalLog("环境变量 MODEL_CHAT_MAP 中 MODEL 有误")
-				}
-				modelChatMap[kv[0]] = kv[1]
-			}
-
-			config.ModelChatMap = modelChatMap
-
-			if config.AutoModelChatMapType == 1 {
-				logger.FatalLog("环境变量 MODEL_CHAT_MAP 有值时,环境变量 AUTO_MODEL_CHAT_MAP_TYPE 不能设置为1")
-			}
-
+	if config.Current().ModelChatMapStr != "" {
+		if len(strings.Split(config.GSCookie, ",")) > 1 || config.GSCookieFile != "" {
+			logger.SysLog("环境变量 MODEL_CHAT_MAP 中的对话仅属于单个账号,配置多个 GS_COOKIE 时建议使用 MODEL_CHAT_PROVISION=1 为每个账号创建专属对话")
 		}
 	}
+	if config.ModelChatProvision == 1 && config.AutoModelChatMapType != 0 {
+		logger.FatalLog("环境变量 MODEL_CHAT_PROVISION=1 时,环境变量 AUTO_MODEL_CHAT_MAP_TYPE 只能设置为0")
+	}
 
 	//if config.SessionImageChatMapStr != "" {
 	//	pattern := `^([a-zA-Z0-9\-\/]+=([a-zA-Z0-9\-\.]+))(,[a-zA-Z0-9\-\/]+=([a-zA-Z0-9\-\.]+))*`
@@ -66,3 +88,127 @@ func CheckEnvVariable() {
 
 	logger.SysLog("environment variable check passed.")
 }
+
+// CheckModelChatMap 校验已保存的专属对话映射,移除cookie已不存在或模型无效的映射
+func CheckModelChatMap() {
+	cookies := config.GetGSCookies()
+	models := config.Current().Models
+	removed := 0
+	for _, modelChat := range config.ModelChats() {
+		if modelChat.Source == config.ModelChatSourceEnv {
+			continue
+		}
+		if !lo.Contains(cookies, modelChat.Cookie) || !models.IsKind(modelChat.Model, config.ModelKindText) || modelChat.ChatID == "" {
+			config.DeleteModelChatByCookieId(modelChat.CookieId, modelChat.Model)
+			removed++
+		}
+	}
+	if removed > 0 {
+		logger.SysLog(fmt.Sprintf("model chat map check removed %d invalid mapping(s)", removed))
+	}
+}
+
+// parseConfig 解析 next 中 JSON 等格式的配置项,启动及重载配置文件时使用
+func parseConfig(next *config.Reloadable) error {
+	registry, err := config.ParseModelRegistry(next.ModelRegistryStr)
+	if err != nil {
+		return fmt.Errorf("环境变量 MODEL_REGISTRY 设置有误: %v", err)
+	}
+	prices, err := config.ParseModelPrices(next.ModelPriceStr)
+	if err != nil {
+		return fmt.Errorf("环境变量 MODEL_PRICE 设置有误: %v", err)
+	}
+	limits, err := config.ParseModelRateLimits(next.ModelRateLimitStr)
+	if err != nil {
+		return fmt.Errorf("环境变量 MODEL_RATE_LIMIT 设置有误: %v", err)
+	}
+	groupModels, err := config.ParseJwtGroupModels(next.JwtGroupModelsStr)
+	if err != nil {
+		return fmt.Errorf("环境变量 JWT_GROUP_MODELS 设置有误: %v", err)
+	}
+	modelChatMap, err := parseModelChatMap(next.ModelChatMapStr, registry)
+	if err != nil {
+		return err
+	}
+	fallbacks, err := config.ParseModelFallbacks(next.ModelFallbackStr, registry)
+	if err != nil {
+		return fmt.Errorf("环境变量 MODEL_FALLBACK 设置有误: %v", err)
+	}
+	fallbackConditions, err := config.ParseFallbackConditions(next.ModelFallbackOn)
+	if err != nil {
+		return fmt.Errorf("环境变量 MODEL_FALLBACK_ON 设置有误: %v", err)
+	}
+	next.Models = registry
+	next.ModelPrices = prices
+	next.ModelRateLimits = limits
+	next.JwtGroupModels = groupModels
+	next.ModelChatMap = modelChatMap
+	next.ModelFallbacks = fallbacks
+	next.ModelFallbackConditions = fallbackConditions
+	return nil
+}
+
+// parseModelChatMap 解析 MODEL_CHAT_MAP
+func parseModelChatMap(s string, registry *config.ModelRegistry) (map[string]string, error) {
+	modelChatMap := make(map[string]string)
+	if s == "" {
+		return modelChatMap, nil
+	}
+	chatIdPattern := regexp.MustCompile(`^[a-zA-Z0-9\-\.]+$`)
+	for _, pair := range strings.Split(s, ",") {
+		kv := strings.Split(strings.TrimSpace(pair), "=")
+		if len(kv) != 2 || !chatIdPattern.MatchString(kv[1]) {
+			return nil, fmt.Errorf("环境变量 MODEL_CHAT_MAP 设置有误: %s", pair)
+		}
+		if !registry.IsKind(kv[0], config.ModelKindText) {
+			return nil, fmt.Errorf("环境变量 MODEL_CHAT_MAP 中 MODEL 有误: %s 不是文本模型", kv[0])
+		}
+		if _, ok := modelChatMap[kv[0]]; ok {
+			return nil, fmt.Errorf("环境变量 MODEL_CHAT_MAP 中 MODEL 重复: %s", kv[0])
+		}
+		modelChatMap[kv[0]] = kv[1]
+	}
+	if config.AutoModelChatMapType != 0 {
+		return nil, errors.New("环境变量 MODEL_CHAT_MAP 有值时,环境变量 AUTO_MODEL_CHAT_MAP_TYPE 只能设置为0")
+	}
+	return modelChatMap, nil
+}
+
+// ReloadConfigFile 重新读取配置文件,更新可热重载的配置项
+// 返回已更新的配置项,以及已修改但需要重启才能生效的配置项
+func ReloadConfigFile() (changed []string, restart []string, err error) {
+	changed, restart, err = config.ReloadConfigFile(func(next *config.Reloadable, changed []string) error {
+		var levels *logger.Levels
+		if lo.Contains(changed, "log_level") {
+			spec := next.LogLevel
+			if config.DebugEnabled {
+				spec = "debug," + spec
+			}
+			l, err := logger.ParseLevels(spec)
+			if err != nil {
+				return fmt.Errorf("log_level: %v", err)
+			}
+			levels = &l
+		}
+		if err := parseConfig(next); err != nil {
+			return err
+		}
+		if levels != nil {
+			if err := logger.SetLevels(*levels); err != nil {
+				return err
+			}
+		}
+		common.SetModelEncoders(next.Models.Encoders())
+		return nil
+	})
+	if err != nil {
+		return nil, restart, err
+	}
+	if len(changed) > 0 {
+		logger.SysLog(fmt.Sprintf("genspark2api reload config file, changed: %s", strings.Join(changed, ",")))
+	}
+	if len(restart) > 0 {
+		logger.SysLog(fmt.Sprintf("genspark2api config %s changed, restart to take effect", strings.Join(restart, ",")))
+	}
+	return changed, restart, nil
+}
@@ -0,0 +1,227 @@
+package alert
+
+import (
+	"fmt"
+	"genspark2api/common/config"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/samber/lo"
+)
+
+// 告警事件
+const (
+	EventNotLogin      = "cookie_not_login"
+	EventFreeLimit     = "cookie_free_limit"
+	EventPoolLow       = "pool_low"
+	EventPoolRecovered = "pool_recovered"
+	EventCloudflare    = "cloudflare_challenge"
+	EventCheatFailure  = "cheat_failure"
+)
+
+var events = []string{EventNotLogin, EventFreeLimit, EventPoolLow, EventCloudflare, EventCheatFailure}
+
+// Event 一次告警
+type Event struct {
+	Type    string            `json:"event"`
+	Title   string            `json:"title"`
+	Message string            `json:"message"`
+	Fields  map[string]string `json:"fields,omitempty"`
+	Time    time.Time         `json:"time"`
+	// Instance 发送告警的副本(主机名)
+	Instance string `json:"instance"`
+	// Suppressed 上次告警后因冷却未发送的相同告警数
+	Suppressed int `json:"suppressed,omitempty"`
+	// key 去重标识,同一事件的不同对象(如不同cookie)分别冷却
+	key string
+}
+
+var (
+	webhooks      []webhook
+	enabledEvents map[string]bool
+	instance      string
+
+	// cooldowns 事件去重标识 -> 冷却状态
+	cooldowns      = make(map[string]*cooldown)
+	cooldownsMutex sync.Mutex
+
+	cloudflareHits  []time.Time
+	cloudflareMutex sync.Mutex
+
+	poolLow      bool
+	poolLowMutex sync.Mutex
+)
+
+type cooldown struct {
+	until      time.Time
+	suppressed int
+}
+
+// Init 解析告警配置,未设置 ALERT_WEBHOOK_URL 时为空操作
+func Init() error {
+	if config.AlertWebhookUrl == "" {
+		return nil
+	}
+	enabled := make(map[string]bool)
+	for _, event := range config.SplitList(config.AlertEvents) {
+		if !lo.Contains(events, event) {
+			return fmt.Errorf("unknown alert event %s, should be one of %v", event, events)
+		}
+		enabled[event] = true
+	}
+	hooks, err := parseWebhooks(config.AlertWebhookUrl, config.AlertWebhookFormat, config.AlertTelegramChatId)
+	if err != nil {
+		return err
+	}
+	instance, _ = os.Hostname()
+	enabledEvents = enabled
+	webhooks = hooks
+	go sendLoop()
+	return nil
+}
+
+// Enabled 是否已配置告警
+func Enabled() bool {
+	return len(webhooks) > 0
+}
+
+// EventEnabled 是否发送该事件的告警
+func EventEnabled(event string) bool {
+	return Enabled() && enabledEvents[event]
+}
+
+// Emit 发送告警,冷却期间的相同告警仅计数,恢复通知随 pool_low 一同开启
+func Emit(event Event) {
+	if !Enabled() || (!enabledEvents[event.Type] && event.Type != EventPoolRecovered) {
+		return
+	}
+	key := event.Type + ":" + event.key
+	now := time.Now()
+
+	cooldownsMutex.Lock()
+	state, ok := cooldowns[key]
+	if ok && now.Before(state.until) {
+		state.suppressed++
+		cooldownsMutex.Unlock()
+		return
+	}
+	if !ok {
+		state = &cooldown{}
+		cooldowns[key] = state
+	}
+	event.Suppressed = state.suppressed
+	state.suppressed = 0
+	state.until = now.Add(time.Duration(config.Current().AlertCooldown) * time.Second)
+	cooldownsMutex.Unlock()
+
+	event.Time = now
+	event.Instance = instance
+	enqueue(event)
+}
+
+// NotLogin cookie未登录(已失效)
+func NotLogin(cookie string) {
+	fingerprint := config.CookieFingerprint(cookie)
+	Emit(Event{
+		Type:    EventNotLogin,
+		Title:   "Cookie 已失效",
+		Message: fmt.Sprintf("cookie %s 未登录,已暂停使用 %d 秒,请更新cookie", fingerprint, config.Current().RateLimitCookieLockDuration),
+		Fields:  map[string]string{"cookie": fingerprint},
+		key:     fingerprint,
+	})
+}
+
+// FreeLimit cookie达到免费额度限制
+func FreeLimit(cookie string, model string) {
+	fingerprint := config.CookieFingerprint(cookie)
+	Emit(Event{
+		Type:    EventFreeLimit,
+		Title:   "Cookie 达到免费额度限制",
+		Message: fmt.Sprintf("cookie %s 请求模型 %s 达到免费额度限制", fingerprint, model),
+		Fields:  map[string]string{"cookie": fingerprint, "model": model},
+		key:     fingerprint + ":" + model,
+	})
+}
+
+// CloudflareChallenge 遇到 Cloudflare 验证,ALERT_CLOUDFLARE_WINDOW 秒内达到 ALERT_CLOUDFLARE_THRESHOLD 次时告警
+func CloudflareChallenge() {
+	cfg := config.Current()
+	if !EventEnabled(EventCloudflare) || cfg.AlertCloudflareThreshold <= 0 {
+		return
+	}
+	now := time.Now()
+	window := time.Duration(cfg.AlertCloudflareWindow) * time.Second
+
+	cloudflareMutex.Lock()
+	cloudflareHits = append(lo.Filter(cloudflareHits, func(t time.Time, _ int) bool {
+		return now.Sub(t) < window
+	}), now)
+	count := len(cloudflareHits)
+	if count < cfg.AlertCloudflareThreshold {
+		cloudflareMutex.Unlock()
+		return
+	}
+	cloudflareHits = nil
+	cloudflareMutex.Unlock()
+
+	Emit(Event{
+		Type:    EventCloudflare,
+		Title:   "频繁遇到 Cloudflare 验证",
+		Message: fmt.Sprintf("%d 秒内遇到 %d 次 Cloudflare 验证,请检查代理或更新cookie", cfg.AlertCloudflareWindow, count),
+		Fields:  map[string]string{"count": fmt.Sprint(count), "window_seconds": fmt.Sprint(cfg.AlertCloudflareWindow)},
+	})
+}
+
+// CheatFailure 请求签名服务失败
+func CheatFailure(err error) {
+	Emit(Event{
+		Type:    EventCheatFailure,
+		Title:   "签名服务请求失败",
+		Message: fmt.Sprintf("请求签名服务 CHEAT_URL 失败: %v", err),
+		Fields:  map[string]string{"error": err.Error()},
+	})
+}
+
+// CheckPool 检查可用cookie数,低于 ALERT_POOL_THRESHOLD 时告警,恢复后发送恢复通知
+func CheckPool() {
+	threshold := config.Current().AlertPoolThreshold
+	if !EventEnabled(EventPoolLow) || threshold <= 0 {
+		return
+	}
+	stats := config.GetCookiePoolStats()
+	fields := map[string]string{
+		"usable":       fmt.Sprint(stats.Usable),
+		"total":        fmt.Sprint(stats.Total),
+		"rate_limited": fmt.Sprint(stats.RateLimited),
+		"not_login":    fmt.Sprint(stats.NotLogin),
+		"threshold":    fmt.Sprint(threshold),
+	}
+
+	poolLowMutex.Lock()
+	defer poolLowMutex.Unlock()
+	if stats.Usable < threshold {
+		poolLow = true
+		Emit(Event{
+			Type:  EventPoolLow,
+			Title: "可用 Cookie 不足",
+			Message: fmt.Sprintf("可用cookie %d 个(低于 %d),共 %d 个,限速 %d 个,失效 %d 个",
+				stats.Usable, threshold, stats.Total, stats.RateLimited, stats.NotLogin),
+			Fields: fields,
+		})
+		return
+	}
+	if poolLow {
+		poolLow = false
+		// 恢复后重新开始冷却,再次不足时立即告警
+		cooldownsMutex.Lock()
+		delete(cooldowns, EventPoolLow+":")
+		cooldownsMutex.Unlock()
+		Emit(Event{
+			Type:    EventPoolRecovered,
+			Title:   "可用 Cookie 已恢复",
+			Message: fmt.Sprintf("可用cookie %d 个,共 %d 个", stats.Usable, stats.Total),
+			Fields:  fields,
+		})
+	}
+}
@@ -0,0 +1,141 @@
+package alert
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"genspark2api/common/config"
+	logger "genspark2api/common/loggger"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// 告警格式
+const (
+	FormatAuto     = "auto"
+	FormatJson     = "json"
+	FormatSlack    = "slack"
+	FormatTelegram = "telegram"
+)
+
+const (
+	sendTimeout = 10 * time.Second
+	// 待发送告警的队列长度,发送过慢时丢弃新的告警
+	queueSize = 100
+)
+
+type webhook struct {
+	url    string
+	format string
+	chatId string
+}
+
+var (
+	queue  = make(chan Event, queueSize)
+	client = &http.Client{Timeout: sendTimeout}
+)
+
+// parseWebhooks 解析 ALERT_WEBHOOK_URL,format 为 auto 时按地址识别格式
+func parseWebhooks(urls string, format string, chatId string) ([]webhook, error) {
+	format = strings.ToLower(strings.TrimSpace(format))
+	if format == "" {
+		format = FormatAuto
+	}
+	if format != FormatAuto && format != FormatJson && format != FormatSlack && format != FormatTelegram {
+		return nil, fmt.Errorf("unknown alert webhook format %s, should be auto, json, slack or telegram", format)
+	}
+	var hooks []webhook
+	for _, raw := range config.SplitList(urls) {
+		u, err := url.Parse(raw)
+		if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+			return nil, errors.New("invalid alert webhook url, should be http or https url")
+		}
+		hook := webhook{url: raw, format: format, chatId: chatId}
+		if format == FormatAuto {
+			switch {
+			case u.Host == "hooks.slack.com":
+				hook.format = FormatSlack
+			case u.Host == "api.telegram.org":
+				hook.format = FormatTelegram
+			default:
+				hook.format = FormatJson
+			}
+		}
+		if hook.format == FormatTelegram && chatId == "" {
+			return nil, errors.New("ALERT_TELEGRAM_CHAT_ID is required for telegram webhook")
+		}
+		hooks = append(hooks, hook)
+	}
+	return hooks, nil
+}
+
+func enqueue(event Event) {
+	select {
+	case queue <- event:
+	default:
+		logger.SysError(fmt.Sprintf("genspark2api alert queue is full, drop alert %s", event.Type))
+	}
+}
+
+func sendLoop() {
+	for event := range queue {
+		for i, hook := range webhooks {
+			if err := hook.send(event); err != nil {
+				logger.SysError(fmt.Sprintf("genspark2api send alert %s to webhook #%d (%s) err: %v", event.Type, i+1, hook.format, err))
+			}
+		}
+	}
+}
+
+func (h webhook) send(event Event) error {
+	var payload interface{}
+	switch h.format {
+	case FormatSlack:
+		payload = map[string]string{"text": formatText(event, "*", "*")}
+	case FormatTelegram:
+		payload = map[string]string{"chat_id": h.chatId, "text": formatText(event, "", "")}
+	default:
+		payload = event
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Post(h.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		// 错误信息中的地址可能包含令牌(如 Telegram bot token),仅保留错误原因
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			return urlErr.Err
+		}
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// formatText Slack 及 Telegram 的告警文本,标题使用 open/close 包裹
+func formatText(event Event, open, close string) string {
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("%s[genspark2api] %s%s\n%s", open, event.Title, close, event.Message))
+	keys := make([]string, 0, len(event.Fields))
+	for key := range event.Fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		sb.WriteString(fmt.Sprintf("\n• %s: %s", key, event.Fields[key]))
+	}
+	if event.Suppressed > 0 {
+		sb.WriteString(fmt.Sprintf("\n(上次告警后另有 %d 次相同告警)", event.Suppressed))
+	}
+	sb.WriteString(fmt.Sprintf("\n%s %s", event.Instance, event.Time.Format(time.DateTime)))
+	return sb.String()
+}
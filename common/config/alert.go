@@ -0,0 +1,15 @@
+package config
+
+import "genspark2api/common/env"
+
+// 告警 Webhook 地址(多个以,分隔),为空时不发送告警
+var AlertWebhookUrl = env.String("ALERT_WEBHOOK_URL", "")
+
+// 告警格式 auto/json/slack/telegram,auto 按地址识别 Slack 及 Telegram,其它地址使用 json
+var AlertWebhookFormat = env.String("ALERT_WEBHOOK_FORMAT", "auto")
+
+// Telegram 告警发送到的 chat_id
+var AlertTelegramChatId = env.String("ALERT_TELEGRAM_CHAT_ID", "")
+
+// 发送告警的事件(多个以,分隔)
+var AlertEvents = env.String("ALERT_EVENTS", "cookie_not_login,cookie_free_limit,pool_low,cloudflare_challenge,cheat_failure")
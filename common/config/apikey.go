@@ -0,0 +1,319 @@
+package config
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// apiKeyPrefix 生成的 API Key 前缀
+	apiKeyPrefix = "sk-"
+	apiKeyLength = 48
+	apiKeyChars  = "0123456789abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ"
+
+	// 月度用量计数器保留时间,覆盖当月即可
+	tenantTokenCounterTTL = 62 * 24 * time.Hour
+)
+
+// 内置租户
+const (
+	// TenantDefault 使用环境变量 API_SECRET 鉴权的请求
+	TenantDefault = "default"
+	// TenantAnonymous 未设置 API_SECRET 时的请求
+	TenantAnonymous = "anonymous"
+)
+
+// ApiKey 租户的 API Key,仅保存 Key 的哈希
+type ApiKey struct {
+	ID        string `json:"id"`
+	Name      string `json:"name"`
+	KeyPrefix string `json:"key_prefix"`
+	Enabled   bool   `json:"enabled"`
+	// ExpiresAt 为空表示永不过期
+	ExpiresAt *time.Time `json:"expires_at"`
+	// AllowedModels 为空表示允许全部模型
+	AllowedModels []string `json:"allowed_models"`
+	// MaxConcurrency 最大并发请求数,0 表示不限制
+	MaxConcurrency int `json:"max_concurrency"`
+	// MonthlyTokenQuota 每月 token 额度,0 表示不限制
+	MonthlyTokenQuota int64 `json:"monthly_token_quota"`
+	// RPM/TPM 每分钟请求数/token数限制,MaxStreams 最大同时进行的流式请求数,为0时使用环境变量中的默认值
+	RPM        int `json:"rpm"`
+	TPM        int `json:"tpm"`
+	MaxStreams int `json:"max_streams"`
+	// DisableDialogRecord 不记录该 Key 的对话
+	DisableDialogRecord bool      `json:"disable_dialog_record"`
+	CreatedAt           time.Time `json:"created_at"`
+	UpdatedAt           time.Time `json:"updated_at"`
+
+	hash string
+}
+
+// Tenant 请求方身份,由鉴权中间件写入请求上下文
+type Tenant struct {
+	ID                string   `json:"id"`
+	Name              string   `json:"name"`
+	AllowedModels     []string `json:"allowed_models"`
+	MaxConcurrency    int      `json:"max_concurrency"`
+	MonthlyTokenQuota int64    `json:"monthly_token_quota"`
+	RPM               int      `json:"rpm"`
+	TPM               int      `json:"tpm"`
+	MaxStreams        int      `json:"max_streams"`
+	// DisableDialogRecord 不记录该租户的对话
+	DisableDialogRecord bool `json:"disable_dialog_record"`
+	// Groups JWT 中的用户组
+	Groups []string `json:"groups,omitempty"`
+	// RateLimitKey 限速使用的标识,为空时使用 ID
+	RateLimitKey string `json:"-"`
+}
+
+// Tenant API Key 对应的租户
+func (k *ApiKey) Tenant() *Tenant {
+	return &Tenant{
+		ID:                  k.ID,
+		Name:                k.Name,
+		AllowedModels:       k.AllowedModels,
+		MaxConcurrency:      k.MaxConcurrency,
+		MonthlyTokenQuota:   k.MonthlyTokenQuota,
+		RPM:                 k.RPM,
+		TPM:                 k.TPM,
+		MaxStreams:          k.MaxStreams,
+		DisableDialogRecord: k.DisableDialogRecord,
+	}
+}
+
+// Validate 校验 API Key 是否可用
+func (k *ApiKey) Validate() error {
+	if !k.Enabled {
+		return fmt.Errorf("api key %s is disabled", k.Name)
+	}
+	if k.ExpiresAt != nil && !k.ExpiresAt.After(time.Now()) {
+		return fmt.Errorf("api key %s expired at %s", k.Name, k.ExpiresAt.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// AllowsModel 判断租户是否可以请求指定模型,联网模型与其基础模型视为同一模型
+func (t *Tenant) AllowsModel(model string) bool {
+	if len(t.AllowedModels) == 0 {
+		return true
+	}
+	for _, m := range t.AllowedModels {
+		if m == model || m == NormalizeEntitlementModel(model) {
+			return true
+		}
+	}
+	return false
+}
+
+// QuotaExceeded 判断租户本月 token 用量是否已超出额度
+func (t *Tenant) QuotaExceeded() bool {
+	return t.MonthlyTokenQuota > 0 && TenantMonthlyTokens(t.ID) >= t.MonthlyTokenQuota
+}
+
+// HashApiKey API Key 的哈希,用于存储及查找
+func HashApiKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// ErrApiKeyNotPersistent 未配置持久化存储时不允许创建 API Key,避免重启后 Key 丢失导致客户端无法访问
+var ErrApiKeyNotPersistent = errors.New("未配置 STATE_DB_PATH 或 REDIS_CONN_STRING,API Key 仅保存在内存中,重启后丢失,请配置持久化存储后再创建")
+
+// CreateApiKey 创建 API Key,返回明文 Key(仅此一次)
+func CreateApiKey(apiKey *ApiKey) (string, error) {
+	if !StatePersistent() {
+		return "", ErrApiKeyNotPersistent
+	}
+	key, err := randomString(apiKeyLength)
+	if err != nil {
+		return "", err
+	}
+	key = apiKeyPrefix + key
+	id, err := randomString(12)
+	if err != nil {
+		return "", err
+	}
+
+	apiKey.ID = id
+	apiKey.KeyPrefix = key[:len(apiKeyPrefix)+4]
+	apiKey.CreatedAt = time.Now()
+	apiKey.hash = HashApiKey(key)
+	if err := SaveApiKey(apiKey); err != nil {
+		return "", err
+	}
+	return key, nil
+}
+
+// SaveApiKey 保存 API Key
+func SaveApiKey(apiKey *ApiKey) error {
+	apiKey.UpdatedAt = time.Now()
+	bytes, err := json.Marshal(apiKey)
+	if err != nil {
+		return err
+	}
+	StateStore.SetApiKey(apiKey.hash, string(bytes))
+	return nil
+}
+
+// LookupApiKey 根据明文 Key 查找 API Key
+func LookupApiKey(key string) (*ApiKey, bool) {
+	if key == "" {
+		return nil, false
+	}
+	hash := HashApiKey(key)
+	value, ok := StateStore.GetApiKey(hash)
+	if !ok {
+		return nil, false
+	}
+	return parseApiKey(hash, value)
+}
+
+// GetApiKey 根据ID查找 API Key
+func GetApiKey(id string) (*ApiKey, bool) {
+	for _, apiKey := range ListApiKeys() {
+		if apiKey.ID == id {
+			return apiKey, true
+		}
+	}
+	return nil, false
+}
+
+// DeleteApiKey 删除 API Key
+func DeleteApiKey(id string) bool {
+	apiKey, ok := GetApiKey(id)
+	if !ok {
+		return false
+	}
+	StateStore.DeleteApiKey(apiKey.hash)
+	return true
+}
+
+// ListApiKeys 获取全部 API Key,按创建时间排序
+func ListApiKeys() []*ApiKey {
+	var apiKeys []*ApiKey
+	for hash, value := range StateStore.ApiKeys() {
+		if apiKey, ok := parseApiKey(hash, value); ok {
+			apiKeys = append(apiKeys, apiKey)
+		}
+	}
+	sort.Slice(apiKeys, func(i, j int) bool {
+		return apiKeys[i].CreatedAt.Before(apiKeys[j].CreatedAt)
+	})
+	return apiKeys
+}
+
+func parseApiKey(hash, value string) (*ApiKey, bool) {
+	var apiKey ApiKey
+	if err := json.Unmarshal([]byte(value), &apiKey); err != nil {
+		return nil, false
+	}
+	apiKey.hash = hash
+	return &apiKey, true
+}
+
+func randomString(length int) (string, error) {
+	bytes := make([]byte, length)
+	max := big.NewInt(int64(len(apiKeyChars)))
+	for i := range bytes {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		bytes[i] = apiKeyChars[n.Int64()]
+	}
+	return string(bytes), nil
+}
+
+func tenantTokenCounterKey(tenantId string, t time.Time) string {
+	return fmt.Sprintf("tenant_tokens:%s:%s", tenantId, t.Format("2006-01"))
+}
+
+// TenantMonthlyTokens 租户本月已使用的 token 数
+func TenantMonthlyTokens(tenantId string) int64 {
+	return StateStore.GetCounter(tenantTokenCounterKey(tenantId, time.Now()))
+}
+
+// AddTenantTokens 累计租户本月使用的 token 数
+func AddTenantTokens(tenantId string, tokens int) {
+	if tokens <= 0 {
+		return
+	}
+	StateStore.IncrCounter(tenantTokenCounterKey(tenantId, time.Now()), int64(tokens), tenantTokenCounterTTL)
+}
+
+var (
+	tenantConcurrency = newSlotCounter()
+	tenantStreams     = newSlotCounter()
+)
+
+// AcquireTenantSlot 占用租户的并发名额(当前副本),超出 MaxConcurrency 时返回 false
+// 成功时返回释放名额的函数,请求结束后调用
+func AcquireTenantSlot(tenant *Tenant) (release func(), ok bool) {
+	return tenantConcurrency.acquire(tenant.ID, tenant.MaxConcurrency)
+}
+
+// AcquireTenantStream 占用租户的流式请求名额(当前副本),超出 maxStreams 时返回 false
+// 成功时返回释放名额的函数,请求结束后调用
+func AcquireTenantStream(tenant *Tenant, maxStreams int) (release func(), ok bool) {
+	return tenantStreams.acquire(tenant.rateLimitKey(), maxStreams)
+}
+
+// TenantSlotStats 当前副本各租户进行中的请求数及流式请求数
+func TenantSlotStats() (concurrency map[string]int, streams map[string]int) {
+	return tenantConcurrency.snapshot(), tenantStreams.snapshot()
+}
+
+// slotCounter 按标识计数的并发名额
+type slotCounter struct {
+	counts map[string]int
+	mutex  sync.Mutex
+}
+
+func newSlotCounter() *slotCounter {
+	return &slotCounter{counts: make(map[string]int)}
+}
+
+// acquire 占用名额,max 为0时不限制;返回的 release 按占用时的结果释放,不受之后限制变化的影响
+func (s *slotCounter) acquire(key string, max int) (release func(), ok bool) {
+	if max <= 0 {
+		return func() {}, true
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.counts[key] >= max {
+		return nil, false
+	}
+	s.counts[key]++
+	return func() { s.release(key) }, true
+}
+
+func (s *slotCounter) release(key string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.counts[key] <= 1 {
+		delete(s.counts, key)
+		return
+	}
+	s.counts[key]--
+}
+
+func (s *slotCounter) snapshot() map[string]int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	counts := make(map[string]int, len(s.counts))
+	for key, count := range s.counts {
+		if count > 0 {
+			counts[key] = count
+		}
+	}
+	return counts
+}
@@ -3,6 +3,7 @@ package config
 import (
 	"errors"
 	"genspark2api/common/env"
+	"genspark2api/common/state"
 	"genspark2api/yescaptcha"
 	"math/rand"
 	"os"
@@ -11,6 +12,9 @@ import (
 	"time"
 )
 
+// 监听端口,为0时使用启动参数 --port
+var Port = env.Int("PORT", 0)
+
 var ApiSecret = os.Getenv("API_SECRET")
 var ApiSecrets = strings.Split(os.Getenv("API_SECRET"), ",")
 
@@ -18,64 +22,98 @@ var GSCookie = os.Getenv("GS_COOKIE")
 
 //var GSCookies = strings.Split(os.Getenv("GS_COOKIE"), ",")
 
-// var IpBlackList = os.Getenv("IP_BLACK_LIST")
-var IpBlackList = strings.Split(os.Getenv("IP_BLACK_LIST"), ",")
-
-var AutoDelChat = env.Int("AUTO_DEL_CHAT", 0)
 var ProxyUrl = env.String("PROXY_URL", "")
 var AutoModelChatMapType = env.Int("AUTO_MODEL_CHAT_MAP_TYPE", 1)
 var YesCaptchaClientKey = env.String("YES_CAPTCHA_CLIENT_KEY", "")
 var CheatUrl = env.String("CHEAT_URL", "https://gs-cheat.aytsao.cn/genspark/create/req/body")
 
-// 隐藏思考过程
-var ReasoningHide = env.Int("REASONING_HIDE", 0)
+// 开启 Prometheus 指标接口 /metrics [0:关闭,1:开启]
+var MetricsEnable = env.Int("METRICS_ENABLE", 0)
 
-var RateLimitCookieLockDuration = env.Int("RATE_LIMIT_COOKIE_LOCK_DURATION", 10*60)
+// 开启 OpenTelemetry 链路追踪 [0:关闭,1:开启]
+var TraceEnable = env.Int("TRACE_ENABLE", 0)
 
 // 路由前缀
 var RoutePrefix = env.String("ROUTE_PREFIX", "")
-var ModelChatMapStr = env.String("MODEL_CHAT_MAP", "")
-var ModelChatMap = make(map[string]string)
 var SessionImageChatMap = make(map[string]string)
 var GlobalSessionManager *SessionManager
 
 var SessionImageChatMapStr = env.String("SESSION_IMAGE_CHAT_MAP", "")
 var YescaptchaClient *yescaptcha.Client
 
-var AllDialogRecordEnable = os.Getenv("ALL_DIALOG_RECORD_ENABLE")
-var RequestOutTime = os.Getenv("REQUEST_OUT_TIME")
-var StreamRequestOutTime = os.Getenv("STREAM_REQUEST_OUT_TIME")
-var SwaggerEnable = os.Getenv("SWAGGER_ENABLE")
-var OnlyOpenaiApi = os.Getenv("ONLY_OPENAI_API")
-
 var DebugEnabled = os.Getenv("DEBUG") == "true"
 
+// 日志格式 text/json
+var LogFormat = env.String("LOG_FORMAT", "text")
+
+// 日志文件目录,与启动参数 --log-dir 相同,启动参数优先
+var LogDir = env.String("LOG_DIR", "")
+
+// 单个日志文件的最大大小(MB),超出后轮转
+var LogMaxSize = env.Int("LOG_MAX_SIZE", 100)
+
+// 按时间轮转日志文件的间隔(小时),从每天0点起计算,0 表示仅按大小轮转
+var LogRotateInterval = env.Int("LOG_ROTATE_INTERVAL", 24)
+
+// 保留的轮转日志文件数,0 表示不限制
+var LogMaxBackups = env.Int("LOG_MAX_BACKUPS", 10)
+
+// 轮转日志文件的保留天数,0 表示不限制
+var LogMaxAge = env.Int("LOG_MAX_AGE", 30)
+
+// 压缩轮转后的日志文件 [0:关闭,1:开启]
+var LogCompress = env.Int("LOG_COMPRESS", 1)
+
+// 访问日志单独写入 genspark2api-access.log [0:关闭,1:开启]
+var LogSplitAccess = env.Int("LOG_SPLIT_ACCESS", 0)
+
 var RateLimitKeyExpirationDuration = 20 * time.Minute
 
-var RequestOutTimeDuration = 5 * time.Minute
+var RequestRateLimitDuration int64 = 1 * 60
 
-var (
-	RequestRateLimitNum            = env.Int("REQUEST_RATE_LIMIT", 60)
-	RequestRateLimitDuration int64 = 1 * 60
-)
+// Redis 连接串,配置后限速cookie、会话映射及请求限速在多个副本间共享
+var RedisConnString = env.String("REDIS_CONN_STRING", "")
+
+// 本地持久化文件,未配置 Redis 时会话映射保存在此文件中,均未配置时保存在内存中
+var StateDbPath = env.String("STATE_DB_PATH", "")
 
-type RateLimitCookie struct {
-	ExpirationTime time.Time // 过期时间
+// StateStore 限速cookie、会话映射及请求限速的存储,默认为内存
+var StateStore state.Store = state.NewMemoryStore(RateLimitKeyExpirationDuration)
+
+// InitStateStore 配置了 REDIS_CONN_STRING 时使用 Redis 存储,配置了 STATE_DB_PATH 时使用本地文件存储,否则使用内存存储
+func InitStateStore() error {
+	if RedisConnString != "" {
+		store, err := state.NewRedisStore(RedisConnString)
+		if err != nil {
+			return err
+		}
+		StateStore = store
+		return nil
+	}
+	if StateDbPath == "" {
+		return nil
+	}
+
+	store, err := state.NewBoltStore(StateDbPath, RateLimitKeyExpirationDuration)
+	if err != nil {
+		return err
+	}
+	StateStore = store
+	return nil
 }
 
-var (
-	rateLimitCookies sync.Map // 使用 sync.Map 管理限速 Cookie
-)
+// StatePersistent 是否配置了持久化存储(Redis 或本地文件),未配置时数据保存在内存中,重启后丢失
+func StatePersistent() bool {
+	return RedisConnString != "" || StateDbPath != ""
+}
 
 func AddRateLimitCookie(cookie string, expirationTime time.Time) {
-	rateLimitCookies.Store(cookie, RateLimitCookie{
-		ExpirationTime: expirationTime,
-	})
-	//fmt.Printf("Storing cookie: %s with value: %+v\n", cookie, RateLimitCookie{ExpirationTime: expirationTime})
+	StateStore.LockCookie(cookie, expirationTime)
 }
 
 type CookieManager struct {
 	Cookies      []string
+	Model        string
 	currentIndex int
 	mu           sync.Mutex
 }
@@ -85,25 +123,23 @@ var (
 	cookiesMutex sync.Mutex // 保护 GSCookies 的互斥锁
 )
 
-// InitGSCookies 初始化 GSCookies
-func InitGSCookies() {
+// InitGSCookies 初始化 GSCookies,读取环境变量 GS_COOKIE 及 GS_COOKIE_FILE
+func InitGSCookies() error {
+	sources, err := loadCookieSources()
+	if err != nil {
+		return err
+	}
+
 	cookiesMutex.Lock()
 	defer cookiesMutex.Unlock()
 
 	GSCookies = []string{}
-
-	// 从环境变量中读取 GS_COOKIE 并拆分为切片
-	cookieStr := os.Getenv("GS_COOKIE")
-	if cookieStr != "" {
-
-		for _, cookie := range strings.Split(cookieStr, ",") {
-			// 如果 cookie 不包含 "session_id="，则添加前缀
-			if !strings.Contains(cookie, "session_id=") {
-				cookie = "session_id=" + cookie
-			}
-			GSCookies = append(GSCookies, cookie)
-		}
+	for _, source := range sources {
+		// cookie 后缀中的模型权限,如 "session_id=xxx|plus"
+		SetCookieEntitlement(source.Cookie, source.Entitlement)
+		GSCookies = append(GSCookies, source.Cookie)
 	}
+	return nil
 }
 
 // RemoveCookie 删除指定的 cookie（支持并发）
@@ -113,7 +149,7 @@ func RemoveCookie(cookieToRemove string) {
 
 	// 创建一个新的切片，过滤掉需要删除的 cookie
 	var newCookies []string
-	for _, cookie := range GetGSCookies() {
+	for _, cookie := range GSCookies {
 		if cookie != cookieToRemove {
 			newCookies = append(newCookies, cookie)
 		}
@@ -125,8 +161,8 @@ func RemoveCookie(cookieToRemove string) {
 
 // GetGSCookies 获取 GSCookies 的副本
 func GetGSCookies() []string {
-	//cookiesMutex.Lock()
-	//defer cookiesMutex.Unlock()
+	cookiesMutex.Lock()
+	defer cookiesMutex.Unlock()
 
 	// 返回 GSCookies 的副本，避免外部直接修改
 	cookiesCopy := make([]string, len(GSCookies))
@@ -134,8 +170,8 @@ func GetGSCookies() []string {
 	return cookiesCopy
 }
 
-// NewCookieManager 创建 CookieManager
-func NewCookieManager() *CookieManager {
+// NewCookieManager 创建 CookieManager,仅包含有权限请求 model 的 cookie(model 为空时不做限制)
+func NewCookieManager(model string) *CookieManager {
 	var validCookies []string
 	// 遍历 GSCookies
 	for _, cookie := range GetGSCookies() {
@@ -144,19 +180,14 @@ func NewCookieManager() *CookieManager {
 			continue // 忽略空字符串
 		}
 
-		// 检查是否在 RateLimitCookies 中
-		if value, ok := rateLimitCookies.Load(cookie); ok {
-			rateLimitCookie, ok := value.(RateLimitCookie) // 正确转换为 RateLimitCookie
-			if !ok {
-				continue
-			}
-			if rateLimitCookie.ExpirationTime.After(time.Now()) {
-				// 如果未过期，忽略该 cookie
-				continue
-			} else {
-				// 如果已过期，从 RateLimitCookies 中删除
-				rateLimitCookies.Delete(cookie)
-			}
+		// 检查是否已失效或处于限速中
+		if !IsCookieUsable(cookie) {
+			continue
+		}
+
+		// 检查是否有该模型的权限
+		if !IsCookieEligible(cookie, model) {
+			continue
 		}
 
 		// 添加到有效 cookie 列表
@@ -165,16 +196,14 @@ func NewCookieManager() *CookieManager {
 
 	return &CookieManager{
 		Cookies:      validCookies,
+		Model:        model,
 		currentIndex: 0,
 	}
 }
 
 func IsRateLimited(cookie string) bool {
-	if value, ok := rateLimitCookies.Load(cookie); ok {
-		rateLimitCookie := value.(RateLimitCookie)
-		return rateLimitCookie.ExpirationTime.After(time.Now())
-	}
-	return false
+	_, locked := StateStore.CookieLockedUntil(cookie)
+	return locked
 }
 
 func (cm *CookieManager) RemoveCookie(cookieToRemove string) error {
@@ -210,16 +239,23 @@ func (cm *CookieManager) RemoveCookie(cookieToRemove string) error {
 	return nil
 }
 
+// GetNextCookie 获取下一个可用且有模型权限的 cookie
 func (cm *CookieManager) GetNextCookie() (string, error) {
 	cm.mu.Lock()
 	defer cm.mu.Unlock()
 
 	if len(cm.Cookies) == 0 {
-		return "", errors.New("no cookies available")
+		return "", noEligibleCookieError(cm.Model)
 	}
 
-	cm.currentIndex = (cm.currentIndex + 1) % len(cm.Cookies)
-	return cm.Cookies[cm.currentIndex], nil
+	for i := 0; i < len(cm.Cookies); i++ {
+		cm.currentIndex = (cm.currentIndex + 1) % len(cm.Cookies)
+		cookie := cm.Cookies[cm.currentIndex]
+		if IsCookieUsable(cookie) && IsCookieEligible(cookie, cm.Model) {
+			return cookie, nil
+		}
+	}
+	return "", noEligibleCookieError(cm.Model)
 }
 
 func (cm *CookieManager) GetRandomCookie() (string, error) {
@@ -227,7 +263,7 @@ func (cm *CookieManager) GetRandomCookie() (string, error) {
 	defer cm.mu.Unlock()
 
 	if len(cm.Cookies) == 0 {
-		return "", errors.New("no cookies available")
+		return "", noEligibleCookieError(cm.Model)
 	}
 
 	// 生成随机索引
@@ -238,76 +274,6 @@ func (cm *CookieManager) GetRandomCookie() (string, error) {
 	return cm.Cookies[randomIndex], nil
 }
 
-// SessionKey 定义复合键结构
-type SessionKey struct {
-	Cookie string
-	Model  string
-}
-
-// SessionManager 会话管理器
-type SessionManager struct {
-	sessions map[SessionKey]string
-	mutex    sync.RWMutex
-}
-
-// NewSessionManager 创建新的会话管理器
-func NewSessionManager() *SessionManager {
-	return &SessionManager{
-		sessions: make(map[SessionKey]string),
-	}
-}
-
-// AddSession 添加会话记录（写操作，需要写锁）
-func (sm *SessionManager) AddSession(cookie string, model string, chatID string) {
-	sm.mutex.Lock()
-	defer sm.mutex.Unlock()
-
-	key := SessionKey{
-		Cookie: cookie,
-		Model:  model,
-	}
-	sm.sessions[key] = chatID
-}
-
-// GetChatID 获取会话ID（读操作，使用读锁）
-func (sm *SessionManager) GetChatID(cookie string, model string) (string, bool) {
-	sm.mutex.RLock()
-	defer sm.mutex.RUnlock()
-
-	key := SessionKey{
-		Cookie: cookie,
-		Model:  model,
-	}
-	chatID, exists := sm.sessions[key]
-	return chatID, exists
-}
-
-// DeleteSession 删除会话记录（写操作，需要写锁）
-func (sm *SessionManager) DeleteSession(cookie string, model string) {
-	sm.mutex.Lock()
-	defer sm.mutex.Unlock()
-
-	key := SessionKey{
-		Cookie: cookie,
-		Model:  model,
-	}
-	delete(sm.sessions, key)
-}
-
-// GetChatIDsByCookie 获取指定cookie关联的所有chatID列表(读操作,使用读锁)
-func (sm *SessionManager) GetChatIDsByCookie(cookie string) []string {
-	sm.mutex.RLock()
-	defer sm.mutex.RUnlock()
-
-	var chatIDs []string
-	for key, chatID := range sm.sessions {
-		if key.Cookie == cookie {
-			chatIDs = append(chatIDs, chatID)
-		}
-	}
-	return chatIDs
-}
-
 type SessionMapManager struct {
 	sessionMap   map[string]string
 	keys         []string
@@ -0,0 +1,225 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"genspark2api/common/env"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// cookie文件或目录,修改后自动重载cookie池
+var GSCookieFile = env.String("GS_COOKIE_FILE", "")
+
+// cookieFileEntry JSON格式的cookie文件条目
+type cookieFileEntry struct {
+	Cookie   string   `json:"cookie"`
+	Tier     string   `json:"tier"`
+	Models   []string `json:"models"`
+	Disabled bool     `json:"disabled"`
+	Note     string   `json:"note"`
+}
+
+// cookieSource 解析后的cookie及其模型权限
+type cookieSource struct {
+	Cookie      string
+	Entitlement CookieEntitlement
+}
+
+// loadCookieSources 读取环境变量 GS_COOKIE 及 GS_COOKIE_FILE 中的cookie,按出现顺序去重
+func loadCookieSources() ([]cookieSource, error) {
+	var sources []cookieSource
+	if GSCookie != "" {
+		for _, raw := range strings.Split(GSCookie, ",") {
+			sources = append(sources, newCookieSource(parseCookieEntitlement(raw)))
+		}
+	}
+	if GSCookieFile != "" {
+		fileSources, err := loadCookieFile(GSCookieFile)
+		if err != nil {
+			return nil, err
+		}
+		sources = append(sources, fileSources...)
+	}
+
+	seen := make(map[string]bool)
+	var result []cookieSource
+	for _, source := range sources {
+		if source.Cookie == "session_id=" || seen[source.Cookie] {
+			continue
+		}
+		seen[source.Cookie] = true
+		result = append(result, source)
+	}
+	return result, nil
+}
+
+// loadCookieFile 读取cookie文件,path 为目录时读取其中全部文件(忽略隐藏文件)
+func loadCookieFile(path string) ([]cookieSource, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return parseCookieFile(path)
+	}
+
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, entry := range entries {
+		if entry.IsDir() || !IsCookieFileName(entry.Name()) {
+			continue
+		}
+		names = append(names, entry.Name())
+	}
+	sort.Strings(names)
+
+	var sources []cookieSource
+	for _, name := range names {
+		fileSources, err := parseCookieFile(filepath.Join(path, name))
+		if err != nil {
+			return nil, err
+		}
+		sources = append(sources, fileSources...)
+	}
+	return sources, nil
+}
+
+// IsCookieFileName 判断目录中的文件是否为cookie文件,忽略隐藏文件及编辑器临时文件
+func IsCookieFileName(name string) bool {
+	return !strings.HasPrefix(name, ".") && !strings.HasSuffix(name, "~") &&
+		!strings.HasSuffix(name, ".swp") && !strings.HasSuffix(name, ".tmp")
+}
+
+// parseCookieFile 解析单个cookie文件
+// .json 文件为字符串数组或对象数组 [{"cookie":"...","tier":"plus","models":["gpt-4o"],"disabled":false,"note":"..."}]
+// 其它文件每行一个cookie,支持与 GS_COOKIE 相同的权限后缀,# 开头的行为注释
+func parseCookieFile(path string) ([]cookieSource, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var sources []cookieSource
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		var raw []json.RawMessage
+		if err := json.Unmarshal(data, &raw); err != nil {
+			return nil, fmt.Errorf("parse cookie file %s: %v", path, err)
+		}
+		for i, item := range raw {
+			var cookie string
+			if err := json.Unmarshal(item, &cookie); err == nil {
+				sources = append(sources, newCookieSource(parseCookieEntitlement(cookie)))
+				continue
+			}
+			var entry cookieFileEntry
+			if err := json.Unmarshal(item, &entry); err != nil {
+				return nil, fmt.Errorf("parse cookie file %s item %d: %v", path, i, err)
+			}
+			if entry.Disabled || strings.TrimSpace(entry.Cookie) == "" {
+				continue
+			}
+			tier := strings.ToLower(strings.TrimSpace(entry.Tier))
+			if tier != "" && tier != CookieTierFree && tier != CookieTierPlus {
+				return nil, fmt.Errorf("parse cookie file %s item %d: unknown tier %s", path, i, entry.Tier)
+			}
+			sources = append(sources, newCookieSource(strings.TrimSpace(entry.Cookie), CookieEntitlement{
+				Tier:          tier,
+				AllowedModels: entry.Models,
+			}))
+		}
+		return sources, nil
+	}
+
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		sources = append(sources, newCookieSource(parseCookieEntitlement(line)))
+	}
+	return sources, nil
+}
+
+func newCookieSource(cookie string, entitlement CookieEntitlement) cookieSource {
+	// 如果 cookie 不包含 "session_id="，则添加前缀
+	if !strings.Contains(cookie, "session_id=") {
+		cookie = "session_id=" + cookie
+	}
+	return cookieSource{Cookie: cookie, Entitlement: entitlement}
+}
+
+// cookiesByFingerprint 当前cookie池中 cookie 指纹 -> cookie
+// 状态存储中只保存 cookie 的指纹,使用时从cookie池中查找
+func cookiesByFingerprint() map[string]string {
+	cookies := GetGSCookies()
+	fingerprints := make(map[string]string, len(cookies))
+	for _, cookie := range cookies {
+		fingerprints[CookieFingerprint(cookie)] = cookie
+	}
+	return fingerprints
+}
+
+// cookieByFingerprint 按指纹从cookie池中查找cookie
+func cookieByFingerprint(fingerprint string) (string, bool) {
+	if fingerprint == "" {
+		return "", false
+	}
+	for _, cookie := range GetGSCookies() {
+		if CookieFingerprint(cookie) == fingerprint {
+			return cookie, true
+		}
+	}
+	return "", false
+}
+
+// ReloadGSCookies 重新读取cookie并整体替换cookie池,返回新增及移除的cookie
+// 读取失败或结果为空时保留原cookie池;进行中的请求使用各自的cookie副本,不受影响
+func ReloadGSCookies() (added []string, removed []string, err error) {
+	sources, err := loadCookieSources()
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(sources) == 0 {
+		return nil, nil, fmt.Errorf("no cookies found, keep current cookies")
+	}
+
+	cookiesMutex.Lock()
+	defer cookiesMutex.Unlock()
+
+	current := make(map[string]bool, len(GSCookies))
+	for _, cookie := range GSCookies {
+		current[cookie] = true
+	}
+
+	cookies := make([]string, 0, len(sources))
+	next := make(map[string]bool, len(sources))
+	for _, source := range sources {
+		SetCookieEntitlement(source.Cookie, source.Entitlement)
+		cookies = append(cookies, source.Cookie)
+		next[source.Cookie] = true
+		if !current[source.Cookie] {
+			added = append(added, source.Cookie)
+		}
+	}
+	for _, cookie := range GSCookies {
+		if !next[cookie] {
+			removed = append(removed, cookie)
+			SetCookieEntitlement(cookie, CookieEntitlement{})
+			StateStore.ClearCookieNotLogin(cookie)
+			GlobalProxyPool.Release(cookie)
+		}
+	}
+	// 移除的cookie无法继续使用绑定的对话
+	if GlobalSessionManager != nil {
+		GlobalSessionManager.DeleteSessionsByCookies(removed)
+	}
+
+	GSCookies = cookies
+	return added, removed, nil
+}
@@ -0,0 +1,32 @@
+package config
+
+import (
+	"genspark2api/common/env"
+	"github.com/samber/lo"
+)
+
+// 记录对话 0:关闭 1:开启
+var AllDialogRecordEnable = env.Int("ALL_DIALOG_RECORD_ENABLE", 0)
+
+// 对话记录目录,记录为 JSONL 格式,当前文件为 dialog.jsonl
+var DialogRecordDir = env.String("DIALOG_RECORD_DIR", "dialogs")
+
+// 单个对话记录文件的最大大小(MB),超出后轮转,此外每天0点轮转
+var DialogRecordMaxSize = env.Int("DIALOG_RECORD_MAX_SIZE", 100)
+
+// 对话记录保留天数,0 表示永久保留
+var DialogRecordRetentionDays = env.Int("DIALOG_RECORD_RETENTION_DAYS", 90)
+
+// 不记录对话的租户(多个以,分隔),如 default、jwt:alice 或 API Key ID
+var DialogRecordSkipKeys = env.String("DIALOG_RECORD_SKIP_KEYS", "")
+
+// 对话记录中隐藏的个人信息 email,phone,id_card,bank_card,none 表示不隐藏
+var DialogRecordRedact = env.String("DIALOG_RECORD_REDACT", "email,phone,id_card,bank_card")
+
+// 自定义隐藏规则(JSON),名称到正则表达式,如 {"order_no":"ORD\\d{10}"},匹配的内容替换为 [名称]
+var DialogRecordRedactPatterns = env.String("DIALOG_RECORD_REDACT_PATTERNS", "")
+
+// DialogRecordEnabled 是否记录租户的对话
+func (t *Tenant) DialogRecordEnabled() bool {
+	return AllDialogRecordEnable == 1 && !t.DisableDialogRecord && !lo.Contains(SplitList(DialogRecordSkipKeys), t.ID)
+}
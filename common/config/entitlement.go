@@ -0,0 +1,146 @@
+package config
+
+import (
+	"fmt"
+	"genspark2api/common/env"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 账号等级
+const (
+	CookieTierFree = "free"
+	CookieTierPlus = "plus"
+)
+
+// 仅Plus账号可用的模型(多个以,分隔)
+var PremiumModels = env.String("PREMIUM_MODELS", "o1,o3-mini-high,flux-pro/ultra,imagen3")
+
+// PremiumModelList 仅Plus账号可用的模型
+var PremiumModelList = splitAndTrim(PremiumModels)
+
+// CookieEntitlement cookie可使用的模型范围
+// Tier 为空且 AllowedModels 为空时表示未知,默认允许所有模型,由上游响应自动探测
+type CookieEntitlement struct {
+	Tier          string
+	AllowedModels []string
+}
+
+var (
+	cookieEntitlements sync.Map // cookie -> CookieEntitlement
+)
+
+// parseCookieEntitlement 解析 cookie 后缀中的权限声明
+// 支持 "cookie|plus"、"cookie|free" 及 "cookie|gpt-4o;claude-3-5-sonnet"
+func parseCookieEntitlement(raw string) (string, CookieEntitlement) {
+	index := strings.LastIndex(raw, "|")
+	if index == -1 {
+		return strings.TrimSpace(raw), CookieEntitlement{}
+	}
+
+	cookie := strings.TrimSpace(raw[:index])
+	spec := strings.TrimSpace(raw[index+1:])
+
+	switch strings.ToLower(spec) {
+	case "":
+		return cookie, CookieEntitlement{}
+	case CookieTierFree, CookieTierPlus:
+		return cookie, CookieEntitlement{Tier: strings.ToLower(spec)}
+	}
+
+	return cookie, CookieEntitlement{AllowedModels: splitAndTrim(strings.ReplaceAll(spec, ";", ","))}
+}
+
+func SetCookieEntitlement(cookie string, entitlement CookieEntitlement) {
+	if entitlement.Tier == "" && len(entitlement.AllowedModels) == 0 {
+		cookieEntitlements.Delete(cookie)
+		return
+	}
+	cookieEntitlements.Store(cookie, entitlement)
+}
+
+func GetCookieEntitlement(cookie string) CookieEntitlement {
+	if value, ok := cookieEntitlements.Load(cookie); ok {
+		return value.(CookieEntitlement)
+	}
+	return CookieEntitlement{}
+}
+
+// NormalizeEntitlementModel 去掉联网后缀,统一模型名
+func NormalizeEntitlementModel(model string) string {
+	return strings.TrimSuffix(model, "-search")
+}
+
+func IsPremiumModel(model string) bool {
+	model = NormalizeEntitlementModel(model)
+	for _, m := range PremiumModelList {
+		if m == model {
+			return true
+		}
+	}
+	return false
+}
+
+// IsCookieEligible 判断cookie是否可以请求指定模型
+func IsCookieEligible(cookie string, model string) bool {
+	model = NormalizeEntitlementModel(model)
+	if model == "" {
+		return true
+	}
+
+	if _, denied := StateStore.CookieModelDeniedUntil(deniedCookieModelKey(cookie, model)); denied {
+		return false
+	}
+
+	entitlement := GetCookieEntitlement(cookie)
+	if len(entitlement.AllowedModels) > 0 {
+		for _, m := range entitlement.AllowedModels {
+			if m == model {
+				return true
+			}
+		}
+		return false
+	}
+	if entitlement.Tier == CookieTierFree {
+		return !IsPremiumModel(model)
+	}
+	return true
+}
+
+// DenyCookieModel 记录cookie无该模型权限(上游自动探测)
+func DenyCookieModel(cookie string, model string, expirationTime time.Time) {
+	StateStore.DenyCookieModel(deniedCookieModelKey(cookie, NormalizeEntitlementModel(model)), expirationTime)
+}
+
+// MarkFreeLimit 处理上游返回的免费额度限制
+// 高级模型仅禁用该cookie的对应模型,其它模型仍禁用整个cookie
+func MarkFreeLimit(cookie string, model string) {
+	if IsPremiumModel(model) {
+		DenyCookieModel(cookie, model, time.Now().Add(time.Duration(Current().ModelDenyLockDuration)*time.Second))
+		return
+	}
+	AddRateLimitCookie(cookie, time.Now().Add(24*60*60*time.Second))
+}
+
+func deniedCookieModelKey(cookie string, model string) string {
+	return cookie + "|" + model
+}
+
+func noEligibleCookieError(model string) error {
+	if model == "" {
+		return fmt.Errorf("no cookies available")
+	}
+	return fmt.Errorf("no cookie eligible for model %s", NormalizeEntitlementModel(model))
+}
+
+func splitAndTrim(s string) []string {
+	var result []string
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			result = append(result, item)
+		}
+	}
+	return result
+}
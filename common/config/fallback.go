@@ -0,0 +1,91 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/samber/lo"
+)
+
+// 降级条件
+const (
+	// FallbackPoolExhausted 没有可请求该模型的cookie
+	FallbackPoolExhausted = "pool_exhausted"
+	// FallbackOverload Genspark 服务不可用或服务器错误
+	FallbackOverload = "overload"
+)
+
+var fallbackConditions = []string{FallbackPoolExhausted, FallbackOverload}
+
+// ParseModelFallbacks 解析 MODEL_FALLBACK,模型别名转换为 id
+func ParseModelFallbacks(s string, registry *ModelRegistry) (map[string][]string, error) {
+	fallbacks := make(map[string][]string)
+	if strings.TrimSpace(s) == "" {
+		return fallbacks, nil
+	}
+	var chains map[string][]string
+	if err := json.Unmarshal([]byte(s), &chains); err != nil {
+		return nil, err
+	}
+	var errs []error
+	for name, chain := range chains {
+		from, ok := registry.Lookup(name)
+		if !ok || strings.HasSuffix(name, SearchModelSuffix) || from.Kind == ModelKindImage {
+			errs = append(errs, fmt.Errorf("%s is not a text model", name))
+			continue
+		}
+		if _, ok := fallbacks[from.Id]; ok {
+			errs = append(errs, fmt.Errorf("fallback of model %s is duplicated", from.Id))
+			continue
+		}
+		var ids []string
+		for _, target := range chain {
+			to, ok := registry.Lookup(target)
+			if !ok || strings.HasSuffix(target, SearchModelSuffix) || to.Kind == ModelKindImage {
+				errs = append(errs, fmt.Errorf("fallback %s of model %s is not a text model", target, from.Id))
+				continue
+			}
+			if to.Id == from.Id || lo.Contains(ids, to.Id) {
+				errs = append(errs, fmt.Errorf("fallback %s of model %s is duplicated", target, from.Id))
+				continue
+			}
+			ids = append(ids, to.Id)
+		}
+		fallbacks[from.Id] = ids
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	return fallbacks, nil
+}
+
+// ParseFallbackConditions 解析 MODEL_FALLBACK_ON
+func ParseFallbackConditions(s string) ([]string, error) {
+	conditions := SplitList(s)
+	for _, condition := range conditions {
+		if !lo.Contains(fallbackConditions, condition) {
+			return nil, fmt.Errorf("unknown fallback condition %s, should be one of %v", condition, fallbackConditions)
+		}
+	}
+	return conditions, nil
+}
+
+// GetModelFallbacks 模型的降级链,联网模型(-search)使用其基础模型的降级链,支持联网的降级模型同样联网
+func GetModelFallbacks(model string) []string {
+	r := Current()
+	base := strings.TrimSuffix(model, SearchModelSuffix)
+	chain := r.ModelFallbacks[base]
+	if base == model {
+		return chain
+	}
+	fallbacks := make([]string, 0, len(chain))
+	for _, id := range chain {
+		if m, ok := r.Models.Lookup(id); ok && m.Search {
+			id += SearchModelSuffix
+		}
+		fallbacks = append(fallbacks, id)
+	}
+	return fallbacks
+}
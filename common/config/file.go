@@ -0,0 +1,539 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"genspark2api/common/env"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+
+	"gopkg.in/yaml.v3"
+)
+
+// 配置文件(YAML),键名为环境变量名的小写形式,环境变量优先于配置文件
+var ConfigFile = env.String("CONFIG_FILE", "")
+
+// 配置项的来源
+const (
+	SourceDefault = "default"
+	SourceFile    = "file"
+	SourceEnv     = "env"
+)
+
+// 隐藏敏感配置项时的替换值
+const secretMask = "******"
+
+type settingKind int
+
+const (
+	kindString settingKind = iota
+	kindInt
+	// kindSwitch 0/1 开关,配置文件中也可使用 true/false
+	kindSwitch
+	kindBool
+	// kindList 以,分隔的列表,配置文件中也可使用数组
+	kindList
+	// kindJson JSON,配置文件中也可直接使用对象或数组
+	kindJson
+)
+
+// setting 一个配置项,value 指向对应的全局变量(*string/*int/*bool)
+type setting struct {
+	env  string
+	kind settingKind
+	// value 指向配置项对应的全局变量,仅在启动时写入
+	value interface{}
+	// field 可热重载的配置项在 Reloadable 中的字段名,修改配置文件后无需重启即生效
+	field string
+	// secret 输出配置时隐藏
+	secret bool
+	check  func(value interface{}) error
+
+	source string
+	// initial 未使用配置文件时的值(环境变量或默认值),配置文件中删除该项后恢复
+	initial interface{}
+}
+
+func (s *setting) key() string {
+	return strings.ToLower(s.env)
+}
+
+func (s *setting) reload() bool {
+	return s.field != ""
+}
+
+// ptr 配置项的指针,可热重载的配置项指向 r 中的字段
+func (s *setting) ptr(r *Reloadable) interface{} {
+	if s.reload() {
+		return reflect.ValueOf(r).Elem().FieldByName(s.field).Addr().Interface()
+	}
+	return s.value
+}
+
+func (s *setting) get(r *Reloadable) interface{} {
+	return reflect.ValueOf(s.ptr(r)).Elem().Interface()
+}
+
+func (s *setting) set(r *Reloadable, value interface{}) {
+	reflect.ValueOf(s.ptr(r)).Elem().Set(reflect.ValueOf(value))
+}
+
+// settings 全部可通过环境变量或配置文件设置的配置项
+var settings = []*setting{
+	{env: "PORT", value: &Port, check: between(0, 65535)},
+	{env: "DEBUG", kind: kindBool, value: &DebugEnabled},
+	{env: "API_SECRET", kind: kindList, value: &ApiSecret, secret: true},
+	{env: "GS_COOKIE", kind: kindList, value: &GSCookie, secret: true},
+	{env: "GS_COOKIE_FILE", value: &GSCookieFile},
+	{env: "AUTO_DEL_CHAT", kind: kindSwitch, field: "AutoDelChat"},
+	{env: "REQUEST_RATE_LIMIT", field: "RequestRateLimitNum", check: between(0, -1)},
+	{env: "PROXY_URL", kind: kindList, value: &ProxyUrl, secret: true},
+	{env: "PROXY_UNHEALTHY_DURATION", value: &ProxyUnhealthyDuration, check: between(0, -1)},
+	{env: "AUTO_MODEL_CHAT_MAP_TYPE", value: &AutoModelChatMapType, check: oneOf(0, 1, 2)},
+	{env: "MODEL_CHAT_MAP", kind: kindList, field: "ModelChatMapStr"},
+	{env: "MODEL_CHAT_PROVISION", kind: kindSwitch, value: &ModelChatProvision},
+	{env: "MODEL_CHAT_PROVISION_INTERVAL", value: &ModelChatProvisionInterval, check: between(0, -1)},
+	{env: "MODEL_REGISTRY", kind: kindJson, field: "ModelRegistryStr"},
+	{env: "MODEL_FALLBACK", kind: kindJson, field: "ModelFallbackStr"},
+	{env: "MODEL_FALLBACK_ON", kind: kindList, field: "ModelFallbackOn"},
+	{env: "PREMIUM_MODELS", kind: kindList, value: &PremiumModels},
+	{env: "MODEL_DENY_LOCK_DURATION", field: "ModelDenyLockDuration", check: between(0, -1)},
+	{env: "RATE_LIMIT_COOKIE_LOCK_DURATION", field: "RateLimitCookieLockDuration", check: between(0, -1)},
+	{env: "YES_CAPTCHA_CLIENT_KEY", value: &YesCaptchaClientKey, secret: true},
+	{env: "CHEAT_URL", value: &CheatUrl},
+	{env: "ROUTE_PREFIX", value: &RoutePrefix},
+	{env: "REASONING_HIDE", kind: kindSwitch, field: "ReasoningHide"},
+	{env: "TRANSCRIPT_MODE", kind: kindSwitch, field: "TranscriptMode"},
+	{env: "TRANSCRIPT_MAX_TOKENS", field: "TranscriptMaxTokens", check: between(1, -1)},
+	{env: "TRANSCRIPT_CONDENSE", kind: kindSwitch, field: "TranscriptCondense"},
+	{env: "REQUEST_OUT_TIME", field: "RequestOutTime", check: between(1, -1)},
+	{env: "STREAM_REQUEST_OUT_TIME", field: "StreamRequestOutTime", check: between(1, -1)},
+	{env: "SESSION_IMAGE_CHAT_MAP", kind: kindList, value: &SessionImageChatMapStr},
+	{env: "REDIS_CONN_STRING", value: &RedisConnString, secret: true},
+	{env: "STATE_DB_PATH", value: &StateDbPath},
+	{env: "SESSION_TTL", value: &SessionTTL, check: between(0, -1)},
+	{env: "SESSION_MAX_ENTRIES", value: &SessionMaxEntries, check: between(0, -1)},
+	{env: "SESSION_EVICT_DEL_CHAT", kind: kindSwitch, value: &SessionEvictDelChat},
+	{env: "PROJECT_CLEANUP_ENABLE", kind: kindSwitch, value: &ProjectCleanupEnable},
+	{env: "PROJECT_CLEANUP_INTERVAL", value: &ProjectCleanupInterval, check: between(0, -1)},
+	{env: "PROJECT_CLEANUP_TTL", value: &ProjectCleanupTTL, check: between(0, -1)},
+	{env: "PROJECT_ORPHAN_GRACE_PERIOD", value: &ProjectOrphanGracePeriod, check: between(0, -1)},
+	{env: "PROJECT_CLEANUP_RETRIES", value: &ProjectCleanupRetries, check: between(0, -1)},
+	{env: "PROJECT_CLEANUP_RATE_INTERVAL", value: &ProjectCleanupRateInterval, check: between(0, -1)},
+	{env: "USAGE_LEDGER_ENABLE", kind: kindSwitch, value: &UsageLedgerEnable},
+	{env: "USAGE_RETENTION_DAYS", value: &UsageRetentionDays, check: between(0, -1)},
+	{env: "MODEL_PRICE", kind: kindJson, field: "ModelPriceStr"},
+	{env: "IP_BLACK_LIST", kind: kindList, value: &IpBlackList},
+	{env: "IP_WHITE_LIST", kind: kindList, value: &IpWhiteList},
+	{env: "IP_ROUTE_RULES", kind: kindJson, value: &IpRouteRules},
+	{env: "IP_LIST_FILE", value: &IpListFile},
+	{env: "TRUSTED_PROXIES", kind: kindList, value: &TrustedProxies},
+	{env: "REAL_IP_HEADER", kind: kindList, value: &RealIpHeader},
+	{env: "KEY_RATE_LIMIT_RPM", field: "KeyRateLimitRPM", check: between(0, -1)},
+	{env: "KEY_RATE_LIMIT_TPM", field: "KeyRateLimitTPM", check: between(0, -1)},
+	{env: "KEY_MAX_STREAMS", field: "KeyMaxStreams", check: between(0, -1)},
+	{env: "MODEL_RATE_LIMIT", kind: kindJson, field: "ModelRateLimitStr"},
+	{env: "JWT_JWKS", value: &JwtJwks},
+	{env: "JWT_ISSUER", value: &JwtIssuer},
+	{env: "JWT_AUDIENCE", kind: kindList, value: &JwtAudience},
+	{env: "JWT_TENANT_CLAIM", value: &JwtTenantClaim},
+	{env: "JWT_GROUPS_CLAIM", value: &JwtGroupsClaim},
+	{env: "JWT_GROUP_MODELS", kind: kindJson, field: "JwtGroupModelsStr"},
+	{env: "JWT_JWKS_REFRESH_INTERVAL", value: &JwtJwksRefreshInterval, check: between(0, -1)},
+	{env: "JWT_LEEWAY", value: &JwtLeeway, check: between(0, -1)},
+	{env: "METRICS_ENABLE", kind: kindSwitch, value: &MetricsEnable},
+	{env: "TRACE_ENABLE", kind: kindSwitch, value: &TraceEnable},
+	{env: "LOG_FORMAT", value: &LogFormat, check: oneOf("text", "json")},
+	{env: "LOG_LEVEL", kind: kindList, field: "LogLevel"},
+	{env: "LOG_DIR", value: &LogDir},
+	{env: "LOG_MAX_SIZE", value: &LogMaxSize, check: between(1, -1)},
+	{env: "LOG_ROTATE_INTERVAL", value: &LogRotateInterval, check: between(0, -1)},
+	{env: "LOG_MAX_BACKUPS", value: &LogMaxBackups, check: between(0, -1)},
+	{env: "LOG_MAX_AGE", value: &LogMaxAge, check: between(0, -1)},
+	{env: "LOG_COMPRESS", kind: kindSwitch, value: &LogCompress},
+	{env: "LOG_SPLIT_ACCESS", kind: kindSwitch, value: &LogSplitAccess},
+	{env: "ALL_DIALOG_RECORD_ENABLE", kind: kindSwitch, value: &AllDialogRecordEnable},
+	{env: "DIALOG_RECORD_DIR", value: &DialogRecordDir},
+	{env: "DIALOG_RECORD_MAX_SIZE", value: &DialogRecordMaxSize, check: between(1, -1)},
+	{env: "DIALOG_RECORD_RETENTION_DAYS", value: &DialogRecordRetentionDays, check: between(0, -1)},
+	{env: "DIALOG_RECORD_SKIP_KEYS", kind: kindList, value: &DialogRecordSkipKeys},
+	{env: "DIALOG_RECORD_REDACT", kind: kindList, value: &DialogRecordRedact},
+	{env: "DIALOG_RECORD_REDACT_PATTERNS", kind: kindJson, value: &DialogRecordRedactPatterns},
+	{env: "ALERT_WEBHOOK_URL", kind: kindList, value: &AlertWebhookUrl, secret: true},
+	{env: "ALERT_WEBHOOK_FORMAT", value: &AlertWebhookFormat, check: oneOf("auto", "json", "slack", "telegram")},
+	{env: "ALERT_TELEGRAM_CHAT_ID", value: &AlertTelegramChatId},
+	{env: "ALERT_EVENTS", kind: kindList, value: &AlertEvents},
+	{env: "ALERT_COOLDOWN", field: "AlertCooldown", check: between(0, -1)},
+	{env: "ALERT_POOL_THRESHOLD", field: "AlertPoolThreshold", check: between(0, -1)},
+	{env: "ALERT_CLOUDFLARE_THRESHOLD", field: "AlertCloudflareThreshold", check: between(0, -1)},
+	{env: "ALERT_CLOUDFLARE_WINDOW", field: "AlertCloudflareWindow", check: between(1, -1)},
+}
+
+func init() {
+	// 未指定类型时按全局变量的类型推断
+	for _, s := range settings {
+		if s.reload() && !reflect.ValueOf(&Reloadable{}).Elem().FieldByName(s.field).IsValid() {
+			panic("unknown reloadable field " + s.field)
+		}
+		if _, ok := s.ptr(&Reloadable{}).(*int); ok && s.kind == kindString {
+			s.kind = kindInt
+		}
+	}
+}
+
+// between 整数需在 [min, max] 之间,max 为 -1 时不限制上限
+func between(min, max int) func(value interface{}) error {
+	return func(value interface{}) error {
+		n := value.(int)
+		if n < min || (max >= 0 && n > max) {
+			if max < 0 {
+				return fmt.Errorf("需大于等于%d,当前为%d", min, n)
+			}
+			return fmt.Errorf("需在%d到%d之间,当前为%d", min, max, n)
+		}
+		return nil
+	}
+}
+
+// oneOf 值需为 values 之一
+func oneOf[T comparable](values ...T) func(value interface{}) error {
+	return func(value interface{}) error {
+		for _, v := range values {
+			if value.(T) == v {
+				return nil
+			}
+		}
+		return fmt.Errorf("需为%v之一,当前为%v", values, value)
+	}
+}
+
+// configMutex 保护配置文件的加载及重载
+var configMutex sync.Mutex
+
+// LoadConfigFile 读取 CONFIG_FILE,未设置环境变量的配置项使用配置文件中的值,并校验全部配置项
+func LoadConfigFile() error {
+	configMutex.Lock()
+	defer configMutex.Unlock()
+
+	next := Current().clone()
+	var errs []error
+	for _, s := range settings {
+		s.initial = s.get(next)
+		s.source = SourceDefault
+		if raw := os.Getenv(s.env); raw != "" {
+			s.source = SourceEnv
+			// env.Int 解析失败时使用默认值,此处给出明确的错误
+			if err := checkEnvValue(s, raw); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+
+	if ConfigFile != "" {
+		values, err := readConfigFile(ConfigFile)
+		if err != nil {
+			return err
+		}
+		for _, s := range settings {
+			if v, ok := values[s.key()]; ok && s.source != SourceEnv {
+				s.set(next, v)
+				s.source = SourceFile
+			}
+		}
+	}
+	refreshDerived()
+	if err := validateSettings(next); err != nil {
+		return err
+	}
+	current.Store(next)
+	return nil
+}
+
+func checkEnvValue(s *setting, raw string) error {
+	switch s.kind {
+	case kindInt, kindSwitch:
+		if _, err := strconv.Atoi(raw); err != nil {
+			return fmt.Errorf("环境变量 %s=%q 需为整数", s.env, raw)
+		}
+	case kindBool:
+		if raw != "true" && raw != "false" {
+			return fmt.Errorf("环境变量 %s=%q 需为 true 或 false", s.env, raw)
+		}
+	}
+	return nil
+}
+
+// refreshDerived 更新由配置项计算得到的全局变量
+func refreshDerived() {
+	ApiSecrets = strings.Split(ApiSecret, ",")
+	PremiumModelList = splitAndTrim(PremiumModels)
+	GlobalProxyPool = NewProxyPool(splitAndTrim(ProxyUrl))
+}
+
+// validateSettings 校验全部配置项,返回全部错误
+func validateSettings(r *Reloadable) error {
+	var errs []error
+	for _, s := range settings {
+		if err := checkSetting(s, s.get(r)); err != nil {
+			errs = append(errs, fmt.Errorf("%s(来自%s): %v", s.key(), sourceName(s.source), err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func checkSetting(s *setting, value interface{}) error {
+	if s.kind == kindSwitch {
+		if err := oneOf(0, 1)(value); err != nil {
+			return err
+		}
+	}
+	if s.kind == kindJson && strings.TrimSpace(value.(string)) != "" && !json.Valid([]byte(value.(string))) {
+		return errors.New("不是有效的JSON")
+	}
+	if s.check != nil {
+		return s.check(value)
+	}
+	return nil
+}
+
+func sourceName(source string) string {
+	switch source {
+	case SourceEnv:
+		return "环境变量"
+	case SourceFile:
+		return "配置文件 " + ConfigFile
+	default:
+		return "默认值"
+	}
+}
+
+// readConfigFile 读取配置文件,按配置项的类型转换,未知的配置项及类型错误时返回带行号的错误
+func readConfigFile(path string) (map[string]interface{}, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("配置文件 %s 格式有误: %v", path, err)
+	}
+	values := make(map[string]interface{})
+	if len(doc.Content) == 0 {
+		return values, nil
+	}
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("配置文件 %s 第%d行: 需为 key: value 格式", path, root.Line)
+	}
+
+	byKey := make(map[string]*setting, len(settings))
+	for _, s := range settings {
+		byKey[s.key()] = s
+	}
+	var errs []error
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		keyNode, valueNode := root.Content[i], root.Content[i+1]
+		key := strings.ToLower(keyNode.Value)
+		s, ok := byKey[key]
+		if !ok {
+			errs = append(errs, fmt.Errorf("配置文件 %s 第%d行: 未知的配置项 %s", path, keyNode.Line, keyNode.Value))
+			continue
+		}
+		value, err := decodeValue(s, valueNode)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("配置文件 %s 第%d行: %s %v", path, valueNode.Line, key, err))
+			continue
+		}
+		values[key] = value
+	}
+	return values, errors.Join(errs...)
+}
+
+// decodeValue 按配置项的类型转换配置文件中的值
+func decodeValue(s *setting, node *yaml.Node) (interface{}, error) {
+	switch s.kind {
+	case kindInt, kindSwitch:
+		if node.Kind != yaml.ScalarNode {
+			return nil, errors.New("需为整数")
+		}
+		if s.kind == kindSwitch && node.ShortTag() == "!!bool" {
+			var b bool
+			if err := node.Decode(&b); err != nil {
+				return nil, err
+			}
+			if b {
+				return 1, nil
+			}
+			return 0, nil
+		}
+		var n int
+		if node.ShortTag() != "!!int" || node.Decode(&n) != nil {
+			return nil, fmt.Errorf("需为整数,当前为 %s", node.Value)
+		}
+		return n, nil
+	case kindBool:
+		var b bool
+		if node.Kind != yaml.ScalarNode || node.ShortTag() != "!!bool" || node.Decode(&b) != nil {
+			return nil, fmt.Errorf("需为 true 或 false,当前为 %s", node.Value)
+		}
+		return b, nil
+	case kindList:
+		if node.Kind == yaml.SequenceNode {
+			items := make([]string, 0, len(node.Content))
+			for _, item := range node.Content {
+				if item.Kind != yaml.ScalarNode {
+					return nil, errors.New("需为字符串数组或以,分隔的字符串")
+				}
+				items = append(items, item.Value)
+			}
+			return strings.Join(items, ","), nil
+		}
+		if node.Kind != yaml.ScalarNode {
+			return nil, errors.New("需为字符串数组或以,分隔的字符串")
+		}
+		return node.Value, nil
+	case kindJson:
+		if node.Kind == yaml.ScalarNode {
+			return node.Value, nil
+		}
+		var value interface{}
+		if err := node.Decode(&value); err != nil {
+			return nil, err
+		}
+		data, err := json.Marshal(value)
+		if err != nil {
+			return nil, err
+		}
+		return string(data), nil
+	default:
+		if node.Kind != yaml.ScalarNode {
+			return nil, errors.New("需为字符串")
+		}
+		return node.Value, nil
+	}
+}
+
+// ReloadConfigFile 重新读取配置文件,仅更新可热重载且未由环境变量设置的配置项
+// apply 根据变更的配置项更新 next 中解析得到的配置(如重新解析 MODEL_RATE_LIMIT),返回错误时不发布 next
+// 返回已更新的配置项,以及已修改但需要重启才能生效的配置项
+func ReloadConfigFile(apply func(next *Reloadable, changed []string) error) (changed []string, restart []string, err error) {
+	configMutex.Lock()
+	defer configMutex.Unlock()
+
+	if ConfigFile == "" {
+		return nil, nil, errors.New("未设置配置文件 CONFIG_FILE")
+	}
+	values, err := readConfigFile(ConfigFile)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	type update struct {
+		setting *setting
+		value   interface{}
+		source  string
+	}
+	next := Current().clone()
+	var updates []update
+	var errs []error
+	for _, s := range settings {
+		if s.source == SourceEnv {
+			continue
+		}
+		value, source := s.initial, SourceDefault
+		if v, ok := values[s.key()]; ok {
+			value, source = v, SourceFile
+		}
+		if value == s.get(next) {
+			continue
+		}
+		if !s.reload() {
+			restart = append(restart, s.key())
+			continue
+		}
+		if err := checkSetting(s, value); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %v", s.key(), err))
+			continue
+		}
+		updates = append(updates, update{setting: s, value: value, source: source})
+	}
+	if len(errs) > 0 {
+		return nil, restart, errors.Join(errs...)
+	}
+
+	if len(updates) == 0 {
+		return nil, restart, nil
+	}
+	for _, u := range updates {
+		u.setting.set(next, u.value)
+		changed = append(changed, u.setting.key())
+	}
+	if apply != nil {
+		if err := apply(next, changed); err != nil {
+			return nil, restart, err
+		}
+	}
+	current.Store(next)
+	for _, u := range updates {
+		u.setting.source = u.source
+	}
+	return changed, restart, nil
+}
+
+// ConfigItem 配置项及其当前值,敏感配置项的值已隐藏
+type ConfigItem struct {
+	Key    string      `json:"key"`
+	Env    string      `json:"env"`
+	Value  interface{} `json:"value"`
+	Source string      `json:"source"`
+	Reload bool        `json:"reload"`
+}
+
+// ConfigItems 全部配置项的当前值
+func ConfigItems() []ConfigItem {
+	configMutex.Lock()
+	defer configMutex.Unlock()
+
+	r := Current()
+	items := make([]ConfigItem, 0, len(settings))
+	for _, s := range settings {
+		value := s.get(r)
+		if s.secret && value != "" {
+			value = secretMask
+		}
+		source := s.source
+		if source == "" {
+			source = SourceDefault
+		}
+		items = append(items, ConfigItem{Key: s.key(), Env: s.env, Value: value, Source: source, Reload: s.reload()})
+	}
+	return items
+}
+
+// PrintConfig 以配置文件格式输出当前配置,敏感配置项的值已隐藏,注释为配置项的来源
+func PrintConfig() (string, error) {
+	root := &yaml.Node{Kind: yaml.MappingNode}
+	for _, item := range ConfigItems() {
+		value := &yaml.Node{}
+		if err := value.Encode(item.Value); err != nil {
+			return "", err
+		}
+		value.LineComment = item.Source
+		if item.Reload {
+			value.LineComment += ", reload"
+		}
+		root.Content = append(root.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: item.Key}, value)
+	}
+	data, err := yaml.Marshal(root)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
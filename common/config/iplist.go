@@ -0,0 +1,185 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"genspark2api/common/env"
+	"net/netip"
+	"os"
+	"strings"
+	"sync/atomic"
+)
+
+// IP黑名单,支持IP、CIDR及IPv6(多个请以,分隔)
+var IpBlackList = env.String("IP_BLACK_LIST", "")
+
+// IP白名单,设置后仅允许名单中的IP访问(多个请以,分隔)
+var IpWhiteList = env.String("IP_WHITE_LIST", "")
+
+// 按路由设置的IP规则(JSON),如 [{"path":"/api","allow":["10.0.0.0/8"]}]
+var IpRouteRules = env.String("IP_ROUTE_RULES", "")
+
+// IP规则文件(JSON),与环境变量中的规则合并,修改后自动重载
+var IpListFile = env.String("IP_LIST_FILE", "")
+
+// 信任的反向代理(多个请以,分隔),仅信任来自这些地址的请求中的真实IP请求头,为空时与旧版本相同信任任意来源
+var TrustedProxies = env.String("TRUSTED_PROXIES", "")
+
+// 获取客户端真实IP的请求头(多个请以,分隔)
+var RealIpHeader = env.String("REAL_IP_HEADER", "X-Forwarded-For,X-Real-IP")
+
+// IPRule IP规则,Path 为空时对全部路由生效,否则对该路径及其子路径生效
+// 命中 Deny 的请求被拒绝;Allow 不为空时仅允许命中 Allow 的请求
+type IPRule struct {
+	Path  string   `json:"path,omitempty"`
+	Allow []string `json:"allow,omitempty"`
+	Deny  []string `json:"deny,omitempty"`
+
+	allow []netip.Prefix
+	deny  []netip.Prefix
+}
+
+// IPPolicy 全局及按路由的IP规则,同时也是 IP_LIST_FILE 的文件格式
+type IPPolicy struct {
+	Allow  []string  `json:"allow"`
+	Deny   []string  `json:"deny"`
+	Routes []*IPRule `json:"routes"`
+}
+
+// ipRules 生效的IP规则,第一条为全局规则
+type ipRules struct {
+	policy *IPPolicy
+	rules  []*IPRule
+}
+
+var currentIPRules atomic.Pointer[ipRules]
+
+// ReloadIPPolicy 重新读取环境变量及 IP_LIST_FILE 中的IP规则,读取失败时保留原规则
+func ReloadIPPolicy() error {
+	policy := &IPPolicy{
+		Allow: SplitList(IpWhiteList),
+		Deny:  SplitList(IpBlackList),
+	}
+	if strings.TrimSpace(IpRouteRules) != "" {
+		if err := json.Unmarshal([]byte(IpRouteRules), &policy.Routes); err != nil {
+			return fmt.Errorf("parse IP_ROUTE_RULES: %v", err)
+		}
+	}
+	if IpListFile != "" {
+		data, err := os.ReadFile(IpListFile)
+		if err != nil {
+			return err
+		}
+		var filePolicy IPPolicy
+		if err := json.Unmarshal(data, &filePolicy); err != nil {
+			return fmt.Errorf("parse ip list file %s: %v", IpListFile, err)
+		}
+		policy.Allow = append(policy.Allow, filePolicy.Allow...)
+		policy.Deny = append(policy.Deny, filePolicy.Deny...)
+		policy.Routes = append(policy.Routes, filePolicy.Routes...)
+	}
+
+	rules := append([]*IPRule{{Allow: policy.Allow, Deny: policy.Deny}}, policy.Routes...)
+	for _, rule := range rules {
+		var err error
+		if rule.allow, err = parsePrefixes(rule.Allow); err != nil {
+			return err
+		}
+		if rule.deny, err = parsePrefixes(rule.Deny); err != nil {
+			return err
+		}
+	}
+	currentIPRules.Store(&ipRules{policy: policy, rules: rules})
+	return nil
+}
+
+// GetIPPolicy 当前生效的IP规则
+func GetIPPolicy() *IPPolicy {
+	if current := currentIPRules.Load(); current != nil {
+		return current.policy
+	}
+	return &IPPolicy{}
+}
+
+// AllowIP 判断客户端IP是否可以访问该路径
+func AllowIP(path string, ip string) bool {
+	current := currentIPRules.Load()
+	if current == nil {
+		return true
+	}
+	addr, err := netip.ParseAddr(ip)
+	if err == nil {
+		addr = addr.Unmap()
+	}
+	for _, rule := range current.rules {
+		if rule.matchPath(path) && !rule.allows(addr) {
+			return false
+		}
+	}
+	return true
+}
+
+func (r *IPRule) matchPath(path string) bool {
+	prefix := strings.TrimSuffix(r.Path, "/")
+	return prefix == "" || path == prefix || strings.HasPrefix(path, prefix+"/")
+}
+
+// allows 无法解析的IP不命中任何规则
+func (r *IPRule) allows(addr netip.Addr) bool {
+	if containsAddr(r.deny, addr) {
+		return false
+	}
+	return len(r.allow) == 0 || containsAddr(r.allow, addr)
+}
+
+func containsAddr(prefixes []netip.Prefix, addr netip.Addr) bool {
+	if !addr.IsValid() {
+		return false
+	}
+	for _, prefix := range prefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// parsePrefixes 解析IP或CIDR,单个IP视为 /32 或 /128
+func parsePrefixes(items []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(items))
+	for _, item := range items {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		if strings.Contains(item, "/") {
+			prefix, err := netip.ParsePrefix(item)
+			if err != nil {
+				return nil, fmt.Errorf("invalid CIDR %s: %v", item, err)
+			}
+			if prefix.Addr().Is4In6() && prefix.Bits() >= 96 {
+				prefix = netip.PrefixFrom(prefix.Addr().Unmap(), prefix.Bits()-96)
+			}
+			prefixes = append(prefixes, prefix.Masked())
+			continue
+		}
+		addr, err := netip.ParseAddr(item)
+		if err != nil {
+			return nil, fmt.Errorf("invalid IP %s: %v", item, err)
+		}
+		addr = addr.Unmap()
+		prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+	}
+	return prefixes, nil
+}
+
+// SplitList 按,分隔并去除空白及空项
+func SplitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,329 @@
+package config
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"genspark2api/common/env"
+	"github.com/golang-jwt/jwt/v5"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// JWKS 文件路径或URL,设置后支持使用 JWT 作为请求密钥
+var JwtJwks = env.String("JWT_JWKS", "")
+
+// JWT 签发者(iss),为空时不校验
+var JwtIssuer = env.String("JWT_ISSUER", "")
+
+// JWT 受众(aud),命中其一即可(多个请以,分隔),为空时不校验
+var JwtAudience = env.String("JWT_AUDIENCE", "")
+
+// 作为租户标识的 claim
+var JwtTenantClaim = env.String("JWT_TENANT_CLAIM", "sub")
+
+// 用户组所在的 claim,值为字符串或字符串数组
+var JwtGroupsClaim = env.String("JWT_GROUPS_CLAIM", "groups")
+
+// JWKS URL 的刷新间隔(秒)
+var JwtJwksRefreshInterval = env.Int("JWT_JWKS_REFRESH_INTERVAL", 3600)
+
+// 校验 exp/nbf 时允许的时钟偏差(秒)
+var JwtLeeway = env.Int("JWT_LEEWAY", 60)
+
+// jwtTenantPrefix JWT 租户ID前缀,避免与 API Key 的ID冲突
+const jwtTenantPrefix = "jwt:"
+
+// 未知 kid 触发刷新 JWKS 的最小间隔
+const jwksMinRefreshInterval = time.Minute
+
+// 支持的签名算法
+var jwtValidMethods = []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512", "EdDSA"}
+
+// ParseJwtGroupModels 解析 JWT_GROUP_MODELS
+func ParseJwtGroupModels(s string) (map[string][]string, error) {
+	groupModels := make(map[string][]string)
+	if strings.TrimSpace(s) == "" {
+		return groupModels, nil
+	}
+	if err := json.Unmarshal([]byte(s), &groupModels); err != nil {
+		return nil, err
+	}
+	return groupModels, nil
+}
+
+// JwtEnabled 是否启用 JWT 鉴权
+func JwtEnabled() bool {
+	return JwtJwks != ""
+}
+
+// IsJwtURL JWKS 是否为URL
+func IsJwtURL() bool {
+	return strings.HasPrefix(JwtJwks, "http://") || strings.HasPrefix(JwtJwks, "https://")
+}
+
+var (
+	jwksKeys        atomic.Pointer[map[string]crypto.PublicKey]
+	jwksMutex       sync.Mutex
+	jwksRefreshedAt time.Time
+)
+
+// ReloadJwks 重新读取 JWKS,失败时保留原公钥
+func ReloadJwks() error {
+	jwksMutex.Lock()
+	defer jwksMutex.Unlock()
+	return reloadJwks()
+}
+
+func reloadJwks() error {
+	jwksRefreshedAt = time.Now()
+	data, err := readJwks(JwtJwks)
+	if err != nil {
+		return fmt.Errorf("read jwks %s: %v", JwtJwks, err)
+	}
+	keys, err := parseJwks(data)
+	if err != nil {
+		return fmt.Errorf("parse jwks %s: %v", JwtJwks, err)
+	}
+	jwksKeys.Store(&keys)
+	return nil
+}
+
+func readJwks(source string) ([]byte, error) {
+	if !IsJwtURL() {
+		return os.ReadFile(source)
+	}
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Get(source)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+}
+
+// jwk JSON Web Key,仅包含验证签名所需的字段
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// parseJwks 解析 JWKS 中的 RSA、EC 及 Ed25519 公钥,忽略用于加密的公钥
+func parseJwks(data []byte) (map[string]crypto.PublicKey, error) {
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, err
+	}
+	keys := make(map[string]crypto.PublicKey)
+	for i, key := range set.Keys {
+		if key.Use == "enc" {
+			continue
+		}
+		publicKey, err := key.publicKey()
+		if err != nil {
+			return nil, fmt.Errorf("key %d (kid: %s): %v", i, key.Kid, err)
+		}
+		keys[key.Kid] = publicKey
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("no signing keys found")
+	}
+	return keys, nil
+}
+
+func (k jwk) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %s", k.Crv)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, errors.New("point is not on curve")
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return nil, fmt.Errorf("unsupported curve %s", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		if len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid ed25519 key size")
+		}
+		return ed25519.PublicKey(x), nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %s", k.Kty)
+	}
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	bytes, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(bytes), nil
+}
+
+// jwtKey 根据 kid 查找公钥,JWKS 为URL且 kid 不存在时尝试刷新(密钥轮换)
+func jwtKey(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	if key, ok := lookupJwtKey(kid); ok {
+		return key, nil
+	}
+	if IsJwtURL() {
+		jwksMutex.Lock()
+		if time.Since(jwksRefreshedAt) >= jwksMinRefreshInterval {
+			if err := reloadJwks(); err != nil && OnJwksError != nil {
+				OnJwksError(err)
+			}
+		}
+		jwksMutex.Unlock()
+		if key, ok := lookupJwtKey(kid); ok {
+			return key, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown key id %q", kid)
+}
+
+// lookupJwtKey kid 为空且 JWKS 中仅有一个公钥时使用该公钥
+func lookupJwtKey(kid string) (crypto.PublicKey, bool) {
+	keys := jwksKeys.Load()
+	if keys == nil {
+		return nil, false
+	}
+	if key, ok := (*keys)[kid]; ok {
+		return key, true
+	}
+	if kid == "" && len(*keys) == 1 {
+		for _, key := range *keys {
+			return key, true
+		}
+	}
+	return nil, false
+}
+
+// OnJwksError 按需刷新 JWKS 失败时的回调
+var OnJwksError func(err error)
+
+// LooksLikeJwt 判断请求密钥是否为 JWT
+func LooksLikeJwt(token string) bool {
+	return strings.HasPrefix(token, "eyJ") && strings.Count(token, ".") == 2
+}
+
+// ParseJwtTenant 校验 JWT 并映射为租户,租户可用的模型为其所属用户组可用模型的并集
+func ParseJwtTenant(tokenString string) (*Tenant, error) {
+	options := []jwt.ParserOption{
+		jwt.WithValidMethods(jwtValidMethods),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(time.Duration(JwtLeeway) * time.Second),
+	}
+	if JwtIssuer != "" {
+		options = append(options, jwt.WithIssuer(JwtIssuer))
+	}
+	if audience := SplitList(JwtAudience); len(audience) > 0 {
+		options = append(options, jwt.WithAudience(audience...))
+	}
+
+	claims := jwt.MapClaims{}
+	if _, err := jwt.ParseWithClaims(tokenString, claims, jwtKey, options...); err != nil {
+		return nil, fmt.Errorf("invalid jwt: %v", err)
+	}
+
+	subject, _ := claims[JwtTenantClaim].(string)
+	if subject == "" {
+		return nil, fmt.Errorf("invalid jwt: missing claim %s", JwtTenantClaim)
+	}
+	tenant := &Tenant{
+		ID:     jwtTenantPrefix + subject,
+		Name:   subject,
+		Groups: claimStrings(claims[JwtGroupsClaim]),
+	}
+	groupModels := Current().JwtGroupModels
+	if len(groupModels) == 0 {
+		return tenant, nil
+	}
+
+	models := make(map[string]bool)
+	for _, group := range append([]string{"*"}, tenant.Groups...) {
+		for _, m := range groupModels[group] {
+			models[m] = true
+		}
+	}
+	if len(models) == 0 {
+		return nil, fmt.Errorf("jwt subject %s is not in any group allowed to use models", subject)
+	}
+	for m := range models {
+		tenant.AllowedModels = append(tenant.AllowedModels, m)
+	}
+	sort.Strings(tenant.AllowedModels)
+	return tenant, nil
+}
+
+// claimStrings 读取字符串或字符串数组类型的 claim
+func claimStrings(value interface{}) []string {
+	switch v := value.(type) {
+	case string:
+		return strings.Fields(strings.ReplaceAll(v, ",", " "))
+	case []interface{}:
+		var result []string
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				result = append(result, s)
+			}
+		}
+		return result
+	}
+	return nil
+}
@@ -0,0 +1,403 @@
+package config
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// testJwtKey 测试用的签名密钥
+type testJwtKey struct {
+	kid    string
+	method jwt.SigningMethod
+	key    crypto.Signer
+}
+
+func newRsaJwtKey(t *testing.T, kid string) testJwtKey {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return testJwtKey{kid: kid, method: jwt.SigningMethodRS256, key: key}
+}
+
+func newEcJwtKey(t *testing.T, kid string) testJwtKey {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return testJwtKey{kid: kid, method: jwt.SigningMethodES256, key: key}
+}
+
+// jwk 公钥的 JWK 格式
+func (k testJwtKey) jwk() map[string]string {
+	encode := func(n *big.Int, size int) string {
+		return base64.RawURLEncoding.EncodeToString(n.FillBytes(make([]byte, size)))
+	}
+	switch public := k.key.Public().(type) {
+	case *rsa.PublicKey:
+		return map[string]string{
+			"kty": "RSA", "kid": k.kid, "use": "sig",
+			"n": base64.RawURLEncoding.EncodeToString(public.N.Bytes()),
+			"e": base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes()),
+		}
+	case *ecdsa.PublicKey:
+		size := (public.Curve.Params().BitSize + 7) / 8
+		return map[string]string{
+			"kty": "EC", "kid": k.kid, "use": "sig", "crv": public.Curve.Params().Name,
+			"x": encode(public.X, size), "y": encode(public.Y, size),
+		}
+	}
+	panic("unsupported key")
+}
+
+func (k testJwtKey) sign(t *testing.T, claims jwt.MapClaims) string {
+	t.Helper()
+	token := jwt.NewWithClaims(k.method, claims)
+	if k.kid != "" {
+		token.Header["kid"] = k.kid
+	}
+	s, err := token.SignedString(k.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func jwksJson(t *testing.T, keys ...testJwtKey) []byte {
+	t.Helper()
+	set := struct {
+		Keys []map[string]string `json:"keys"`
+	}{}
+	for _, key := range keys {
+		set.Keys = append(set.Keys, key.jwk())
+	}
+	data, err := json.Marshal(set)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// setupJwt 使用默认的 JWT 配置,测试结束后恢复
+func setupJwt(t *testing.T) {
+	t.Helper()
+	jwks, issuer, audience := JwtJwks, JwtIssuer, JwtAudience
+	tenantClaim, groupsClaim, leeway := JwtTenantClaim, JwtGroupsClaim, JwtLeeway
+	keys, refreshedAt, reloadable := jwksKeys.Load(), jwksRefreshedAt, Current()
+	t.Cleanup(func() {
+		JwtJwks, JwtIssuer, JwtAudience = jwks, issuer, audience
+		JwtTenantClaim, JwtGroupsClaim, JwtLeeway = tenantClaim, groupsClaim, leeway
+		jwksKeys.Store(keys)
+		jwksRefreshedAt = refreshedAt
+		current.Store(reloadable)
+	})
+	JwtIssuer, JwtAudience = "", ""
+	JwtTenantClaim, JwtGroupsClaim, JwtLeeway = "sub", "groups", 60
+	setJwtGroupModels(t, nil)
+}
+
+// useJwksFile 将 JWKS 写入文件并加载
+func useJwksFile(t *testing.T, keys ...testJwtKey) {
+	t.Helper()
+	JwtJwks = filepath.Join(t.TempDir(), "jwks.json")
+	if err := os.WriteFile(JwtJwks, jwksJson(t, keys...), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ReloadJwks(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func setJwtGroupModels(t *testing.T, groupModels map[string][]string) {
+	t.Helper()
+	err := UpdateReloadable(func(next *Reloadable) error {
+		next.JwtGroupModels = groupModels
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func validClaims(sub string) jwt.MapClaims {
+	return jwt.MapClaims{"sub": sub, "exp": time.Now().Add(time.Hour).Unix()}
+}
+
+func TestParseJwtTenantSigningMethods(t *testing.T) {
+	setupJwt(t)
+	rsaKey, ecKey := newRsaJwtKey(t, "rsa"), newEcJwtKey(t, "ec")
+	useJwksFile(t, rsaKey, ecKey)
+
+	for _, key := range []testJwtKey{rsaKey, ecKey} {
+		t.Run(key.method.Alg(), func(t *testing.T) {
+			tenant, err := ParseJwtTenant(key.sign(t, validClaims("alice")))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tenant.ID != "jwt:alice" || tenant.Name != "alice" {
+				t.Fatalf("tenant = %+v", tenant)
+			}
+		})
+	}
+
+	t.Run("wrong key", func(t *testing.T) {
+		// kid 指向 rsa 公钥,但使用其它私钥签名
+		forged := newRsaJwtKey(t, "rsa")
+		if _, err := ParseJwtTenant(forged.sign(t, validClaims("alice"))); err == nil {
+			t.Fatal("token signed by unknown key is accepted")
+		}
+	})
+
+	t.Run("hmac", func(t *testing.T) {
+		token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, validClaims("alice")).SignedString([]byte("secret"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := ParseJwtTenant(token); err == nil {
+			t.Fatal("HS256 token is accepted")
+		}
+	})
+
+	t.Run("unknown kid", func(t *testing.T) {
+		if _, err := ParseJwtTenant(newEcJwtKey(t, "other").sign(t, validClaims("alice"))); err == nil {
+			t.Fatal("token with unknown kid is accepted")
+		}
+	})
+}
+
+func TestParseJwtTenantSingleKeyWithoutKid(t *testing.T) {
+	setupJwt(t)
+	key := newEcJwtKey(t, "only")
+	useJwksFile(t, key)
+
+	// JWKS 中仅有一个公钥时,未设置 kid 的 JWT 使用该公钥
+	key.kid = ""
+	if _, err := ParseJwtTenant(key.sign(t, validClaims("alice"))); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestParseJwtTenantClaims(t *testing.T) {
+	setupJwt(t)
+	key := newRsaJwtKey(t, "rsa")
+	useJwksFile(t, key)
+	JwtIssuer = "https://issuer.example.com"
+	JwtAudience = "genspark2api, other"
+
+	now := time.Now()
+	tests := []struct {
+		name   string
+		claims jwt.MapClaims
+		ok     bool
+	}{
+		{"valid", jwt.MapClaims{"sub": "alice", "iss": JwtIssuer, "aud": "genspark2api", "exp": now.Add(time.Hour).Unix()}, true},
+		{"any audience", jwt.MapClaims{"sub": "alice", "iss": JwtIssuer, "aud": []string{"x", "other"}, "exp": now.Add(time.Hour).Unix()}, true},
+		{"wrong issuer", jwt.MapClaims{"sub": "alice", "iss": "https://evil.example.com", "aud": "genspark2api", "exp": now.Add(time.Hour).Unix()}, false},
+		{"missing issuer", jwt.MapClaims{"sub": "alice", "aud": "genspark2api", "exp": now.Add(time.Hour).Unix()}, false},
+		{"wrong audience", jwt.MapClaims{"sub": "alice", "iss": JwtIssuer, "aud": "x", "exp": now.Add(time.Hour).Unix()}, false},
+		{"missing audience", jwt.MapClaims{"sub": "alice", "iss": JwtIssuer, "exp": now.Add(time.Hour).Unix()}, false},
+		{"missing exp", jwt.MapClaims{"sub": "alice", "iss": JwtIssuer, "aud": "genspark2api"}, false},
+		{"expired", jwt.MapClaims{"sub": "alice", "iss": JwtIssuer, "aud": "genspark2api", "exp": now.Add(-time.Hour).Unix()}, false},
+		{"not before", jwt.MapClaims{"sub": "alice", "iss": JwtIssuer, "aud": "genspark2api", "exp": now.Add(time.Hour).Unix(), "nbf": now.Add(time.Hour).Unix()}, false},
+		{"missing sub", jwt.MapClaims{"iss": JwtIssuer, "aud": "genspark2api", "exp": now.Add(time.Hour).Unix()}, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := ParseJwtTenant(key.sign(t, test.claims))
+			if (err == nil) != test.ok {
+				t.Fatalf("err = %v, want ok = %v", err, test.ok)
+			}
+		})
+	}
+
+	t.Run("tenant claim", func(t *testing.T) {
+		JwtTenantClaim = "email"
+		claims := jwt.MapClaims{"sub": "alice", "email": "alice@example.com", "iss": JwtIssuer, "aud": "genspark2api", "exp": now.Add(time.Hour).Unix()}
+		tenant, err := ParseJwtTenant(key.sign(t, claims))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tenant.ID != "jwt:alice@example.com" {
+			t.Fatalf("tenant id = %s", tenant.ID)
+		}
+	})
+}
+
+func TestParseJwtTenantLeeway(t *testing.T) {
+	setupJwt(t)
+	key := newEcJwtKey(t, "ec")
+	useJwksFile(t, key)
+
+	expiredAgo := func(d time.Duration) string {
+		return key.sign(t, jwt.MapClaims{"sub": "alice", "exp": time.Now().Add(-d).Unix()})
+	}
+	JwtLeeway = 60
+	if _, err := ParseJwtTenant(expiredAgo(30 * time.Second)); err != nil {
+		t.Fatalf("token expired within leeway: %v", err)
+	}
+	if _, err := ParseJwtTenant(expiredAgo(2 * time.Minute)); err == nil {
+		t.Fatal("token expired beyond leeway is accepted")
+	}
+	JwtLeeway = 0
+	if _, err := ParseJwtTenant(expiredAgo(30 * time.Second)); err == nil {
+		t.Fatal("expired token is accepted without leeway")
+	}
+
+	// nbf 同样允许时钟偏差
+	JwtLeeway = 60
+	notBefore := key.sign(t, jwt.MapClaims{"sub": "alice", "exp": time.Now().Add(time.Hour).Unix(), "nbf": time.Now().Add(30 * time.Second).Unix()})
+	if _, err := ParseJwtTenant(notBefore); err != nil {
+		t.Fatalf("token not valid yet within leeway: %v", err)
+	}
+}
+
+func TestParseJwtTenantRefreshUnknownKid(t *testing.T) {
+	setupJwt(t)
+	oldKey, newKey := newRsaJwtKey(t, "old"), newEcJwtKey(t, "new")
+
+	var jwks atomic.Value
+	jwks.Store(jwksJson(t, oldKey))
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		_, _ = w.Write(jwks.Load().([]byte))
+	}))
+	defer server.Close()
+
+	JwtJwks = server.URL + "/jwks.json"
+	if !IsJwtURL() {
+		t.Fatal("jwks should be an url")
+	}
+	if err := ReloadJwks(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ParseJwtTenant(oldKey.sign(t, validClaims("alice"))); err != nil {
+		t.Fatal(err)
+	}
+
+	// 密钥轮换: 新 kid 触发刷新,但刚刷新过时不再请求
+	jwks.Store(jwksJson(t, oldKey, newKey))
+	token := newKey.sign(t, validClaims("bob"))
+	if _, err := ParseJwtTenant(token); err == nil {
+		t.Fatal("unknown kid is accepted before refresh")
+	}
+	if got := requests.Load(); got != 1 {
+		t.Fatalf("jwks requests = %d, want 1", got)
+	}
+
+	jwksRefreshedAt = time.Now().Add(-2 * jwksMinRefreshInterval)
+	tenant, err := ParseJwtTenant(token)
+	if err != nil {
+		t.Fatalf("unknown kid after refresh: %v", err)
+	}
+	if tenant.ID != "jwt:bob" {
+		t.Fatalf("tenant id = %s", tenant.ID)
+	}
+	if got := requests.Load(); got != 2 {
+		t.Fatalf("jwks requests = %d, want 2", got)
+	}
+
+	// 刷新失败时保留原公钥
+	var jwksErr error
+	OnJwksError = func(err error) { jwksErr = err }
+	defer func() { OnJwksError = nil }()
+	jwks.Store([]byte("not json"))
+	jwksRefreshedAt = time.Now().Add(-2 * jwksMinRefreshInterval)
+	if _, err := ParseJwtTenant(newRsaJwtKey(t, "unknown").sign(t, validClaims("eve"))); err == nil {
+		t.Fatal("unknown kid is accepted")
+	}
+	if jwksErr == nil {
+		t.Fatal("OnJwksError is not called")
+	}
+	if _, err := ParseJwtTenant(token); err != nil {
+		t.Fatalf("known key is lost after failed refresh: %v", err)
+	}
+}
+
+func TestParseJwtTenantGroupModels(t *testing.T) {
+	setupJwt(t)
+	key := newEcJwtKey(t, "ec")
+	useJwksFile(t, key)
+
+	withGroups := func(groups interface{}) string {
+		claims := validClaims("alice")
+		if groups != nil {
+			claims["groups"] = groups
+		}
+		return key.sign(t, claims)
+	}
+
+	// 未配置 JWT_GROUP_MODELS 时可用全部模型
+	tenant, err := ParseJwtTenant(withGroups([]string{"ml"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tenant.AllowedModels != nil || !reflect.DeepEqual(tenant.Groups, []string{"ml"}) {
+		t.Fatalf("tenant = %+v", tenant)
+	}
+
+	setJwtGroupModels(t, map[string][]string{
+		"*":    {"gpt-4o-mini"},
+		"ml":   {"o1", "gpt-4o"},
+		"chat": {"gpt-4o", "claude-3-5-sonnet"},
+	})
+	tests := []struct {
+		name   string
+		groups interface{}
+		want   []string
+	}{
+		{"array", []string{"ml", "chat"}, []string{"claude-3-5-sonnet", "gpt-4o", "gpt-4o-mini", "o1"}},
+		{"string", "ml, unknown", []string{"gpt-4o", "gpt-4o-mini", "o1"}},
+		{"no groups", nil, []string{"gpt-4o-mini"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tenant, err := ParseJwtTenant(withGroups(test.groups))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(tenant.AllowedModels, test.want) {
+				t.Fatalf("allowed models = %v, want %v", tenant.AllowedModels, test.want)
+			}
+			for _, m := range test.want {
+				if !tenant.AllowsModel(m) {
+					t.Fatalf("tenant is not allowed to use %s", m)
+				}
+			}
+		})
+	}
+
+	// 没有 * 且不属于任何配置的用户组时拒绝
+	setJwtGroupModels(t, map[string][]string{"ml": {"o1"}})
+	_, err = ParseJwtTenant(withGroups([]string{"chat"}))
+	if err == nil || !strings.Contains(err.Error(), "not in any group") {
+		t.Fatalf("err = %v", err)
+	}
+	JwtGroupsClaim = "roles"
+	tenant, err = ParseJwtTenant(key.sign(t, jwt.MapClaims{"sub": "alice", "roles": []string{"ml"}, "exp": time.Now().Add(time.Hour).Unix()}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(tenant.AllowedModels, []string{"o1"}) {
+		t.Fatalf("allowed models = %v", tenant.AllowedModels)
+	}
+}
@@ -0,0 +1,286 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/samber/lo"
+)
+
+// 模型类型
+const (
+	ModelKindText  = "text"
+	ModelKindImage = "image"
+	// ModelKindMixture 多个文本模型同时回答(Mixture-of-Agents)
+	ModelKindMixture = "mixture"
+)
+
+// 联网模型的后缀,如 gpt-4o-search
+const SearchModelSuffix = "-search"
+
+// tiktoken 编码
+const (
+	EncoderCl100k = "cl100k_base"
+	EncoderO200k  = "o200k_base"
+)
+
+var modelKinds = []string{ModelKindText, ModelKindImage, ModelKindMixture}
+var modelEncoders = []string{EncoderCl100k, EncoderO200k, "p50k_base", "r50k_base"}
+
+// ModelInfo 模型信息
+type ModelInfo struct {
+	Id string `json:"id"`
+	// Upstream 请求 Genspark 时使用的模型名,默认同 id
+	Upstream string `json:"upstream,omitempty"`
+	// Aliases 别名,请求时转换为 id
+	Aliases []string `json:"aliases,omitempty"`
+	Kind    string   `json:"kind"`
+	// Models mixture 模型同时请求的文本模型
+	Models  []string `json:"models,omitempty"`
+	OwnedBy string   `json:"owned_by,omitempty"`
+
+	Reasoning bool `json:"reasoning,omitempty"`
+	// Search 支持联网(-search 后缀)
+	Search        bool `json:"search,omitempty"`
+	Vision        bool `json:"vision,omitempty"`
+	ContextLength int  `json:"context_length,omitempty"`
+	// Encoder 计算 token 数使用的 tiktoken 编码,默认为 cl100k_base
+	Encoder string `json:"encoder,omitempty"`
+
+	// AnswerFieldValue 流式回答在 field_value 而不是 delta 中返回
+	AnswerFieldValue bool `json:"answer_field_value,omitempty"`
+	// SearchDetailAnswer 联网时回答在 message_result 的 detailAnswer 中返回
+	SearchDetailAnswer bool `json:"search_detail_answer,omitempty"`
+	// SystemAsUser system 消息转换为 user 消息
+	SystemAsUser bool `json:"system_as_user,omitempty"`
+
+	// Disabled 禁用内置模型
+	Disabled bool `json:"disabled,omitempty"`
+}
+
+// UpstreamName 请求 Genspark 时使用的模型名
+func (m *ModelInfo) UpstreamName() string {
+	if m.Upstream != "" {
+		return m.Upstream
+	}
+	return m.Id
+}
+
+// TokenEncoder 计算 token 数使用的 tiktoken 编码
+func (m *ModelInfo) TokenEncoder() string {
+	if m.Encoder != "" {
+		return m.Encoder
+	}
+	return EncoderCl100k
+}
+
+// defaultModels 内置模型
+var defaultModels = []ModelInfo{
+	{Id: "gpt-4o", Kind: ModelKindText, OwnedBy: "openai", Search: true, Vision: true, ContextLength: 128000, Encoder: EncoderO200k},
+	{Id: "o1", Kind: ModelKindText, OwnedBy: "openai", Reasoning: true, Search: true, Vision: true, ContextLength: 200000, Encoder: EncoderO200k,
+		AnswerFieldValue: true, SearchDetailAnswer: true},
+	{Id: "o3-mini-high", Kind: ModelKindText, OwnedBy: "openai", Reasoning: true, Search: true, ContextLength: 200000, Encoder: EncoderO200k,
+		AnswerFieldValue: true},
+	{Id: "claude-3-5-sonnet", Kind: ModelKindText, OwnedBy: "anthropic", Search: true, Vision: true, ContextLength: 200000},
+	{Id: "claude-3-5-haiku", Kind: ModelKindText, OwnedBy: "anthropic", Search: true, ContextLength: 200000},
+	{Id: "gemini-2.0-flash", Kind: ModelKindText, OwnedBy: "google", Search: true, Vision: true, ContextLength: 1048576},
+	{Id: "deep-seek-v3", Aliases: []string{"deepseek-v3"}, Kind: ModelKindText, OwnedBy: "deepseek", Search: true, ContextLength: 64000},
+	{Id: "deep-seek-r1", Aliases: []string{"deepseek-r1"}, Kind: ModelKindText, OwnedBy: "deepseek", Reasoning: true, Search: true, ContextLength: 64000,
+		SystemAsUser: true},
+	{Id: "mixture", Kind: ModelKindMixture, OwnedBy: "genspark", Models: []string{"gpt-4o", "claude-3-5-sonnet", "gemini-2.0-flash"}},
+
+	{Id: "flux", Kind: ModelKindImage, OwnedBy: "black-forest-labs"},
+	{Id: "flux-speed", Kind: ModelKindImage, OwnedBy: "black-forest-labs"},
+	{Id: "flux-pro/ultra", Kind: ModelKindImage, OwnedBy: "black-forest-labs"},
+	{Id: "ideogram", Kind: ModelKindImage, OwnedBy: "ideogram"},
+	{Id: "recraft-v3", Kind: ModelKindImage, OwnedBy: "recraft"},
+	{Id: "dall-e-3", Upstream: "dalle-3", Kind: ModelKindImage, OwnedBy: "openai"},
+	{Id: "imagen3", Kind: ModelKindImage, OwnedBy: "google"},
+}
+
+// ModelRegistry 模型注册表
+type ModelRegistry struct {
+	models []*ModelInfo
+	// byName id 及别名 -> 模型
+	byName map[string]*ModelInfo
+}
+
+func mustParseModelRegistry(s string) *ModelRegistry {
+	registry, err := ParseModelRegistry(s)
+	if err != nil {
+		panic(err)
+	}
+	return registry
+}
+
+// ParseModelRegistry 解析 MODEL_REGISTRY,与内置模型合并并校验
+func ParseModelRegistry(s string) (*ModelRegistry, error) {
+	models := make([]ModelInfo, len(defaultModels))
+	copy(models, defaultModels)
+	if strings.TrimSpace(s) != "" {
+		var custom []ModelInfo
+		if err := json.Unmarshal([]byte(s), &custom); err != nil {
+			return nil, err
+		}
+		for _, m := range custom {
+			index := -1
+			for i := range models {
+				if models[i].Id == m.Id {
+					index = i
+					break
+				}
+			}
+			if index >= 0 {
+				models[index] = m
+			} else {
+				models = append(models, m)
+			}
+		}
+	}
+
+	registry := &ModelRegistry{byName: make(map[string]*ModelInfo)}
+	var errs []error
+	for i := range models {
+		m := &models[i]
+		if m.Disabled {
+			continue
+		}
+		if err := m.validate(); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		for _, name := range append([]string{m.Id}, m.Aliases...) {
+			if other, ok := registry.byName[name]; ok {
+				errs = append(errs, fmt.Errorf("model %s: name %s is already used by model %s", m.Id, name, other.Id))
+				continue
+			}
+			registry.byName[name] = m
+		}
+		registry.models = append(registry.models, m)
+	}
+	for _, m := range registry.models {
+		for _, member := range m.Models {
+			if other, ok := registry.byName[member]; !ok || other.Kind != ModelKindText {
+				errs = append(errs, fmt.Errorf("model %s: %s is not a text model", m.Id, member))
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	return registry, nil
+}
+
+func (m *ModelInfo) validate() error {
+	if strings.TrimSpace(m.Id) == "" {
+		return errors.New("model id is required")
+	}
+	for _, name := range append([]string{m.Id}, m.Aliases...) {
+		if strings.HasSuffix(name, SearchModelSuffix) {
+			return fmt.Errorf("model %s: name %s must not end with %s", m.Id, name, SearchModelSuffix)
+		}
+	}
+	if !lo.Contains(modelKinds, m.Kind) {
+		return fmt.Errorf("model %s: unknown kind %q, should be one of %v", m.Id, m.Kind, modelKinds)
+	}
+	if m.Kind == ModelKindMixture && len(m.Models) == 0 {
+		return fmt.Errorf("model %s: models is required for mixture model", m.Id)
+	}
+	if m.Kind != ModelKindMixture && len(m.Models) > 0 {
+		return fmt.Errorf("model %s: models is only allowed for mixture model", m.Id)
+	}
+	if m.Encoder != "" && !lo.Contains(modelEncoders, m.Encoder) {
+		return fmt.Errorf("model %s: unknown encoder %q, should be one of %v", m.Id, m.Encoder, modelEncoders)
+	}
+	if m.ContextLength < 0 {
+		return fmt.Errorf("model %s: context_length must not be negative", m.Id)
+	}
+	return nil
+}
+
+// List 全部模型
+func (r *ModelRegistry) List() []*ModelInfo {
+	return r.models
+}
+
+// Lookup 按 id 或别名查找模型,联网模型(-search)查找其基础模型
+func (r *ModelRegistry) Lookup(name string) (*ModelInfo, bool) {
+	m, ok := r.byName[strings.TrimSuffix(name, SearchModelSuffix)]
+	if !ok || (strings.HasSuffix(name, SearchModelSuffix) && !m.Search) {
+		return nil, false
+	}
+	return m, true
+}
+
+// Resolve 将别名转换为模型 id,保留 -search 后缀,未知模型原样返回
+func (r *ModelRegistry) Resolve(name string) string {
+	m, ok := r.Lookup(name)
+	if !ok {
+		return name
+	}
+	if strings.HasSuffix(name, SearchModelSuffix) {
+		return m.Id + SearchModelSuffix
+	}
+	return m.Id
+}
+
+// IsKind 模型是否为指定类型,联网模型按其基础模型判断
+func (r *ModelRegistry) IsKind(name string, kind string) bool {
+	m, ok := r.Lookup(name)
+	return ok && m.Kind == kind
+}
+
+// Ids 指定类型的全部模型 id
+func (r *ModelRegistry) Ids(kind string) []string {
+	var ids []string
+	for _, m := range r.models {
+		if m.Kind == kind {
+			ids = append(ids, m.Id)
+		}
+	}
+	return ids
+}
+
+// UpstreamModels 请求 Genspark 时 extra_data.models 的值,未知模型使用第一个 mixture 模型
+func (r *ModelRegistry) UpstreamModels(name string) []string {
+	m, ok := r.Lookup(name)
+	if !ok || m.Kind == ModelKindImage {
+		m = nil
+		for _, candidate := range r.models {
+			if candidate.Kind == ModelKindMixture {
+				m = candidate
+				break
+			}
+		}
+		if m == nil {
+			return []string{name}
+		}
+	}
+	if m.Kind != ModelKindMixture {
+		return []string{m.UpstreamName()}
+	}
+	models := make([]string, 0, len(m.Models))
+	for _, member := range m.Models {
+		models = append(models, r.byName[member].UpstreamName())
+	}
+	return models
+}
+
+// Upstream 请求 Genspark 时使用的模型名,未知模型原样返回
+func (r *ModelRegistry) Upstream(name string) string {
+	if m, ok := r.Lookup(name); ok {
+		return m.UpstreamName()
+	}
+	return name
+}
+
+// Encoders 模型 id -> tiktoken 编码
+func (r *ModelRegistry) Encoders() map[string]string {
+	encoders := make(map[string]string, len(r.models))
+	for _, m := range r.models {
+		encoders[m.Id] = m.TokenEncoder()
+	}
+	return encoders
+}
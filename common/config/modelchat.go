@@ -0,0 +1,161 @@
+package config
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"genspark2api/common/env"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 启动时自动为每个cookie的每个文本模型创建专属对话 0:关闭 1:开启
+var ModelChatProvision = env.Int("MODEL_CHAT_PROVISION", 0)
+
+// 两次创建专属对话之间的间隔(毫秒),避免请求过快
+var ModelChatProvisionInterval = env.Int("MODEL_CHAT_PROVISION_INTERVAL", 2000)
+
+// 专属对话来源
+const (
+	ModelChatSourceProvision = "provision"
+	ModelChatSourceManual    = "manual"
+	ModelChatSourceEnv       = "env"
+)
+
+// ModelChat cookie下某个模型的专属对话,请求该模型时固定使用此对话,避免模型被自动切换
+// 状态存储中只保存 cookie 的指纹,Cookie 从cookie池中查找,cookie 已被移除时为空
+type ModelChat struct {
+	Cookie    string    `json:"-"`
+	CookieId  string    `json:"cookie"`
+	Model     string    `json:"model"`
+	ChatID    string    `json:"chat_id"`
+	Source    string    `json:"source"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+type modelChatEntry struct {
+	ChatID    string    `json:"chat_id"`
+	Source    string    `json:"source"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func modelChatKey(cookieId, model string) string {
+	return SessionKey{Cookie: cookieId, Model: model}.String()
+}
+
+// GetModelChat 获取cookie下模型的专属对话,未配置时回退到环境变量 MODEL_CHAT_MAP
+func GetModelChat(cookie, model string) (string, bool) {
+	for _, m := range []string{model, NormalizeEntitlementModel(model)} {
+		if value, ok := StateStore.GetModelChat(modelChatKey(CookieFingerprint(cookie), m)); ok {
+			var entry modelChatEntry
+			if err := json.Unmarshal([]byte(value), &entry); err == nil && entry.ChatID != "" {
+				return entry.ChatID, true
+			}
+		}
+	}
+	chatId, ok := Current().ModelChatMap[model]
+	return chatId, ok
+}
+
+// SetModelChat 保存cookie下模型的专属对话
+func SetModelChat(cookie, model, chatID, source string) {
+	bytes, err := json.Marshal(modelChatEntry{
+		ChatID:    chatID,
+		Source:    source,
+		UpdatedAt: time.Now(),
+	})
+	if err != nil {
+		return
+	}
+	StateStore.SetModelChat(modelChatKey(CookieFingerprint(cookie), model), string(bytes))
+}
+
+// DeleteModelChat 删除cookie下模型的专属对话映射(不删除对话本身)
+func DeleteModelChat(cookie, model string) {
+	DeleteModelChatByCookieId(CookieFingerprint(cookie), model)
+}
+
+// DeleteModelChatByCookieId 按cookie指纹删除专属对话映射,用于删除cookie已被移除的映射
+func DeleteModelChatByCookieId(cookieId, model string) {
+	StateStore.DeleteModelChat(modelChatKey(cookieId, model))
+}
+
+// ModelChats 获取全部专属对话,包含环境变量 MODEL_CHAT_MAP 中的配置
+func ModelChats() []ModelChat {
+	var modelChats []ModelChat
+	cookies := cookiesByFingerprint()
+	for key, value := range StateStore.ModelChats() {
+		sessionKey, ok := parseSessionKey(key)
+		if !ok {
+			continue
+		}
+		var entry modelChatEntry
+		if err := json.Unmarshal([]byte(value), &entry); err != nil {
+			continue
+		}
+		modelChats = append(modelChats, ModelChat{
+			Cookie:    cookies[sessionKey.Cookie],
+			CookieId:  sessionKey.Cookie,
+			Model:     sessionKey.Model,
+			ChatID:    entry.ChatID,
+			Source:    entry.Source,
+			UpdatedAt: entry.UpdatedAt,
+		})
+	}
+	for model, chatId := range Current().ModelChatMap {
+		modelChats = append(modelChats, ModelChat{
+			Model:  model,
+			ChatID: chatId,
+			Source: ModelChatSourceEnv,
+		})
+	}
+	sort.Slice(modelChats, func(i, j int) bool {
+		if modelChats[i].CookieId != modelChats[j].CookieId {
+			return modelChats[i].CookieId < modelChats[j].CookieId
+		}
+		return modelChats[i].Model < modelChats[j].Model
+	})
+	return modelChats
+}
+
+// IsModelChat 判断对话是否为cookie下的专属对话
+func IsModelChat(cookie, chatID string) bool {
+	for _, modelChat := range ModelChats() {
+		if modelChat.ChatID == chatID && (modelChat.Source == ModelChatSourceEnv || modelChat.Cookie == cookie) {
+			return true
+		}
+	}
+	return false
+}
+
+// CookieFingerprint cookie的短指纹,用于日志及管理接口中标识cookie而不暴露cookie本身
+func CookieFingerprint(cookie string) string {
+	if cookie == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(cookie))
+	return hex.EncodeToString(sum[:6])
+}
+
+// FindCookie 按指纹或在 GS_COOKIE 中的序号(从0开始)查找cookie
+func FindCookie(ref string) (string, bool) {
+	ref = strings.TrimSpace(ref)
+	if ref == "" {
+		return "", false
+	}
+	cookies := GetGSCookies()
+	if index, err := strconv.Atoi(ref); err == nil {
+		if index >= 0 && index < len(cookies) {
+			return cookies[index], true
+		}
+		return "", false
+	}
+	for _, cookie := range cookies {
+		if CookieFingerprint(cookie) == ref {
+			return cookie, true
+		}
+	}
+	return "", false
+}
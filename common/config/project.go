@@ -0,0 +1,138 @@
+package config
+
+import (
+	"encoding/json"
+	"genspark2api/common/env"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// 定时清理 Genspark 对话 0:关闭 1:开启
+var ProjectCleanupEnable = env.Int("PROJECT_CLEANUP_ENABLE", 0)
+
+// 清理间隔(秒)
+var ProjectCleanupInterval = env.Int("PROJECT_CLEANUP_INTERVAL", 60*60)
+
+// 对话未被使用超过该时间(秒)后删除(映射中的对话除外)
+var ProjectCleanupTTL = env.Int("PROJECT_CLEANUP_TTL", 24*60*60)
+
+// 未被映射的对话超过该时间(秒)后视为遗留对话(如请求中断未能删除),仅在 AUTO_DEL_CHAT=1 时删除
+var ProjectOrphanGracePeriod = env.Int("PROJECT_ORPHAN_GRACE_PERIOD", 10*60)
+
+// 每次删除失败后的重试次数
+var ProjectCleanupRetries = env.Int("PROJECT_CLEANUP_RETRIES", 2)
+
+// 两次删除请求之间的间隔(毫秒),避免请求过快
+var ProjectCleanupRateInterval = env.Int("PROJECT_CLEANUP_RATE_INTERVAL", 1000)
+
+// TrackedProject 已创建的 Genspark 对话
+type TrackedProject struct {
+	ID     string `json:"-"`
+	Cookie string `json:"cookie"`
+	Model  string `json:"model"`
+	// UsedAt 最近一次使用时间
+	UsedAt time.Time `json:"used_at"`
+}
+
+// TrackProject 记录 cookie 下使用的对话,用于后续清理,未开启 PROJECT_CLEANUP_ENABLE 时不记录
+// 复用已有对话时刷新最近使用时间
+func TrackProject(cookie, model, projectId string) {
+	if projectId == "" || ProjectCleanupEnable != 1 {
+		return
+	}
+	bytes, err := json.Marshal(TrackedProject{
+		Cookie: cookie,
+		Model:  model,
+		UsedAt: time.Now(),
+	})
+	if err != nil {
+		return
+	}
+	StateStore.SetProject(projectId, string(bytes))
+}
+
+// UntrackProject 对话已删除,移除记录
+func UntrackProject(projectId string) {
+	StateStore.DeleteProject(projectId)
+}
+
+// TrackedProjects 获取全部已记录的对话,按最近使用时间排序
+func TrackedProjects() []TrackedProject {
+	var projects []TrackedProject
+	for id, value := range StateStore.Projects() {
+		var project TrackedProject
+		if err := json.Unmarshal([]byte(value), &project); err != nil {
+			continue
+		}
+		project.ID = id
+		projects = append(projects, project)
+	}
+	sort.Slice(projects, func(i, j int) bool {
+		return projects[i].UsedAt.Before(projects[j].UsedAt)
+	})
+	return projects
+}
+
+// ProjectGuard 读取时的全部映射,用于判断对话是否仍被映射使用
+// 清理时只构建一次,避免每个对话都扫描一次全部会话映射
+type ProjectGuard struct {
+	modelChats []ModelChat
+	sessions   map[string]map[string]bool // cookie -> chatID
+	imageChats map[string]bool
+}
+
+// NewProjectGuard 读取当前的模型专属对话、会话映射及 SESSION_IMAGE_CHAT_MAP
+func NewProjectGuard() *ProjectGuard {
+	guard := &ProjectGuard{
+		modelChats: ModelChats(),
+		imageChats: make(map[string]bool, len(SessionImageChatMap)),
+	}
+	if GlobalSessionManager != nil {
+		guard.sessions = GlobalSessionManager.CookieChatIDs()
+	}
+	for _, chatID := range SessionImageChatMap {
+		guard.imageChats[chatID] = true
+	}
+	return guard
+}
+
+// Protects 判断对话是否仍被映射使用,映射中的对话不会被删除
+func (g *ProjectGuard) Protects(cookie, projectId string) bool {
+	for _, modelChat := range g.modelChats {
+		if modelChat.ChatID == projectId && (modelChat.Source == ModelChatSourceEnv || modelChat.Cookie == cookie) {
+			return true
+		}
+	}
+	return g.sessions[CookieFingerprint(cookie)][projectId] || g.imageChats[projectId]
+}
+
+// IsProtectedProject 判断对话是否仍被映射使用,映射中的对话不会被删除
+func IsProtectedProject(cookie, projectId string) bool {
+	return NewProjectGuard().Protects(cookie, projectId)
+}
+
+// ProjectCleanupReport 一次对话清理的结果
+type ProjectCleanupReport struct {
+	StartedAt time.Time     `json:"started_at"`
+	Duration  time.Duration `json:"duration"`
+	Tracked   int           `json:"tracked"`
+	Expired   []string      `json:"expired"`
+	Orphaned  []string      `json:"orphaned"`
+	Protected int           `json:"protected"`
+	Failed    []string      `json:"failed"`
+	Abandoned []string      `json:"abandoned"`
+	Remaining int           `json:"remaining"`
+}
+
+var lastProjectCleanupReport atomic.Pointer[ProjectCleanupReport]
+
+// LastProjectCleanupReport 最近一次对话清理的结果,尚未执行时返回 nil
+func LastProjectCleanupReport() *ProjectCleanupReport {
+	return lastProjectCleanupReport.Load()
+}
+
+// SetProjectCleanupReport 记录最近一次对话清理的结果
+func SetProjectCleanupReport(report *ProjectCleanupReport) {
+	lastProjectCleanupReport.Store(report)
+}
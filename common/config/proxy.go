@@ -0,0 +1,174 @@
+package config
+
+import (
+	"genspark2api/common/env"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// 代理被封禁后的禁用时间(秒)
+var ProxyUnhealthyDuration = env.Int("PROXY_UNHEALTHY_DURATION", 10*60)
+
+var GlobalProxyPool = NewProxyPool(splitAndTrim(ProxyUrl))
+
+type proxyState struct {
+	url            string
+	failures       int
+	lastFailure    time.Time
+	unhealthyUntil time.Time
+}
+
+// ProxyStat 代理健康状态
+type ProxyStat struct {
+	Proxy       string    `json:"proxy"`
+	Healthy     bool      `json:"healthy"`
+	Failures    int       `json:"failures"`
+	LastFailure time.Time `json:"last_failure"`
+	Cookies     int       `json:"cookies"`
+}
+
+// ProxyPool 代理池,每个 cookie 固定绑定一个代理,保证同一账号始终从同一出口IP请求
+// 代理的健康状态与 cookie 的限速状态分开记录
+type ProxyPool struct {
+	proxies     []*proxyState
+	assignments map[string]int // cookie -> proxy index
+	mu          sync.Mutex
+}
+
+func NewProxyPool(proxyUrls []string) *ProxyPool {
+	pool := &ProxyPool{
+		assignments: make(map[string]int),
+	}
+	for _, proxyUrl := range proxyUrls {
+		pool.proxies = append(pool.proxies, &proxyState{url: proxyUrl})
+	}
+	return pool
+}
+
+// Size 代理数量
+func (p *ProxyPool) Size() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.proxies)
+}
+
+// GetProxy 获取 cookie 绑定的代理,未绑定时分配一个绑定数最少的健康代理
+func (p *ProxyPool) GetProxy(cookie string) string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if len(p.proxies) == 0 {
+		return ""
+	}
+
+	if index, ok := p.assignments[cookie]; ok {
+		return p.proxies[index].url
+	}
+
+	index := p.pickLocked(-1)
+	if index == -1 {
+		// 全部不健康时仍然按顺序分配,避免请求直连
+		index = len(p.assignments) % len(p.proxies)
+	}
+	p.assignments[cookie] = index
+	return p.proxies[index].url
+}
+
+// ReportBlocked 记录 cookie 当前代理被封禁,并为其切换到其它健康代理
+// 返回 false 表示没有可切换的健康代理
+func (p *ProxyPool) ReportBlocked(cookie string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	index, ok := p.assignments[cookie]
+	if !ok {
+		return false
+	}
+
+	now := time.Now()
+	current := p.proxies[index]
+	current.failures++
+	current.lastFailure = now
+	current.unhealthyUntil = now.Add(time.Duration(ProxyUnhealthyDuration) * time.Second)
+
+	next := p.pickLocked(index)
+	if next == -1 {
+		return false
+	}
+	p.assignments[cookie] = next
+	return true
+}
+
+// Release 解除 cookie 与代理的绑定,cookie 从cookie池中移除后调用
+func (p *ProxyPool) Release(cookie string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.assignments, cookie)
+}
+
+// Stats 获取代理池状态
+func (p *ProxyPool) Stats() []ProxyStat {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	counts := p.assignmentCountsLocked()
+	now := time.Now()
+	stats := make([]ProxyStat, 0, len(p.proxies))
+	for i, proxy := range p.proxies {
+		stats = append(stats, ProxyStat{
+			Proxy:       redactProxyUrl(proxy.url),
+			Healthy:     !proxy.unhealthyUntil.After(now),
+			Failures:    proxy.failures,
+			LastFailure: proxy.lastFailure,
+			Cookies:     counts[i],
+		})
+	}
+	return stats
+}
+
+// pickLocked 选择绑定数最少的健康代理,exclude 为需要排除的下标
+func (p *ProxyPool) pickLocked(exclude int) int {
+	counts := p.assignmentCountsLocked()
+	now := time.Now()
+	best := -1
+	for i, proxy := range p.proxies {
+		if i == exclude || proxy.unhealthyUntil.After(now) {
+			continue
+		}
+		if best == -1 || counts[i] < counts[best] {
+			best = i
+		}
+	}
+	return best
+}
+
+func (p *ProxyPool) assignmentCountsLocked() []int {
+	counts := make([]int, len(p.proxies))
+	for _, index := range p.assignments {
+		counts[index]++
+	}
+	return counts
+}
+
+// GetProxy 获取 cookie 绑定的代理
+func GetProxy(cookie string) string {
+	return GlobalProxyPool.GetProxy(cookie)
+}
+
+// RotateProxy 当前代理被 Cloudflare 封禁时为 cookie 切换代理
+func RotateProxy(cookie string) bool {
+	return GlobalProxyPool.ReportBlocked(cookie)
+}
+
+// redactProxyUrl 隐藏代理地址中的密码
+func redactProxyUrl(proxyUrl string) string {
+	u, err := url.Parse(proxyUrl)
+	if err != nil || u.User == nil {
+		return proxyUrl
+	}
+	if _, ok := u.User.Password(); ok {
+		u.User = url.UserPassword(u.User.Username(), "***")
+	}
+	return u.String()
+}
@@ -0,0 +1,153 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"genspark2api/common/state"
+	"strings"
+)
+
+// RateLimit 每分钟请求数/token数及最大流式请求数限制,0 表示不限制
+type RateLimit struct {
+	RPM        int `json:"rpm"`
+	TPM        int `json:"tpm"`
+	MaxStreams int `json:"max_streams"`
+}
+
+// ParseModelRateLimits 解析 MODEL_RATE_LIMIT
+func ParseModelRateLimits(s string) (map[string]RateLimit, error) {
+	limits := make(map[string]RateLimit)
+	if strings.TrimSpace(s) == "" {
+		return limits, nil
+	}
+	if err := json.Unmarshal([]byte(s), &limits); err != nil {
+		return nil, err
+	}
+	for model, limit := range limits {
+		if limit.RPM < 0 || limit.TPM < 0 {
+			return nil, fmt.Errorf("rate limit of model %s must not be negative", model)
+		}
+	}
+	return limits, nil
+}
+
+// GetModelRateLimit 获取模型限速,联网模型与其基础模型共享限速
+func GetModelRateLimit(model string) (RateLimit, bool) {
+	limits := Current().ModelRateLimits
+	if limit, ok := limits[model]; ok {
+		return limit, true
+	}
+	limit, ok := limits[NormalizeEntitlementModel(model)]
+	return limit, ok
+}
+
+// RateLimit 租户的限速,未单独设置时使用默认值
+func (t *Tenant) RateLimit() RateLimit {
+	r := Current()
+	limit := RateLimit{RPM: t.RPM, TPM: t.TPM, MaxStreams: t.MaxStreams}
+	if limit.RPM == 0 {
+		limit.RPM = r.KeyRateLimitRPM
+	}
+	if limit.TPM == 0 {
+		limit.TPM = r.KeyRateLimitTPM
+	}
+	if limit.MaxStreams == 0 {
+		limit.MaxStreams = r.KeyMaxStreams
+	}
+	return limit
+}
+
+func (t *Tenant) rateLimitKey() string {
+	if t.RateLimitKey != "" {
+		return t.RateLimitKey
+	}
+	return t.ID
+}
+
+// TakeRequest 扣除一次请求,key 为 API Key 或模型的限速标识
+func TakeRequest(key string, rpm int) RateLimitResult {
+	return takeTokens("rpm:"+key, rpm, 1, false)
+}
+
+// CheckRequest 检查每分钟请求数是否已超出限制(不扣除)
+func CheckRequest(key string, rpm int) RateLimitResult {
+	return takeTokens("rpm:"+key, rpm, 0, false)
+}
+
+// CheckTokens 检查每分钟 token 数是否已超出限制(不扣除)
+func CheckTokens(key string, tpm int) RateLimitResult {
+	return takeTokens("tpm:"+key, tpm, 0, false)
+}
+
+// ConsumeTokens 请求完成后扣除实际使用的 token 数,允许超出,超出的部分需等待补足后才能继续请求
+func ConsumeTokens(key string, tpm int, tokens int) {
+	if tpm <= 0 || tokens <= 0 {
+		return
+	}
+	takeTokens("tpm:"+key, tpm, int64(tokens), true)
+}
+
+// RateLimitResult 限速结果,Limit 为0表示不限速
+type RateLimitResult struct {
+	Limit int
+	state.TokenBucketResult
+}
+
+func takeTokens(key string, limit int, cost int64, force bool) RateLimitResult {
+	if limit <= 0 {
+		return RateLimitResult{TokenBucketResult: state.TokenBucketResult{Allowed: true}}
+	}
+	return RateLimitResult{
+		Limit:             limit,
+		TokenBucketResult: StateStore.TakeTokens(key, int64(limit), float64(limit)/60, cost, force),
+	}
+}
+
+// TenantRateLimitKey 租户的限速标识
+func TenantRateLimitKey(tenant *Tenant) string {
+	return "key:" + tenant.rateLimitKey()
+}
+
+// ModelRateLimitKey 模型的限速标识
+func ModelRateLimitKey(model string) string {
+	return "model:" + NormalizeEntitlementModel(model)
+}
+
+// SecretRateLimitKey API_SECRET 中各 Key 的限速标识,不同的 Key 分别限速
+func SecretRateLimitKey(secret string) string {
+	return "secret:" + HashApiKey(secret)[:16]
+}
+
+// CheckModelRateLimit 检查模型的每分钟 token 数及请求数是否已超出限制(不扣除)
+// 超出时返回对应的结果及类型(tokens/requests),未配置限速的模型总是允许
+func CheckModelRateLimit(model string) (RateLimitResult, string) {
+	limit, ok := GetModelRateLimit(model)
+	if model == "" || !ok {
+		return RateLimitResult{TokenBucketResult: state.TokenBucketResult{Allowed: true}}, ""
+	}
+	key := ModelRateLimitKey(model)
+	if result := CheckTokens(key, limit.TPM); !result.Allowed {
+		return result, "tokens"
+	}
+	result := CheckRequest(key, limit.RPM)
+	if !result.Allowed {
+		return result, "requests"
+	}
+	return result, ""
+}
+
+// TakeModelRequest 扣除模型的一次请求
+func TakeModelRequest(model string) RateLimitResult {
+	limit, ok := GetModelRateLimit(model)
+	if model == "" || !ok {
+		return RateLimitResult{TokenBucketResult: state.TokenBucketResult{Allowed: true}}
+	}
+	return TakeRequest(ModelRateLimitKey(model), limit.RPM)
+}
+
+// ConsumeModelTokens 请求完成后扣除模型实际使用的 token 数
+func ConsumeModelTokens(model string, tokens int) {
+	if limit, ok := GetModelRateLimit(model); model != "" && ok {
+		ConsumeTokens(ModelRateLimitKey(model), limit.TPM, tokens)
+	}
+}
@@ -0,0 +1,142 @@
+package config
+
+import (
+	"genspark2api/common/env"
+	"sync/atomic"
+)
+
+// Reloadable 可热重载的配置项及由其解析得到的配置
+// 重载配置文件时创建新的副本整体替换,不修改已发布的副本,请求处理时通过 Current 读取快照
+type Reloadable struct {
+	// 自动删除对话
+	AutoDelChat int
+	// 每分钟每个IP的请求数限制
+	RequestRateLimitNum int
+	// 模型对应的专属对话,如 gpt-4o=chatId,多个以,分隔
+	ModelChatMapStr string
+	// 检测到cookie无该模型权限后的禁用时间(秒)
+	ModelDenyLockDuration int
+	// cookie被限速或未登录时暂停使用的时长(秒)
+	RateLimitCookieLockDuration int
+	// 隐藏思考过程
+	ReasoningHide int
+	// 未绑定Chat时将完整对话历史折叠进提问[0:关闭(仅发送最后一条user消息),1:开启]
+	TranscriptMode int
+	// 折叠对话历史的token上限,超出时保留system消息及最近的轮次
+	TranscriptMaxTokens int
+	// 超出token上限时将放不下的较早消息截断为前200个字符保留而不是直接省略(不是语义摘要)[0:关闭,1:开启]
+	TranscriptCondense int
+	// 非流式请求上游的超时时间(秒)
+	RequestOutTime int
+	// 流式请求上游的超时时间(秒)
+	StreamRequestOutTime int
+	// 模型注册表(JSON数组),按 id 覆盖或新增内置模型,如 [{"id":"gpt-4o-mini","kind":"text","search":true}]
+	ModelRegistryStr string
+	// 模型降级链(JSON),如 {"o1":["o3-mini-high","gpt-4o"]},请求 o1 失败时依次使用 o3-mini-high、gpt-4o
+	ModelFallbackStr string
+	// 触发降级的条件(多个以,分隔),默认为 pool_exhausted,overload
+	ModelFallbackOn string
+	// 模型价格(JSON),用于估算费用,如 {"gpt-4o":{"prompt":2.5,"completion":10},"flux":{"image":0.04}}
+	// prompt/completion 为每百万 token 的价格,image 为每张图片的价格
+	ModelPriceStr string
+	// 每个 API Key 每分钟的请求数限制,0 表示不限制,可在 API Key 中单独设置
+	KeyRateLimitRPM int
+	// 每个 API Key 每分钟的 token 数限制,0 表示不限制,可在 API Key 中单独设置
+	KeyRateLimitTPM int
+	// 每个 API Key 同时进行的流式请求数限制,0 表示不限制,可在 API Key 中单独设置
+	KeyMaxStreams int
+	// 每个模型的限速(JSON),所有 Key 共享,如 {"o1":{"rpm":10,"tpm":200000}}
+	ModelRateLimitStr string
+	// 用户组可用的模型(JSON),如 {"ml-team":["gpt-4o","o1"],"*":["gpt-4o-mini"]},* 对全部用户生效
+	JwtGroupModelsStr string
+	// 日志级别,可按包设置,如 info,controller=debug,common/config=warn
+	LogLevel string
+	// 相同告警的冷却时间(秒),冷却期间的重复告警合并到下一次告警中
+	AlertCooldown int
+	// 可用cookie数低于该值时告警,0 表示不检查
+	AlertPoolThreshold int
+	// ALERT_CLOUDFLARE_WINDOW 秒内遇到 Cloudflare 验证的次数达到该值时告警
+	AlertCloudflareThreshold int
+	// 统计 Cloudflare 验证次数的时间窗口(秒)
+	AlertCloudflareWindow int
+
+	// Models 当前生效的模型注册表
+	Models *ModelRegistry
+	// ModelChatMap 模型 -> 专属对话
+	ModelChatMap map[string]string
+	// ModelPrices 模型价格表,未配置的模型不计算费用
+	ModelPrices map[string]ModelPrice
+	// ModelRateLimits 模型限速,未配置的模型不限速
+	ModelRateLimits map[string]RateLimit
+	// JwtGroupModels 用户组可用的模型,为空时 JWT 用户可用全部模型
+	JwtGroupModels map[string][]string
+	// ModelFallbacks 模型 id -> 降级模型 id
+	ModelFallbacks map[string][]string
+	// ModelFallbackConditions 触发降级的条件
+	ModelFallbackConditions []string
+}
+
+var current atomic.Pointer[Reloadable]
+
+func init() {
+	current.Store(&Reloadable{
+		AutoDelChat:                 env.Int("AUTO_DEL_CHAT", 0),
+		RequestRateLimitNum:         env.Int("REQUEST_RATE_LIMIT", 60),
+		ModelChatMapStr:             env.String("MODEL_CHAT_MAP", ""),
+		ModelDenyLockDuration:       env.Int("MODEL_DENY_LOCK_DURATION", 24*60*60),
+		RateLimitCookieLockDuration: env.Int("RATE_LIMIT_COOKIE_LOCK_DURATION", 10*60),
+		ReasoningHide:               env.Int("REASONING_HIDE", 0),
+		TranscriptMode:              env.Int("TRANSCRIPT_MODE", 0),
+		TranscriptMaxTokens:         env.Int("TRANSCRIPT_MAX_TOKENS", 8000),
+		TranscriptCondense:          env.Int("TRANSCRIPT_CONDENSE", 0),
+		RequestOutTime:              env.Int("REQUEST_OUT_TIME", 10*60*60),
+		StreamRequestOutTime:        env.Int("STREAM_REQUEST_OUT_TIME", 10*60*60),
+		ModelRegistryStr:            env.String("MODEL_REGISTRY", ""),
+		ModelFallbackStr:            env.String("MODEL_FALLBACK", ""),
+		ModelFallbackOn:             env.String("MODEL_FALLBACK_ON", FallbackPoolExhausted+","+FallbackOverload),
+		ModelPriceStr:               env.String("MODEL_PRICE", ""),
+		KeyRateLimitRPM:             env.Int("KEY_RATE_LIMIT_RPM", 0),
+		KeyRateLimitTPM:             env.Int("KEY_RATE_LIMIT_TPM", 0),
+		KeyMaxStreams:               env.Int("KEY_MAX_STREAMS", 0),
+		ModelRateLimitStr:           env.String("MODEL_RATE_LIMIT", ""),
+		JwtGroupModelsStr:           env.String("JWT_GROUP_MODELS", ""),
+		LogLevel:                    env.String("LOG_LEVEL", ""),
+		AlertCooldown:               env.Int("ALERT_COOLDOWN", 600),
+		AlertPoolThreshold:          env.Int("ALERT_POOL_THRESHOLD", 1),
+		AlertCloudflareThreshold:    env.Int("ALERT_CLOUDFLARE_THRESHOLD", 5),
+		AlertCloudflareWindow:       env.Int("ALERT_CLOUDFLARE_WINDOW", 300),
+
+		Models:                  mustParseModelRegistry(""),
+		ModelChatMap:            make(map[string]string),
+		ModelPrices:             make(map[string]ModelPrice),
+		ModelRateLimits:         make(map[string]RateLimit),
+		JwtGroupModels:          make(map[string][]string),
+		ModelFallbacks:          make(map[string][]string),
+		ModelFallbackConditions: []string{FallbackPoolExhausted, FallbackOverload},
+	})
+}
+
+// Current 当前生效的可热重载配置,返回的快照不可修改
+// 一次请求中多次读取配置时应只调用一次,保证使用同一份配置
+func Current() *Reloadable {
+	return current.Load()
+}
+
+// clone 浅拷贝,解析得到的 map 等只整体替换,不修改其内容
+func (r *Reloadable) clone() *Reloadable {
+	next := *r
+	return &next
+}
+
+// UpdateReloadable 在当前配置的副本上执行 update,成功后发布该副本
+func UpdateReloadable(update func(next *Reloadable) error) error {
+	configMutex.Lock()
+	defer configMutex.Unlock()
+
+	next := Current().clone()
+	if err := update(next); err != nil {
+		return err
+	}
+	current.Store(next)
+	return nil
+}
@@ -0,0 +1,309 @@
+package config
+
+import (
+	"encoding/json"
+	"genspark2api/common/env"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 会话映射过期时间(秒),超过该时间未使用的映射会被淘汰,0为不过期
+var SessionTTL = env.Int("SESSION_TTL", 0)
+
+// 会话映射最大数量,超出后淘汰最久未使用的映射,0为不限制
+var SessionMaxEntries = env.Int("SESSION_MAX_ENTRIES", 0)
+
+// 会话映射被淘汰时是否删除对应的对话[0:关闭,1:开启]
+var SessionEvictDelChat = env.Int("SESSION_EVICT_DEL_CHAT", 0)
+
+// SessionKey 定义复合键结构
+// Cookie 为 cookie 的指纹(CookieFingerprint),状态存储中不保存 cookie 本身,使用时从cookie池中查找
+// 按对话映射时 Cookie 为空,对话所在 cookie 的指纹记录在 sessionEntry 中
+type SessionKey struct {
+	Cookie       string `json:"cookie,omitempty"`
+	Model        string `json:"model"`
+	Conversation string `json:"conversation,omitempty"`
+}
+
+func (key SessionKey) String() string {
+	bytes, _ := json.Marshal(key)
+	return string(bytes)
+}
+
+func parseSessionKey(s string) (SessionKey, bool) {
+	var key SessionKey
+	if err := json.Unmarshal([]byte(s), &key); err != nil {
+		return SessionKey{}, false
+	}
+	return key, true
+}
+
+// sessionEntry 会话映射的值
+type sessionEntry struct {
+	ChatID string `json:"chat_id"`
+	// Cookie 对话所在 cookie 的指纹
+	Cookie    string    `json:"cookie,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func (entry sessionEntry) String() string {
+	bytes, _ := json.Marshal(entry)
+	return string(bytes)
+}
+
+func parseSessionEntry(s string) (sessionEntry, bool) {
+	var entry sessionEntry
+	if err := json.Unmarshal([]byte(s), &entry); err != nil {
+		return sessionEntry{}, false
+	}
+	return entry, true
+}
+
+func (entry sessionEntry) expired(now time.Time) bool {
+	return SessionTTL > 0 && !entry.UpdatedAt.IsZero() &&
+		entry.UpdatedAt.Add(time.Duration(SessionTTL)*time.Second).Before(now)
+}
+
+// SessionManager 会话管理器,映射保存在 StateStore 中
+type SessionManager struct {
+	// OnEvict 会话映射因过期或超出数量被淘汰后的回调,cookie 已从cookie池中移除时为空
+	OnEvict func(cookie string, chatID string)
+
+	evictMutex sync.Mutex
+	// writeMutex 保护会话映射的读取-修改-写入
+	writeMutex sync.Mutex
+	// size 上次淘汰后的映射数量加上之后新增的数量,超出 SESSION_MAX_ENTRIES 时才淘汰
+	size atomic.Int64
+}
+
+// NewSessionManager 创建新的会话管理器
+func NewSessionManager() *SessionManager {
+	sm := &SessionManager{}
+	sm.size.Store(int64(len(StateStore.Sessions())))
+	return sm
+}
+
+// AddSession 添加会话记录
+func (sm *SessionManager) AddSession(cookie string, model string, chatID string) {
+	key := SessionKey{
+		Cookie: CookieFingerprint(cookie),
+		Model:  model,
+	}
+	sm.save(key, sessionEntry{ChatID: chatID})
+}
+
+// AddConversation 添加对话映射,同时记录对话所在的 cookie,后续请求固定使用该 cookie
+func (sm *SessionManager) AddConversation(cookie string, model string, conversation string, chatID string) {
+	key := SessionKey{
+		Model:        model,
+		Conversation: conversation,
+	}
+	sm.save(key, sessionEntry{ChatID: chatID, Cookie: CookieFingerprint(cookie)})
+}
+
+// GetConversation 获取对话映射的 cookie 及会话ID,已过期或 cookie 已从cookie池中移除的映射视为不存在
+func (sm *SessionManager) GetConversation(model string, conversation string) (string, string, bool) {
+	key := SessionKey{
+		Model:        model,
+		Conversation: conversation,
+	}
+	value, ok := StateStore.GetSession(key.String())
+	if !ok {
+		return "", "", false
+	}
+	entry, ok := parseSessionEntry(value)
+	if !ok || entry.expired(time.Now()) {
+		return "", "", false
+	}
+	cookie, ok := cookieByFingerprint(entry.Cookie)
+	if !ok {
+		return "", "", false
+	}
+	sm.touch(key.String(), entry.ChatID)
+	return cookie, entry.ChatID, true
+}
+
+// DeleteConversation 删除对话映射
+func (sm *SessionManager) DeleteConversation(model string, conversation string) {
+	key := SessionKey{
+		Model:        model,
+		Conversation: conversation,
+	}
+	StateStore.DeleteSession(key.String())
+}
+
+func (sm *SessionManager) save(key SessionKey, entry sessionEntry) {
+	sm.writeMutex.Lock()
+	now := time.Now()
+	entry.CreatedAt = now
+	entry.UpdatedAt = now
+	value, exists := StateStore.GetSession(key.String())
+	if exists {
+		if existing, ok := parseSessionEntry(value); ok && existing.ChatID == entry.ChatID && !existing.CreatedAt.IsZero() {
+			entry.CreatedAt = existing.CreatedAt
+		}
+	}
+	StateStore.SetSession(key.String(), entry.String())
+	sm.writeMutex.Unlock()
+
+	// 仅新增映射且可能超出最大数量时淘汰,其余由 SessionEvictTask 定时淘汰
+	if !exists && SessionMaxEntries > 0 && sm.size.Add(1) > int64(SessionMaxEntries) {
+		sm.Evict()
+	}
+}
+
+// touch 刷新会话映射的最近使用时间,映射已被修改时不更新
+// 未配置 SESSION_TTL 及 SESSION_MAX_ENTRIES 时不淘汰映射,无需更新
+func (sm *SessionManager) touch(key string, chatID string) {
+	if SessionTTL <= 0 && SessionMaxEntries <= 0 {
+		return
+	}
+	sm.writeMutex.Lock()
+	defer sm.writeMutex.Unlock()
+	value, ok := StateStore.GetSession(key)
+	if !ok {
+		return
+	}
+	entry, ok := parseSessionEntry(value)
+	if !ok || entry.ChatID != chatID {
+		return
+	}
+	entry.UpdatedAt = time.Now()
+	StateStore.SetSession(key, entry.String())
+}
+
+// GetChatID 获取会话ID,已过期的映射视为不存在
+func (sm *SessionManager) GetChatID(cookie string, model string) (string, bool) {
+	key := SessionKey{
+		Cookie: CookieFingerprint(cookie),
+		Model:  model,
+	}
+	value, ok := StateStore.GetSession(key.String())
+	if !ok {
+		return "", false
+	}
+	entry, ok := parseSessionEntry(value)
+	if !ok || entry.expired(time.Now()) {
+		return "", false
+	}
+	sm.touch(key.String(), entry.ChatID)
+	return entry.ChatID, true
+}
+
+// DeleteSession 删除会话记录
+func (sm *SessionManager) DeleteSession(cookie string, model string) {
+	key := SessionKey{
+		Cookie: CookieFingerprint(cookie),
+		Model:  model,
+	}
+	StateStore.DeleteSession(key.String())
+}
+
+// CookieChatIDs 获取全部会话映射及对话映射中各cookie关联的chatID,key 为 cookie 的指纹
+func (sm *SessionManager) CookieChatIDs() map[string]map[string]bool {
+	chatIDs := make(map[string]map[string]bool)
+	for s, value := range StateStore.Sessions() {
+		key, ok := parseSessionKey(s)
+		if !ok {
+			continue
+		}
+		entry, ok := parseSessionEntry(value)
+		if !ok {
+			continue
+		}
+		cookie := key.Cookie
+		if cookie == "" {
+			cookie = entry.Cookie
+		}
+		if chatIDs[cookie] == nil {
+			chatIDs[cookie] = make(map[string]bool)
+		}
+		chatIDs[cookie][entry.ChatID] = true
+	}
+	return chatIDs
+}
+
+// DeleteSessionsByCookies 删除指定cookie的会话映射及对话映射,cookie 从cookie池中移除后调用,返回删除的数量
+func (sm *SessionManager) DeleteSessionsByCookies(cookies []string) int {
+	if len(cookies) == 0 {
+		return 0
+	}
+	removed := make(map[string]bool, len(cookies))
+	for _, cookie := range cookies {
+		removed[CookieFingerprint(cookie)] = true
+	}
+
+	sm.writeMutex.Lock()
+	defer sm.writeMutex.Unlock()
+
+	deleted := 0
+	for s, value := range StateStore.Sessions() {
+		key, ok := parseSessionKey(s)
+		if !ok {
+			continue
+		}
+		entry, _ := parseSessionEntry(value)
+		if removed[key.Cookie] || removed[entry.Cookie] {
+			StateStore.DeleteSession(s)
+			deleted++
+		}
+	}
+	sm.size.Add(-int64(deleted))
+	return deleted
+}
+
+// Size 会话映射数量
+func (sm *SessionManager) Size() int {
+	return len(StateStore.Sessions())
+}
+
+// Evict 淘汰过期及超出最大数量的会话映射,返回被淘汰的数量
+func (sm *SessionManager) Evict() int {
+	sm.evictMutex.Lock()
+	defer sm.evictMutex.Unlock()
+
+	type item struct {
+		raw   string
+		key   SessionKey
+		entry sessionEntry
+	}
+
+	now := time.Now()
+	var evicted, alive []item
+	for raw, value := range StateStore.Sessions() {
+		key, _ := parseSessionKey(raw)
+		entry, ok := parseSessionEntry(value)
+		it := item{raw: raw, key: key, entry: entry}
+		if it.key.Cookie == "" {
+			it.key.Cookie = it.entry.Cookie
+		}
+		// 无法解析的映射直接淘汰
+		if !ok || it.entry.expired(now) {
+			evicted = append(evicted, it)
+		} else {
+			alive = append(alive, it)
+		}
+	}
+
+	if SessionMaxEntries > 0 && len(alive) > SessionMaxEntries {
+		// 最久未使用的排在前面
+		sort.Slice(alive, func(i, j int) bool {
+			return alive[i].entry.UpdatedAt.Before(alive[j].entry.UpdatedAt)
+		})
+		evicted = append(evicted, alive[:len(alive)-SessionMaxEntries]...)
+		alive = alive[len(alive)-SessionMaxEntries:]
+	}
+	sm.size.Store(int64(len(alive)))
+
+	cookies := cookiesByFingerprint()
+	for _, it := range evicted {
+		StateStore.DeleteSession(it.raw)
+		if sm.OnEvict != nil {
+			sm.OnEvict(cookies[it.key.Cookie], it.entry.ChatID)
+		}
+	}
+	return len(evicted)
+}
@@ -0,0 +1,66 @@
+package config
+
+import (
+	"strings"
+	"time"
+)
+
+// MarkNotLogin 标记cookie已失效,锁定 RATE_LIMIT_COOKIE_LOCK_DURATION 期间不再使用,之后重新尝试
+// 标记保存在 StateStore 中,配置Redis时各副本共享
+func MarkNotLogin(cookie string) {
+	StateStore.MarkCookieNotLogin(cookie, time.Now().Add(time.Duration(Current().RateLimitCookieLockDuration)*time.Second))
+}
+
+// IsNotLogin cookie是否已失效
+func IsNotLogin(cookie string) bool {
+	_, ok := StateStore.CookieNotLoginUntil(cookie)
+	return ok
+}
+
+// IsCookieUsable cookie是否可用(未失效且未限速)
+func IsCookieUsable(cookie string) bool {
+	return !IsNotLogin(cookie) && !IsRateLimited(cookie)
+}
+
+// CookiePoolStats cookie池状态
+type CookiePoolStats struct {
+	Total       int `json:"total"`
+	Usable      int `json:"usable"`
+	RateLimited int `json:"rate_limited"`
+	NotLogin    int `json:"not_login"`
+	// Tiers 按套餐统计的cookie数,仅声明可用模型的为 custom,未声明的为 unknown
+	Tiers map[string]int `json:"tiers"`
+}
+
+// GetCookiePoolStats 获取cookie池状态
+func GetCookiePoolStats() CookiePoolStats {
+	stats := CookiePoolStats{Tiers: make(map[string]int)}
+	for _, cookie := range GetGSCookies() {
+		stats.Total++
+		switch {
+		case IsNotLogin(cookie):
+			stats.NotLogin++
+		case IsRateLimited(cookie):
+			stats.RateLimited++
+		default:
+			stats.Usable++
+		}
+		entitlement := GetCookieEntitlement(cookie)
+		tier := entitlement.Tier
+		switch {
+		case tier != "":
+		case len(entitlement.AllowedModels) > 0:
+			tier = "custom"
+		default:
+			tier = "unknown"
+		}
+		stats.Tiers[tier]++
+	}
+	return stats
+}
+
+// CheatEnabled 是否配置了请求体签名服务 CHEAT_URL
+func CheatEnabled() bool {
+	url := strings.TrimSpace(CheatUrl)
+	return strings.HasPrefix(url, "http://") || strings.HasPrefix(url, "https://")
+}
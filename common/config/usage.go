@@ -0,0 +1,189 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"genspark2api/common/env"
+	"sort"
+	"strings"
+	"time"
+)
+
+// 记录每次请求的用量 0:关闭 1:开启
+var UsageLedgerEnable = env.Int("USAGE_LEDGER_ENABLE", 0)
+
+// 用量记录保留天数,0 表示永久保留
+var UsageRetentionDays = env.Int("USAGE_RETENTION_DAYS", 90)
+
+// ModelPrice 模型价格
+type ModelPrice struct {
+	Prompt     float64 `json:"prompt"`
+	Completion float64 `json:"completion"`
+	Image      float64 `json:"image"`
+}
+
+// ModelPrices 模型价格表,未配置的模型不计算费用
+var ModelPrices = make(map[string]ModelPrice)
+
+// ParseModelPrices 解析 MODEL_PRICE
+func ParseModelPrices(s string) (map[string]ModelPrice, error) {
+	prices := make(map[string]ModelPrice)
+	if strings.TrimSpace(s) == "" {
+		return prices, nil
+	}
+	if err := json.Unmarshal([]byte(s), &prices); err != nil {
+		return nil, err
+	}
+	for model, price := range prices {
+		if price.Prompt < 0 || price.Completion < 0 || price.Image < 0 {
+			return nil, fmt.Errorf("price of model %s must not be negative", model)
+		}
+	}
+	return prices, nil
+}
+
+// UsageRecord 一次请求的用量
+type UsageRecord struct {
+	Time             time.Time `json:"time"`
+	RequestId        string    `json:"request_id"`
+	KeyId            string    `json:"key_id"`
+	KeyName          string    `json:"key_name"`
+	Path             string    `json:"path"`
+	Model            string    `json:"model"`
+	Stream           bool      `json:"stream"`
+	PromptTokens     int       `json:"prompt_tokens"`
+	CompletionTokens int       `json:"completion_tokens"`
+	Images           int       `json:"images"`
+	LatencyMs        int64     `json:"latency_ms"`
+	Status           int       `json:"status"`
+}
+
+// Cost 按价格表估算费用,未配置价格时返回 false
+func (r *UsageRecord) Cost() (float64, bool) {
+	prices := Current().ModelPrices
+	price, ok := prices[r.Model]
+	if !ok {
+		price, ok = prices[NormalizeEntitlementModel(r.Model)]
+	}
+	if !ok {
+		return 0, false
+	}
+	return float64(r.PromptTokens)*price.Prompt/1e6 +
+		float64(r.CompletionTokens)*price.Completion/1e6 +
+		float64(r.Images)*price.Image, true
+}
+
+// AddUsageRecord 保存用量记录
+func AddUsageRecord(record *UsageRecord) {
+	if UsageLedgerEnable != 1 {
+		return
+	}
+	bytes, err := json.Marshal(record)
+	if err != nil {
+		return
+	}
+	StateStore.AddUsage(record.Time, string(bytes))
+}
+
+// UsageRecords 获取 [from, to) 时间范围内的用量记录
+func UsageRecords(from, to time.Time) []UsageRecord {
+	var records []UsageRecord
+	for _, value := range StateStore.Usages(from, to) {
+		var record UsageRecord
+		if err := json.Unmarshal([]byte(value), &record); err != nil {
+			continue
+		}
+		records = append(records, record)
+	}
+	return records
+}
+
+// PurgeUsageRecords 删除超出保留天数的用量记录
+func PurgeUsageRecords() int {
+	if UsageRetentionDays <= 0 {
+		return 0
+	}
+	return StateStore.DeleteUsagesBefore(time.Now().AddDate(0, 0, -UsageRetentionDays))
+}
+
+// UsageSummary 用量汇总
+type UsageSummary struct {
+	Date             string   `json:"date,omitempty"`
+	KeyId            string   `json:"key_id,omitempty"`
+	KeyName          string   `json:"key_name,omitempty"`
+	Model            string   `json:"model,omitempty"`
+	Requests         int      `json:"requests"`
+	Errors           int      `json:"errors"`
+	PromptTokens     int64    `json:"prompt_tokens"`
+	CompletionTokens int64    `json:"completion_tokens"`
+	TotalTokens      int64    `json:"total_tokens"`
+	Images           int64    `json:"images"`
+	AvgLatencyMs     int64    `json:"avg_latency_ms"`
+	Cost             *float64 `json:"cost,omitempty"`
+
+	latencyMs int64
+}
+
+// 汇总维度
+const (
+	UsageGroupKey   = "key"
+	UsageGroupModel = "model"
+	UsageGroupDay   = "day"
+)
+
+// SummarizeUsage 按维度汇总用量,groupBy 为 key/model/day 的组合
+func SummarizeUsage(records []UsageRecord, groupBy []string) []*UsageSummary {
+	group := make(map[string]bool)
+	for _, g := range groupBy {
+		group[g] = true
+	}
+
+	summaries := make(map[string]*UsageSummary)
+	var keys []string
+	for i := range records {
+		record := &records[i]
+		var summary UsageSummary
+		if group[UsageGroupDay] {
+			summary.Date = record.Time.Local().Format("2006-01-02")
+		}
+		if group[UsageGroupKey] {
+			summary.KeyId = record.KeyId
+			summary.KeyName = record.KeyName
+		}
+		if group[UsageGroupModel] {
+			summary.Model = record.Model
+		}
+		key := summary.Date + "\x00" + summary.KeyId + "\x00" + summary.Model
+		s, ok := summaries[key]
+		if !ok {
+			s = &summary
+			summaries[key] = s
+			keys = append(keys, key)
+		}
+
+		s.Requests++
+		if record.Status >= 400 {
+			s.Errors++
+		}
+		s.PromptTokens += int64(record.PromptTokens)
+		s.CompletionTokens += int64(record.CompletionTokens)
+		s.TotalTokens += int64(record.PromptTokens + record.CompletionTokens)
+		s.Images += int64(record.Images)
+		s.latencyMs += record.LatencyMs
+		if cost, ok := record.Cost(); ok {
+			if s.Cost == nil {
+				s.Cost = new(float64)
+			}
+			*s.Cost += cost
+		}
+	}
+
+	sort.Strings(keys)
+	result := make([]*UsageSummary, 0, len(keys))
+	for _, key := range keys {
+		s := summaries[key]
+		s.AvgLatencyMs = s.latencyMs / int64(s.Requests)
+		result = append(result, s)
+	}
+	return result
+}
@@ -0,0 +1,294 @@
+package dialog
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"genspark2api/common/config"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+const (
+	// 当前对话记录文件,轮转后为 dialog-2006-01-02T15-04-05.000.jsonl.gz
+	recordFile         = "dialog.jsonl"
+	defaultSearchLimit = 100
+	maxCitationLength  = 2048
+)
+
+// Record 一次对话的记录
+type Record struct {
+	Time      time.Time `json:"time"`
+	RequestId string    `json:"request_id"`
+	KeyId     string    `json:"key_id"`
+	KeyName   string    `json:"key_name"`
+	Path      string    `json:"path"`
+	Model     string    `json:"model"`
+	Stream    bool      `json:"stream"`
+	// Cookie 使用的cookie指纹
+	Cookie string `json:"cookie,omitempty"`
+	// Request 原始请求(OpenAI 格式)
+	Request   json.RawMessage `json:"request"`
+	Answer    string          `json:"answer"`
+	Reasoning string          `json:"reasoning,omitempty"`
+	// Citations 回答中引用的链接
+	Citations        []string `json:"citations,omitempty"`
+	Images           []string `json:"images,omitempty"`
+	PromptTokens     int      `json:"prompt_tokens"`
+	CompletionTokens int      `json:"completion_tokens"`
+	Status           int      `json:"status"`
+	// FirstTokenMs 流式请求首个响应的耗时
+	FirstTokenMs int64 `json:"first_token_ms,omitempty"`
+	LatencyMs    int64 `json:"latency_ms"`
+}
+
+var (
+	sink *lumberjack.Logger
+
+	thinkPattern     = regexp.MustCompile(`(?s)<think>(.*?)</think>`)
+	imageLinkPattern = regexp.MustCompile(`!\[[^\]]*\]\([^)]*\)`)
+	citationPattern  = regexp.MustCompile(`https?://[^\s)\]>"'<]+`)
+)
+
+// SetAnswer 写入回答,<think> 中的内容作为思考过程,回答中的链接(不含图片)作为引用
+func (r *Record) SetAnswer(content string) {
+	var reasoning []string
+	for _, match := range thinkPattern.FindAllStringSubmatch(content, -1) {
+		reasoning = append(reasoning, strings.TrimSpace(match[1]))
+	}
+	r.Reasoning = strings.Join(reasoning, "\n")
+	r.Answer = strings.TrimSpace(thinkPattern.ReplaceAllString(content, ""))
+
+	seen := make(map[string]bool)
+	for _, link := range citationPattern.FindAllString(imageLinkPattern.ReplaceAllString(r.Answer, ""), -1) {
+		link = strings.TrimRight(link, ".,;:!?")
+		if seen[link] || len(link) > maxCitationLength {
+			continue
+		}
+		seen[link] = true
+		r.Citations = append(r.Citations, link)
+	}
+}
+
+// Init 初始化对话记录,未开启 ALL_DIALOG_RECORD_ENABLE 时为空操作
+func Init() error {
+	if config.AllDialogRecordEnable != 1 {
+		return nil
+	}
+	rules, err := parseRedactRules(config.DialogRecordRedact, config.DialogRecordRedactPatterns)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(config.DialogRecordDir, 0755); err != nil {
+		return err
+	}
+	redactRules = rules
+	sink = &lumberjack.Logger{
+		Filename:  filepath.Join(config.DialogRecordDir, recordFile),
+		MaxSize:   config.DialogRecordMaxSize,
+		Compress:  true,
+		LocalTime: true,
+	}
+	return nil
+}
+
+// Enabled 是否已开启对话记录
+func Enabled() bool {
+	return sink != nil
+}
+
+// Write 隐藏个人信息后写入对话记录
+func Write(record *Record) error {
+	if sink == nil {
+		return nil
+	}
+	record.Request = redactJson(record.Request)
+	record.Answer = redact(record.Answer)
+	record.Reasoning = redact(record.Reasoning)
+	bytes, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	_, err = sink.Write(append(bytes, '\n'))
+	return err
+}
+
+// Rotate 轮转对话记录文件,当前文件为空时不轮转
+func Rotate() error {
+	if sink == nil {
+		return nil
+	}
+	info, err := os.Stat(sink.Filename)
+	if err != nil || info.Size() == 0 {
+		return nil
+	}
+	return sink.Rotate()
+}
+
+// Purge 删除超出保留天数的对话记录文件,返回删除的文件数
+func Purge() (int, error) {
+	if sink == nil || config.DialogRecordRetentionDays <= 0 {
+		return 0, nil
+	}
+	before := time.Now().AddDate(0, 0, -config.DialogRecordRetentionDays)
+	files, err := recordFiles()
+	if err != nil {
+		return 0, err
+	}
+	purged := 0
+	for _, file := range files {
+		// 轮转后的文件最后修改时间即其中最后一条记录的时间
+		if file.path == sink.Filename || !file.modTime.Before(before) {
+			continue
+		}
+		if err := os.Remove(file.path); err != nil {
+			return purged, err
+		}
+		purged++
+	}
+	return purged, nil
+}
+
+// Query 对话记录查询条件,为空的条件不过滤
+type Query struct {
+	From      time.Time
+	To        time.Time
+	KeyId     string
+	Model     string
+	RequestId string
+	// Keyword 在请求及回答中查找
+	Keyword string
+	Limit   int
+}
+
+func (q *Query) match(record *Record, line string) bool {
+	if !q.From.IsZero() && record.Time.Before(q.From) {
+		return false
+	}
+	if !q.To.IsZero() && !record.Time.Before(q.To) {
+		return false
+	}
+	if q.KeyId != "" && record.KeyId != q.KeyId {
+		return false
+	}
+	if q.Model != "" && record.Model != q.Model {
+		return false
+	}
+	if q.RequestId != "" && record.RequestId != q.RequestId {
+		return false
+	}
+	return q.Keyword == "" || strings.Contains(line, q.Keyword)
+}
+
+// Search 按时间倒序查找对话记录,最多返回 Limit 条
+func Search(q Query) ([]Record, error) {
+	if sink == nil {
+		return nil, nil
+	}
+	if q.Limit <= 0 {
+		q.Limit = defaultSearchLimit
+	}
+	files, err := recordFiles()
+	if err != nil {
+		return nil, err
+	}
+	var records []Record
+	for _, file := range files {
+		if len(records) >= q.Limit {
+			break
+		}
+		// 文件中的记录均早于其最后修改时间
+		if !q.From.IsZero() && file.modTime.Before(q.From) {
+			break
+		}
+		matched, err := searchFile(file.path, q)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, matched...)
+	}
+	sort.SliceStable(records, func(i, j int) bool {
+		return records[i].Time.After(records[j].Time)
+	})
+	if len(records) > q.Limit {
+		records = records[:q.Limit]
+	}
+	return records, nil
+}
+
+func searchFile(path string, q Query) ([]Record, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer file.Close()
+
+	var reader io.Reader = file
+	if strings.HasSuffix(path, ".gz") {
+		gz, err := gzip.NewReader(file)
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		reader = gz
+	}
+
+	var records []Record
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		var record Record
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			continue
+		}
+		if q.match(&record, line) {
+			records = append(records, record)
+		}
+	}
+	// 文件按时间顺序写入,保留最新的 Limit 条
+	if len(records) > q.Limit {
+		records = records[len(records)-q.Limit:]
+	}
+	return records, scanner.Err()
+}
+
+type recordFileInfo struct {
+	path    string
+	modTime time.Time
+}
+
+// recordFiles 全部对话记录文件,按最后修改时间倒序
+func recordFiles() ([]recordFileInfo, error) {
+	entries, err := os.ReadDir(config.DialogRecordDir)
+	if err != nil {
+		return nil, err
+	}
+	prefix := strings.TrimSuffix(recordFile, filepath.Ext(recordFile))
+	var files []recordFileInfo
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, prefix) || !strings.Contains(name, ".jsonl") {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		files = append(files, recordFileInfo{path: filepath.Join(config.DialogRecordDir, name), modTime: info.ModTime()})
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].modTime.After(files[j].modTime)
+	})
+	return files, nil
+}
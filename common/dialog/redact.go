@@ -0,0 +1,100 @@
+package dialog
+
+import (
+	"encoding/json"
+	"fmt"
+	"genspark2api/common/config"
+	logger "genspark2api/common/loggger"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// builtinRules 内置的个人信息规则
+var builtinRules = map[string]string{
+	"email":     `[A-Za-z0-9._%+\-]+@[A-Za-z0-9.\-]+\.[A-Za-z]{2,}`,
+	"phone":     `(?:\+?86[\s\-]?1[3-9]\d{9}|\b1[3-9]\d{9})\b`,
+	"id_card":   `\b\d{17}[\dXx]\b`,
+	"bank_card": `\b(?:\d[\s\-]?){12,18}\d\b`,
+}
+
+// redactRule 匹配的内容替换为 [名称]
+type redactRule struct {
+	name    string
+	pattern *regexp.Regexp
+}
+
+var redactRules []redactRule
+
+// parseRedactRules 解析 DIALOG_RECORD_REDACT 及 DIALOG_RECORD_REDACT_PATTERNS
+func parseRedactRules(builtin, custom string) ([]redactRule, error) {
+	var rules []redactRule
+	for _, name := range config.SplitList(builtin) {
+		if name == "none" {
+			continue
+		}
+		pattern, ok := builtinRules[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown redact rule %s, should be one of email/phone/id_card/bank_card", name)
+		}
+		rules = append(rules, redactRule{name: name, pattern: regexp.MustCompile(pattern)})
+	}
+
+	if strings.TrimSpace(custom) == "" {
+		return rules, nil
+	}
+	patterns := make(map[string]string)
+	if err := json.Unmarshal([]byte(custom), &patterns); err != nil {
+		return nil, fmt.Errorf("parse DIALOG_RECORD_REDACT_PATTERNS: %v", err)
+	}
+	names := make([]string, 0, len(patterns))
+	for name := range patterns {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		pattern, err := regexp.Compile(patterns[name])
+		if err != nil {
+			return nil, fmt.Errorf("redact rule %s: %v", name, err)
+		}
+		rules = append(rules, redactRule{name: name, pattern: pattern})
+	}
+	return rules, nil
+}
+
+// redact 隐藏个人信息,并与日志一样隐藏cookie、API Key 及 base64 数据
+func redact(s string) string {
+	for _, rule := range redactRules {
+		s = rule.pattern.ReplaceAllString(s, "["+rule.name+"]")
+	}
+	return logger.Redact(s)
+}
+
+// redactJson 隐藏 JSON 中全部字符串的个人信息,不改变 JSON 结构
+func redactJson(data []byte) json.RawMessage {
+	var value interface{}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return nil
+	}
+	bytes, err := json.Marshal(redactValue(value))
+	if err != nil {
+		return nil
+	}
+	return bytes
+}
+
+func redactValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case string:
+		return redact(v)
+	case []interface{}:
+		for i := range v {
+			v[i] = redactValue(v[i])
+		}
+	case map[string]interface{}:
+		for key := range v {
+			v[key] = redactValue(v[key])
+		}
+	}
+	return value
+}
@@ -2,4 +2,12 @@ package helper
 
 const (
 	RequestIdKey = "X-Request-Id"
+	TenantKey    = "tenant"
+	UsageKey     = "usage"
+	// CompletionKey 已返回的回答内容(*strings.Builder)
+	CompletionKey = "completion_content"
+	// ImagesKey 生成的图片链接
+	ImagesKey = "images"
+	// FirstTokenKey 返回首个回答内容的时间
+	FirstTokenKey = "first_token"
 )
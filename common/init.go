@@ -13,6 +13,8 @@ var (
 	PrintVersion = flag.Bool("version", false, "print version and exit")
 	PrintHelp    = flag.Bool("help", false, "print help and exit")
 	LogDir       = flag.String("log-dir", "", "specify the log directory")
+	ConfigFile   = flag.String("config", "", "specify the config file, overrides CONFIG_FILE")
+	PrintConfig  = flag.Bool("print-config", false, "print the effective config with secrets redacted and exit")
 )
 
 // UploadPath Maybe override by ENV_VAR
@@ -22,7 +24,7 @@ func printHelp() {
 	fmt.Println("genspark2api" + Version + "")
 	fmt.Println("Copyright (C) 2024 Dean. All rights reserved.")
 	fmt.Println("GitHub: https://github.com/deanxv/genspark2api ")
-	fmt.Println("Usage: genspark2api [--port <port>] [--log-dir <log directory>] [--version] [--help]")
+	fmt.Println("Usage: genspark2api [--port <port>] [--log-dir <log directory>] [--config <config file>] [--print-config] [--version] [--help]")
 }
 
 func init() {
@@ -0,0 +1,83 @@
+package logger
+
+import (
+	"context"
+	"genspark2api/common/config"
+	"genspark2api/common/helper"
+	"log/slog"
+	"sync"
+)
+
+// 日志字段
+const (
+	FieldRequestId = "request_id"
+	FieldKey       = "key"
+	FieldModel     = "model"
+	FieldCookie    = "cookie"
+)
+
+type fieldsKey struct{}
+
+// fields 请求的日志字段,在请求处理过程中逐步写入
+type fields struct {
+	keys   []string
+	values map[string]string
+	mutex  sync.Mutex
+}
+
+// WithFields 在上下文中创建日志字段,之后可通过 SetField 写入
+func WithFields(ctx context.Context) context.Context {
+	return context.WithValue(ctx, fieldsKey{}, &fields{values: make(map[string]string)})
+}
+
+// SetField 写入日志字段,上下文中没有日志字段时为空操作
+func SetField(ctx context.Context, key, value string) {
+	f, ok := ctx.Value(fieldsKey{}).(*fields)
+	if !ok {
+		return
+	}
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if _, ok := f.values[key]; !ok {
+		f.keys = append(f.keys, key)
+	}
+	f.values[key] = value
+}
+
+// GetField 读取日志字段
+func GetField(ctx context.Context, key string) string {
+	f, ok := ctx.Value(fieldsKey{}).(*fields)
+	if !ok {
+		return ""
+	}
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.values[key]
+}
+
+// SetCookie 写入当前使用的cookie指纹
+func SetCookie(ctx context.Context, cookie string) {
+	SetField(ctx, FieldCookie, config.CookieFingerprint(cookie))
+}
+
+func contextAttrs(ctx context.Context) []slog.Attr {
+	if ctx == nil {
+		return nil
+	}
+	var attrs []slog.Attr
+	if id, ok := ctx.Value(helper.RequestIdKey).(string); ok && id != "" {
+		attrs = append(attrs, slog.String(FieldRequestId, id))
+	}
+	f, ok := ctx.Value(fieldsKey{}).(*fields)
+	if !ok {
+		return attrs
+	}
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	for _, key := range f.keys {
+		if value := f.values[key]; value != "" {
+			attrs = append(attrs, slog.String(key, value))
+		}
+	}
+	return attrs
+}
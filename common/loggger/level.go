@@ -0,0 +1,175 @@
+package logger
+
+import (
+	"fmt"
+	"log/slog"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// 模块路径,按包设置日志级别时包名不含该前缀,如 controller、common/config
+const (
+	modulePrefix  = "genspark2api/"
+	loggerPackage = "common/loggger"
+)
+
+// LevelFatal 致命错误,输出后退出进程
+const LevelFatal = slog.Level(12)
+
+// Levels 日志级别,Packages 按包设置,未设置的包使用 Level
+type Levels struct {
+	Level    string            `json:"level"`
+	Packages map[string]string `json:"packages"`
+}
+
+type levelConfig struct {
+	level    slog.Level
+	packages map[string]slog.Level
+}
+
+var (
+	levels atomic.Pointer[levelConfig]
+	// callerPackages 调用位置对应的包名
+	callerPackages sync.Map
+)
+
+func init() {
+	levels.Store(&levelConfig{level: slog.LevelInfo})
+}
+
+// ParseLevels 解析 LOG_LEVEL,如 info,controller=debug,common/config=warn
+func ParseLevels(s string) (Levels, error) {
+	l := Levels{Packages: make(map[string]string)}
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		pkg, level, ok := strings.Cut(item, "=")
+		if !ok {
+			l.Level = item
+			continue
+		}
+		l.Packages[strings.Trim(strings.TrimSpace(pkg), "/")] = strings.TrimSpace(level)
+	}
+	if _, err := l.config(); err != nil {
+		return Levels{}, err
+	}
+	return l, nil
+}
+
+// String LOG_LEVEL 格式
+func (l Levels) String() string {
+	items := []string{l.Level}
+	for pkg, level := range l.Packages {
+		items = append(items, pkg+"="+level)
+	}
+	sort.Strings(items[1:])
+	return strings.Join(items, ",")
+}
+
+func (l Levels) config() (*levelConfig, error) {
+	c := &levelConfig{level: slog.LevelInfo, packages: make(map[string]slog.Level)}
+	if l.Level != "" {
+		level, err := parseLevel(l.Level)
+		if err != nil {
+			return nil, err
+		}
+		c.level = level
+	}
+	for pkg, s := range l.Packages {
+		if pkg == "" {
+			return nil, fmt.Errorf("empty package name for log level %s", s)
+		}
+		level, err := parseLevel(s)
+		if err != nil {
+			return nil, fmt.Errorf("package %s: %v", pkg, err)
+		}
+		c.packages[pkg] = level
+	}
+	return c, nil
+}
+
+func parseLevel(s string) (slog.Level, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "debug":
+		return slog.LevelDebug, nil
+	case "info":
+		return slog.LevelInfo, nil
+	case "warn", "warning":
+		return slog.LevelWarn, nil
+	case "error", "err":
+		return slog.LevelError, nil
+	}
+	return 0, fmt.Errorf("unknown log level %q, should be one of debug/info/warn/error", s)
+}
+
+func levelName(level slog.Level) string {
+	return strings.ToLower(level.String())
+}
+
+// SetLevels 设置日志级别,立即生效
+func SetLevels(l Levels) error {
+	c, err := l.config()
+	if err != nil {
+		return err
+	}
+	levels.Store(c)
+	return nil
+}
+
+// GetLevels 当前日志级别
+func GetLevels() Levels {
+	c := levels.Load()
+	l := Levels{Level: levelName(c.level), Packages: make(map[string]string, len(c.packages))}
+	for pkg, level := range c.packages {
+		l.Packages[pkg] = levelName(level)
+	}
+	return l
+}
+
+// enabled 判断调用方所在包是否输出该级别的日志
+func enabled(level slog.Level) bool {
+	c := levels.Load()
+	if len(c.packages) == 0 || level >= LevelFatal {
+		return level >= c.level
+	}
+	pkg := callerPackage()
+	min, matched := c.level, ""
+	for p, l := range c.packages {
+		if (pkg == p || strings.HasPrefix(pkg, p+"/")) && len(p) > len(matched) {
+			min, matched = l, p
+		}
+	}
+	return level >= min
+}
+
+// callerPackage 调用日志函数的包名
+func callerPackage() string {
+	var pcs [8]uintptr
+	n := runtime.Callers(3, pcs[:])
+	for _, pc := range pcs[:n] {
+		pkg, ok := callerPackages.Load(pc)
+		if !ok {
+			frame, _ := runtime.CallersFrames([]uintptr{pc}).Next()
+			pkg = funcPackage(frame.Function)
+			callerPackages.Store(pc, pkg)
+		}
+		if pkg != loggerPackage {
+			return pkg.(string)
+		}
+	}
+	return ""
+}
+
+// funcPackage 从函数全名中取包名,如 genspark2api/controller.handleStreamRequest.func1 为 controller
+func funcPackage(function string) string {
+	slash := strings.LastIndex(function, "/")
+	if dot := strings.Index(function[slash+1:], "."); dot >= 0 {
+		function = function[:slash+1+dot]
+	}
+	return strings.TrimPrefix(function, modulePrefix)
+}
@@ -4,100 +4,214 @@ import (
 	"context"
 	"fmt"
 	"genspark2api/common/config"
-	"genspark2api/common/helper"
 	"io"
 	"log"
+	"log/slog"
 	"os"
-	"path/filepath"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gin-gonic/gin"
 )
 
+// 日志格式
 const (
-	loggerDEBUG = "DEBUG"
-	loggerINFO  = "INFO"
-	loggerWarn  = "WARN"
-	loggerError = "ERR"
+	FormatText = "text"
+	FormatJson = "json"
 )
 
 var setupLogOnce sync.Once
 
+var (
+	// stdout INFO 及以下级别的日志,stderr WARN 及以上级别的日志,写入时使用 gin 当前的输出
+	stdout = slog.New(newHandler(writerFunc(func(p []byte) (int, error) { return gin.DefaultWriter.Write(p) })))
+	stderr = slog.New(newHandler(writerFunc(func(p []byte) (int, error) { return gin.DefaultErrorWriter.Write(p) })))
+	// access 访问日志,开启 LOG_SPLIT_ACCESS 时单独写入文件
+	access = slog.New(newHandler(writerFunc(func(p []byte) (int, error) {
+		if accessOutput != nil {
+			return accessOutput.Write(p)
+		}
+		return gin.DefaultWriter.Write(p)
+	})))
+	accessOutput io.Writer
+)
+
+// setOutput 设置日志输出,gin 的日志使用相同的输出
+func setOutput(out, err io.Writer) {
+	gin.DefaultWriter = out
+	gin.DefaultErrorWriter = err
+}
+
+type writerFunc func(p []byte) (int, error)
+
+func (f writerFunc) Write(p []byte) (int, error) {
+	return f(p)
+}
+
+var jsonFormat atomic.Bool
+
+// formatHandler 按 LOG_FORMAT 输出 text 或 json
+type formatHandler struct {
+	text slog.Handler
+	json slog.Handler
+}
+
+func newHandler(w io.Writer) slog.Handler {
+	opts := &slog.HandlerOptions{
+		Level:       slog.LevelDebug,
+		ReplaceAttr: replaceLevel,
+	}
+	return &formatHandler{
+		text: slog.NewTextHandler(w, opts),
+		json: slog.NewJSONHandler(w, opts),
+	}
+}
+
+func (h *formatHandler) handler() slog.Handler {
+	if jsonFormat.Load() {
+		return h.json
+	}
+	return h.text
+}
+
+func (h *formatHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.handler().Enabled(ctx, level)
+}
+
+func (h *formatHandler) Handle(ctx context.Context, r slog.Record) error {
+	return h.handler().Handle(ctx, r)
+}
+
+func (h *formatHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &formatHandler{text: h.text.WithAttrs(attrs), json: h.json.WithAttrs(attrs)}
+}
+
+func (h *formatHandler) WithGroup(name string) slog.Handler {
+	return &formatHandler{text: h.text.WithGroup(name), json: h.json.WithGroup(name)}
+}
+
+// replaceLevel 输出 FATAL 级别名称
+func replaceLevel(groups []string, a slog.Attr) slog.Attr {
+	if a.Key == slog.LevelKey && len(groups) == 0 {
+		if level, ok := a.Value.Any().(slog.Level); ok && level >= LevelFatal {
+			return slog.String(slog.LevelKey, "FATAL")
+		}
+	}
+	return a
+}
+
+// SetFormat 设置日志格式 text/json
+func SetFormat(format string) error {
+	switch strings.ToLower(strings.TrimSpace(format)) {
+	case "", FormatText:
+		jsonFormat.Store(false)
+	case FormatJson:
+		jsonFormat.Store(true)
+	default:
+		return fmt.Errorf("unknown log format %q, should be text or json", format)
+	}
+	return nil
+}
+
 func SetupLogger() {
 	setupLogOnce.Do(func() {
+		if err := SetFormat(config.LogFormat); err != nil {
+			log.Fatal(err)
+		}
+		spec := config.Current().LogLevel
+		if config.DebugEnabled {
+			// DEBUG=true 时默认输出 DEBUG 日志,LOG_LEVEL 中的设置优先
+			spec = "debug," + spec
+		}
+		l, err := ParseLevels(spec)
+		if err == nil {
+			err = SetLevels(l)
+		}
+		if err != nil {
+			log.Fatal(fmt.Sprintf("LOG_LEVEL: %v", err))
+		}
+
+		if LogDir == "" {
+			LogDir = config.LogDir
+		}
 		if LogDir != "" {
-			logPath := filepath.Join(LogDir, fmt.Sprintf("genspark2api-%s.log", time.Now().Format("20060102")))
-			fd, err := os.OpenFile(logPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
-			if err != nil {
-				log.Fatal("failed to open log file")
+			if err := os.MkdirAll(LogDir, 0755); err != nil {
+				log.Fatal(fmt.Sprintf("failed to create log dir: %v", err))
 			}
-			gin.DefaultWriter = io.MultiWriter(os.Stdout, fd)
-			gin.DefaultErrorWriter = io.MultiWriter(os.Stderr, fd)
+			setupLogFiles()
 		}
 	})
 }
 
 func SysLog(s string) {
-	t := time.Now()
-	_, _ = fmt.Fprintf(gin.DefaultWriter, "[SYS] %v | %s \n", t.Format("2006/01/02 - 15:04:05"), s)
+	output(context.Background(), slog.LevelInfo, s)
 }
 
 func SysError(s string) {
-	t := time.Now()
-	_, _ = fmt.Fprintf(gin.DefaultErrorWriter, "[SYS] %v | %s \n", t.Format("2006/01/02 - 15:04:05"), s)
+	output(context.Background(), slog.LevelError, s)
 }
 
 func Debug(ctx context.Context, msg string) {
-	if config.DebugEnabled {
-		logHelper(ctx, loggerDEBUG, msg)
-	}
+	output(ctx, slog.LevelDebug, msg)
 }
 
 func Info(ctx context.Context, msg string) {
-	logHelper(ctx, loggerINFO, msg)
+	output(ctx, slog.LevelInfo, msg)
 }
 
 func Warn(ctx context.Context, msg string) {
-	logHelper(ctx, loggerWarn, msg)
+	output(ctx, slog.LevelWarn, msg)
 }
 
 func Error(ctx context.Context, msg string) {
-	logHelper(ctx, loggerError, msg)
+	output(ctx, slog.LevelError, msg)
 }
 
 func Debugf(ctx context.Context, format string, a ...any) {
-	Debug(ctx, fmt.Sprintf(format, a...))
+	output(ctx, slog.LevelDebug, fmt.Sprintf(format, a...))
 }
 
 func Infof(ctx context.Context, format string, a ...any) {
-	Info(ctx, fmt.Sprintf(format, a...))
+	output(ctx, slog.LevelInfo, fmt.Sprintf(format, a...))
 }
 
 func Warnf(ctx context.Context, format string, a ...any) {
-	Warn(ctx, fmt.Sprintf(format, a...))
+	output(ctx, slog.LevelWarn, fmt.Sprintf(format, a...))
 }
 
 func Errorf(ctx context.Context, format string, a ...any) {
-	Error(ctx, fmt.Sprintf(format, a...))
+	output(ctx, slog.LevelError, fmt.Sprintf(format, a...))
 }
 
-func logHelper(ctx context.Context, level string, msg string) {
-	writer := gin.DefaultErrorWriter
-	if level == loggerINFO {
-		writer = gin.DefaultWriter
+// Access 输出访问日志,不受日志级别限制
+func Access(ctx context.Context, msg string, attrs ...slog.Attr) {
+	write(access, ctx, slog.LevelInfo, msg, attrs...)
+}
+
+func output(ctx context.Context, level slog.Level, msg string) {
+	if !enabled(level) {
+		return
+	}
+	logger := stdout
+	if level >= slog.LevelWarn {
+		logger = stderr
 	}
-	id := ctx.Value(helper.RequestIdKey)
-	if id == nil {
-		id = helper.GenRequestID()
+	write(logger, ctx, level, msg)
+}
+
+func write(logger *slog.Logger, ctx context.Context, level slog.Level, msg string, attrs ...slog.Attr) {
+	if ctx == nil {
+		ctx = context.Background()
 	}
-	now := time.Now()
-	_, _ = fmt.Fprintf(writer, "[%s] %v | %s | %s \n", level, now.Format("2006/01/02 - 15:04:05"), id, msg)
-	SetupLogger()
+	r := slog.NewRecord(time.Now(), level, Redact(strings.TrimSpace(msg)), 0)
+	r.AddAttrs(contextAttrs(ctx)...)
+	r.AddAttrs(attrs...)
+	_ = logger.Handler().Handle(ctx, r)
 }
 
 func FatalLog(v ...any) {
-	t := time.Now()
-	_, _ = fmt.Fprintf(gin.DefaultErrorWriter, "[FATAL] %v | %v \n", t.Format("2006/01/02 - 15:04:05"), v)
+	output(context.Background(), LevelFatal, fmt.Sprint(v...))
 	os.Exit(1)
 }
@@ -0,0 +1,47 @@
+package logger
+
+import (
+	"fmt"
+	"genspark2api/common/config"
+	"regexp"
+	"strings"
+)
+
+// 最短的未带前缀的 base64 长度,更短的字符串不视为 base64 数据
+const minBase64Length = 256
+
+var (
+	cookiePattern     = regexp.MustCompile(`session_id=[^;\s,"'&]+`)
+	apiKeyPattern     = regexp.MustCompile(`sk-[A-Za-z0-9_\-]{16,}`)
+	bearerPattern     = regexp.MustCompile(`(?i)(bearer\s+)[^\s"',]+`)
+	jwtPattern        = regexp.MustCompile(`eyJ[A-Za-z0-9_\-]+\.eyJ[A-Za-z0-9_\-]+\.[A-Za-z0-9_\-]*`)
+	dataUrlPattern    = regexp.MustCompile(`data:([A-Za-z0-9/+.\-]+);base64,[A-Za-z0-9+/]+=*`)
+	rawBase64Pattern  = regexp.MustCompile(fmt.Sprintf(`[A-Za-z0-9+/]{%d,}=*`, minBase64Length))
+	redactionPatterns = []struct {
+		pattern *regexp.Regexp
+		replace func(string) string
+	}{
+		// cookie 替换为指纹,与管理接口及 cookie_failover 事件中的指纹一致
+		{cookiePattern, func(s string) string { return "session_id=[cookie " + config.CookieFingerprint(s) + "]" }},
+		{jwtPattern, func(string) string { return "eyJ****" }},
+		{bearerPattern, nil},
+		{apiKeyPattern, func(s string) string { return s[:7] + "****" }},
+		{dataUrlPattern, func(s string) string {
+			i := strings.Index(s, ";base64,")
+			return fmt.Sprintf("%s;base64,[%d chars]", s[:i], len(s)-i-len(";base64,"))
+		}},
+		{rawBase64Pattern, func(s string) string { return fmt.Sprintf("[base64 %d chars]", len(s)) }},
+	}
+)
+
+// Redact 隐藏日志中的cookie、API Key、JWT 及 base64 数据
+func Redact(s string) string {
+	for _, r := range redactionPatterns {
+		if r.replace == nil {
+			s = r.pattern.ReplaceAllString(s, "${1}****")
+			continue
+		}
+		s = r.pattern.ReplaceAllStringFunc(s, r.replace)
+	}
+	return s
+}
@@ -0,0 +1,87 @@
+package logger
+
+import (
+	"errors"
+	"genspark2api/common/config"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+// 日志文件名,轮转后的文件名为 genspark2api-2006-01-02T15-04-05.000.log
+const (
+	appLogFile    = "genspark2api.log"
+	accessLogFile = "genspark2api-access.log"
+)
+
+var (
+	logFiles      []*lumberjack.Logger
+	logFilesMutex sync.Mutex
+)
+
+// openLogFile 打开 LogDir 下的日志文件,按大小轮转,按时间轮转由 RotateTask 执行
+func openLogFile(name string) io.Writer {
+	file := &lumberjack.Logger{
+		Filename:   filepath.Join(LogDir, name),
+		MaxSize:    config.LogMaxSize,
+		MaxBackups: config.LogMaxBackups,
+		MaxAge:     config.LogMaxAge,
+		Compress:   config.LogCompress == 1,
+		LocalTime:  true,
+	}
+	logFilesMutex.Lock()
+	logFiles = append(logFiles, file)
+	logFilesMutex.Unlock()
+	return file
+}
+
+// setupLogFiles 日志输出到标准输出及 LogDir 下的日志文件
+func setupLogFiles() {
+	app := openLogFile(appLogFile)
+	setOutput(io.MultiWriter(os.Stdout, app), io.MultiWriter(os.Stderr, app))
+	if config.LogSplitAccess == 1 {
+		accessOutput = io.MultiWriter(os.Stdout, openLogFile(accessLogFile))
+	}
+}
+
+// Rotate 立即轮转全部日志文件
+func Rotate() error {
+	logFilesMutex.Lock()
+	defer logFilesMutex.Unlock()
+	var errs []error
+	for _, file := range logFiles {
+		errs = append(errs, file.Rotate())
+	}
+	return errors.Join(errs...)
+}
+
+// Reopen 关闭全部日志文件,下次写入时重新打开,用于 logrotate 等外部工具移动日志文件后
+func Reopen() error {
+	logFilesMutex.Lock()
+	defer logFilesMutex.Unlock()
+	var errs []error
+	for _, file := range logFiles {
+		errs = append(errs, file.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// HasLogFiles 是否输出到日志文件
+func HasLogFiles() bool {
+	logFilesMutex.Lock()
+	defer logFilesMutex.Unlock()
+	return len(logFiles) > 0
+}
+
+// NextRotation 下次按时间轮转的时间,从当天0点起每隔 interval 轮转一次
+func NextRotation(now time.Time, interval time.Duration) time.Time {
+	next := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	for !next.After(now) {
+		next = next.Add(interval)
+	}
+	return next
+}
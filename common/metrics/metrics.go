@@ -0,0 +1,133 @@
+package metrics
+
+import (
+	"genspark2api/common/config"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/samber/lo"
+	"strconv"
+	"time"
+)
+
+const namespace = "genspark2api"
+
+// 上游错误分类
+const (
+	UpstreamRateLimit           = "rate_limit"
+	UpstreamFreeLimit           = "free_limit"
+	UpstreamNotLogin            = "not_login"
+	UpstreamCloudflareChallenge = "cloudflare_challenge"
+	UpstreamCloudflareBlock     = "cloudflare_block"
+	UpstreamServerError         = "server_error"
+	UpstreamServiceUnavailable  = "service_unavailable"
+	UpstreamOverload            = "overload"
+)
+
+var (
+	requestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "requests_total",
+		Help:      "Total number of HTTP requests by route, model and status.",
+	}, []string{"route", "model", "status"})
+
+	requestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "request_duration_seconds",
+		Help:      "HTTP request latency by route, model and status.",
+		Buckets:   []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 20, 30, 60, 120, 300},
+	}, []string{"route", "model", "status"})
+
+	streamTTFT = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "stream_time_to_first_token_seconds",
+		Help:      "Time from request start to the first streamed chunk.",
+		Buckets:   []float64{0.25, 0.5, 1, 2, 3, 5, 8, 13, 20, 30, 60},
+	}, []string{"model"})
+
+	upstreamErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "upstream_errors_total",
+		Help:      "Total number of upstream errors by classification.",
+	}, []string{"type"})
+
+	imagePollDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "image_poll_duration_seconds",
+		Help:      "Duration of polling an image generation task until it finishes.",
+		Buckets:   []float64{1, 2.5, 5, 10, 20, 30, 45, 60, 90, 120, 180, 300},
+	}, []string{"model"})
+)
+
+func init() {
+	prometheus.MustRegister(
+		requestsTotal,
+		requestDuration,
+		streamTTFT,
+		upstreamErrors,
+		imagePollDuration,
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "cookie_pool_size",
+			Help:      "Number of cookies in the pool.",
+		}, func() float64 {
+			return float64(len(config.GetGSCookies()))
+		}),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "cookie_locked",
+			Help:      "Number of cookies locked by upstream rate limiting.",
+		}, func() float64 {
+			if config.StateStore == nil {
+				return 0
+			}
+			return float64(len(lo.Filter(config.GetGSCookies(), func(cookie string, _ int) bool {
+				return config.IsRateLimited(cookie)
+			})))
+		}),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "sessions",
+			Help:      "Number of entries in the session map.",
+		}, func() float64 {
+			if config.GlobalSessionManager == nil {
+				return 0
+			}
+			return float64(config.GlobalSessionManager.Size())
+		}),
+	)
+}
+
+// ObserveRequest 记录一次请求
+func ObserveRequest(route, model string, status int, duration time.Duration) {
+	if route == "" {
+		route = "unmatched"
+	}
+	labels := prometheus.Labels{"route": route, "model": modelLabel(model), "status": strconv.Itoa(status)}
+	requestsTotal.With(labels).Inc()
+	requestDuration.With(labels).Observe(duration.Seconds())
+}
+
+// ObserveTTFT 记录流式请求的首个响应耗时
+func ObserveTTFT(model string, duration time.Duration) {
+	streamTTFT.WithLabelValues(modelLabel(model)).Observe(duration.Seconds())
+}
+
+// UpstreamError 记录一次上游错误
+func UpstreamError(errType string) {
+	upstreamErrors.WithLabelValues(errType).Inc()
+}
+
+// ObserveImagePoll 记录一次生图任务的轮询耗时
+func ObserveImagePoll(model string, duration time.Duration) {
+	imagePollDuration.WithLabelValues(modelLabel(model)).Observe(duration.Seconds())
+}
+
+// modelLabel 仅使用已知模型作为标签,防止请求中的任意模型名导致标签数量无限增长
+func modelLabel(model string) string {
+	if model == "" {
+		return ""
+	}
+	if _, ok := config.Current().Models.Lookup(model); ok {
+		return model
+	}
+	return "other"
+}
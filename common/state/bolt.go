@@ -0,0 +1,262 @@
+package state
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	boltSessionBucket   = []byte("sessions")
+	boltProjectBucket   = []byte("projects")
+	boltModelChatBucket = []byte("model_chats")
+	boltApiKeyBucket    = []byte("api_keys")
+	boltCounterBucket   = []byte("counters")
+	boltUsageBucket     = []byte("usages")
+)
+
+// boltCounter 持久化的计数器
+type boltCounter struct {
+	Value     int64 `json:"value"`
+	ExpiresAt int64 `json:"expires_at"`
+}
+
+// BoltStore 单副本持久化实现,会话映射、对话记录、API Key、计数器及用量记录保存在本地文件中,重启后不丢失
+// cookie 锁定及请求限速仍保存在内存中
+type BoltStore struct {
+	*MemoryStore
+	db *bolt.DB
+}
+
+func NewBoltStore(path string, rateLimitExpirationDuration time.Duration) (*BoltStore, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("open bolt db %s error: %v", path, err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, bucket := range [][]byte{boltSessionBucket, boltProjectBucket, boltModelChatBucket, boltApiKeyBucket, boltCounterBucket, boltUsageBucket} {
+			if _, err := tx.CreateBucketIfNotExists(bucket); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("init bolt db %s error: %v", path, err)
+	}
+	return &BoltStore{
+		MemoryStore: NewMemoryStore(rateLimitExpirationDuration),
+		db:          db,
+	}, nil
+}
+
+func (s *BoltStore) GetSession(key string) (string, bool) {
+	return s.get(boltSessionBucket, key)
+}
+
+func (s *BoltStore) SetSession(key string, value string) {
+	s.put(boltSessionBucket, key, value)
+}
+
+func (s *BoltStore) DeleteSession(key string) {
+	s.delete(boltSessionBucket, key)
+}
+
+func (s *BoltStore) Sessions() map[string]string {
+	return s.all(boltSessionBucket)
+}
+
+func (s *BoltStore) SetProject(id string, value string) {
+	s.put(boltProjectBucket, id, value)
+}
+
+func (s *BoltStore) DeleteProject(id string) {
+	s.delete(boltProjectBucket, id)
+}
+
+func (s *BoltStore) Projects() map[string]string {
+	return s.all(boltProjectBucket)
+}
+
+func (s *BoltStore) GetModelChat(key string) (string, bool) {
+	return s.get(boltModelChatBucket, key)
+}
+
+func (s *BoltStore) SetModelChat(key string, value string) {
+	s.put(boltModelChatBucket, key, value)
+}
+
+func (s *BoltStore) DeleteModelChat(key string) {
+	s.delete(boltModelChatBucket, key)
+}
+
+func (s *BoltStore) ModelChats() map[string]string {
+	return s.all(boltModelChatBucket)
+}
+
+func (s *BoltStore) GetApiKey(key string) (string, bool) {
+	return s.get(boltApiKeyBucket, key)
+}
+
+func (s *BoltStore) SetApiKey(key string, value string) {
+	s.put(boltApiKeyBucket, key, value)
+}
+
+func (s *BoltStore) DeleteApiKey(key string) {
+	s.delete(boltApiKeyBucket, key)
+}
+
+func (s *BoltStore) ApiKeys() map[string]string {
+	return s.all(boltApiKeyBucket)
+}
+
+func (s *BoltStore) IncrCounter(key string, delta int64, ttl time.Duration) int64 {
+	var counter boltCounter
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(boltCounterBucket)
+		now := time.Now()
+		if v := bucket.Get([]byte(key)); v != nil {
+			_ = json.Unmarshal(v, &counter)
+		}
+		if counter.ExpiresAt <= now.UnixMilli() {
+			counter = boltCounter{ExpiresAt: now.Add(ttl).UnixMilli()}
+		}
+		counter.Value += delta
+		value, err := json.Marshal(counter)
+		if err != nil {
+			return err
+		}
+		return bucket.Put([]byte(key), value)
+	})
+	if err != nil {
+		OnError(fmt.Errorf("bolt incr counter error: %v", err))
+	}
+	return counter.Value
+}
+
+func (s *BoltStore) GetCounter(key string) int64 {
+	value, ok := s.get(boltCounterBucket, key)
+	if !ok {
+		return 0
+	}
+	var counter boltCounter
+	if err := json.Unmarshal([]byte(value), &counter); err != nil || counter.ExpiresAt <= time.Now().UnixMilli() {
+		return 0
+	}
+	return counter.Value
+}
+
+func (s *BoltStore) AddUsage(at time.Time, value string) {
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(boltUsageBucket)
+		// 以时间及自增序号作为 key,保证按时间排序且不重复
+		seq, err := bucket.NextSequence()
+		if err != nil {
+			return err
+		}
+		key := append(boltUsageKey(at), make([]byte, 8)...)
+		binary.BigEndian.PutUint64(key[8:], seq)
+		return bucket.Put(key, []byte(value))
+	})
+	if err != nil {
+		OnError(fmt.Errorf("bolt add usage error: %v", err))
+	}
+}
+
+func (s *BoltStore) Usages(from, to time.Time) []string {
+	var usages []string
+	err := s.db.View(func(tx *bolt.Tx) error {
+		cursor := tx.Bucket(boltUsageBucket).Cursor()
+		for k, v := cursor.Seek(boltUsageKey(from)); k != nil && bytes.Compare(k, boltUsageKey(to)) < 0; k, v = cursor.Next() {
+			usages = append(usages, string(v))
+		}
+		return nil
+	})
+	if err != nil {
+		OnError(fmt.Errorf("bolt get usages error: %v", err))
+	}
+	return usages
+}
+
+func (s *BoltStore) DeleteUsagesBefore(before time.Time) int {
+	deleted := 0
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(boltUsageBucket)
+		var keys [][]byte
+		cursor := bucket.Cursor()
+		for k, _ := cursor.First(); k != nil && bytes.Compare(k, boltUsageKey(before)) < 0; k, _ = cursor.Next() {
+			keys = append(keys, append([]byte{}, k...))
+		}
+		for _, k := range keys {
+			if err := bucket.Delete(k); err != nil {
+				return err
+			}
+			deleted++
+		}
+		return nil
+	})
+	if err != nil {
+		OnError(fmt.Errorf("bolt delete usages error: %v", err))
+	}
+	return deleted
+}
+
+func boltUsageKey(t time.Time) []byte {
+	key := make([]byte, 8)
+	if t.After(time.Unix(0, 0)) {
+		binary.BigEndian.PutUint64(key, uint64(t.UnixNano()))
+	}
+	return key
+}
+
+func (s *BoltStore) get(bucket []byte, key string) (string, bool) {
+	var value []byte
+	err := s.db.View(func(tx *bolt.Tx) error {
+		if v := tx.Bucket(bucket).Get([]byte(key)); v != nil {
+			value = append([]byte{}, v...)
+		}
+		return nil
+	})
+	if err != nil {
+		OnError(fmt.Errorf("bolt get %s error: %v", bucket, err))
+		return "", false
+	}
+	return string(value), value != nil
+}
+
+func (s *BoltStore) put(bucket []byte, key string, value string) {
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucket).Put([]byte(key), []byte(value))
+	})
+	if err != nil {
+		OnError(fmt.Errorf("bolt put %s error: %v", bucket, err))
+	}
+}
+
+func (s *BoltStore) delete(bucket []byte, key string) {
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucket).Delete([]byte(key))
+	})
+	if err != nil {
+		OnError(fmt.Errorf("bolt delete %s error: %v", bucket, err))
+	}
+}
+
+func (s *BoltStore) all(bucket []byte) map[string]string {
+	values := make(map[string]string)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucket).ForEach(func(k, v []byte) error {
+			values[string(k)] = string(v)
+			return nil
+		})
+	})
+	if err != nil {
+		OnError(fmt.Errorf("bolt get %s error: %v", bucket, err))
+	}
+	return values
+}
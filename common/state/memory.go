@@ -0,0 +1,327 @@
+package state
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+type memoryCounter struct {
+	value     int64
+	expiresAt time.Time
+}
+
+type memoryUsage struct {
+	at    time.Time
+	value string
+}
+
+// 令牌桶数量超过该值时清理长时间未使用的令牌桶
+const memoryTokenBucketCleanupSize = 10000
+
+type memoryTokenBucket struct {
+	tokens    float64
+	updatedAt time.Time
+}
+
+// MemoryStore 单副本内存实现
+type MemoryStore struct {
+	cookieLocks       sync.Map // key -> time.Time
+	cookieModelDenies sync.Map // key -> time.Time
+	cookieNotLogins   sync.Map // key -> time.Time
+
+	sessions     map[string]string
+	sessionMutex sync.RWMutex
+
+	projects     map[string]string
+	projectMutex sync.RWMutex
+
+	modelChats     map[string]string
+	modelChatMutex sync.RWMutex
+
+	apiKeys     map[string]string
+	apiKeyMutex sync.RWMutex
+
+	counters     map[string]memoryCounter
+	counterMutex sync.Mutex
+
+	usages     []memoryUsage
+	usageMutex sync.RWMutex
+
+	tokenBuckets     map[string]*memoryTokenBucket
+	tokenBucketMutex sync.Mutex
+
+	rateLimiter InMemoryRateLimiter
+}
+
+func NewMemoryStore(rateLimitExpirationDuration time.Duration) *MemoryStore {
+	store := &MemoryStore{
+		sessions:   make(map[string]string),
+		projects:   make(map[string]string),
+		modelChats: make(map[string]string),
+		apiKeys:    make(map[string]string),
+		counters:   make(map[string]memoryCounter),
+
+		tokenBuckets: make(map[string]*memoryTokenBucket),
+	}
+	store.rateLimiter.Init(rateLimitExpirationDuration)
+	return store
+}
+
+func (s *MemoryStore) LockCookie(key string, until time.Time) {
+	s.cookieLocks.Store(key, until)
+}
+
+func (s *MemoryStore) CookieLockedUntil(key string) (time.Time, bool) {
+	return loadUntil(&s.cookieLocks, key)
+}
+
+func (s *MemoryStore) CountLockedCookies() int {
+	count := 0
+	now := time.Now()
+	s.cookieLocks.Range(func(key, value any) bool {
+		if value.(time.Time).After(now) {
+			count++
+		}
+		return true
+	})
+	return count
+}
+
+func (s *MemoryStore) DenyCookieModel(key string, until time.Time) {
+	s.cookieModelDenies.Store(key, until)
+}
+
+func (s *MemoryStore) CookieModelDeniedUntil(key string) (time.Time, bool) {
+	return loadUntil(&s.cookieModelDenies, key)
+}
+
+func (s *MemoryStore) MarkCookieNotLogin(key string, until time.Time) {
+	s.cookieNotLogins.Store(key, until)
+}
+
+func (s *MemoryStore) CookieNotLoginUntil(key string) (time.Time, bool) {
+	return loadUntil(&s.cookieNotLogins, key)
+}
+
+func (s *MemoryStore) ClearCookieNotLogin(key string) {
+	s.cookieNotLogins.Delete(key)
+}
+
+// loadUntil 获取未过期的截止时间,已过期时删除
+func loadUntil(m *sync.Map, key string) (time.Time, bool) {
+	value, ok := m.Load(key)
+	if !ok {
+		return time.Time{}, false
+	}
+	until := value.(time.Time)
+	if !until.After(time.Now()) {
+		// 已过期，删除
+		m.Delete(key)
+		return time.Time{}, false
+	}
+	return until, true
+}
+
+func (s *MemoryStore) GetSession(key string) (string, bool) {
+	s.sessionMutex.RLock()
+	defer s.sessionMutex.RUnlock()
+	value, ok := s.sessions[key]
+	return value, ok
+}
+
+func (s *MemoryStore) SetSession(key string, value string) {
+	s.sessionMutex.Lock()
+	defer s.sessionMutex.Unlock()
+	s.sessions[key] = value
+}
+
+func (s *MemoryStore) DeleteSession(key string) {
+	s.sessionMutex.Lock()
+	defer s.sessionMutex.Unlock()
+	delete(s.sessions, key)
+}
+
+func (s *MemoryStore) Sessions() map[string]string {
+	s.sessionMutex.RLock()
+	defer s.sessionMutex.RUnlock()
+	sessions := make(map[string]string, len(s.sessions))
+	for key, value := range s.sessions {
+		sessions[key] = value
+	}
+	return sessions
+}
+
+func (s *MemoryStore) SetProject(id string, value string) {
+	s.projectMutex.Lock()
+	defer s.projectMutex.Unlock()
+	s.projects[id] = value
+}
+
+func (s *MemoryStore) DeleteProject(id string) {
+	s.projectMutex.Lock()
+	defer s.projectMutex.Unlock()
+	delete(s.projects, id)
+}
+
+func (s *MemoryStore) Projects() map[string]string {
+	s.projectMutex.RLock()
+	defer s.projectMutex.RUnlock()
+	projects := make(map[string]string, len(s.projects))
+	for id, value := range s.projects {
+		projects[id] = value
+	}
+	return projects
+}
+
+func (s *MemoryStore) GetModelChat(key string) (string, bool) {
+	s.modelChatMutex.RLock()
+	defer s.modelChatMutex.RUnlock()
+	value, ok := s.modelChats[key]
+	return value, ok
+}
+
+func (s *MemoryStore) SetModelChat(key string, value string) {
+	s.modelChatMutex.Lock()
+	defer s.modelChatMutex.Unlock()
+	s.modelChats[key] = value
+}
+
+func (s *MemoryStore) DeleteModelChat(key string) {
+	s.modelChatMutex.Lock()
+	defer s.modelChatMutex.Unlock()
+	delete(s.modelChats, key)
+}
+
+func (s *MemoryStore) ModelChats() map[string]string {
+	s.modelChatMutex.RLock()
+	defer s.modelChatMutex.RUnlock()
+	modelChats := make(map[string]string, len(s.modelChats))
+	for key, value := range s.modelChats {
+		modelChats[key] = value
+	}
+	return modelChats
+}
+
+func (s *MemoryStore) GetApiKey(key string) (string, bool) {
+	s.apiKeyMutex.RLock()
+	defer s.apiKeyMutex.RUnlock()
+	value, ok := s.apiKeys[key]
+	return value, ok
+}
+
+func (s *MemoryStore) SetApiKey(key string, value string) {
+	s.apiKeyMutex.Lock()
+	defer s.apiKeyMutex.Unlock()
+	s.apiKeys[key] = value
+}
+
+func (s *MemoryStore) DeleteApiKey(key string) {
+	s.apiKeyMutex.Lock()
+	defer s.apiKeyMutex.Unlock()
+	delete(s.apiKeys, key)
+}
+
+func (s *MemoryStore) ApiKeys() map[string]string {
+	s.apiKeyMutex.RLock()
+	defer s.apiKeyMutex.RUnlock()
+	apiKeys := make(map[string]string, len(s.apiKeys))
+	for key, value := range s.apiKeys {
+		apiKeys[key] = value
+	}
+	return apiKeys
+}
+
+func (s *MemoryStore) IncrCounter(key string, delta int64, ttl time.Duration) int64 {
+	s.counterMutex.Lock()
+	defer s.counterMutex.Unlock()
+	now := time.Now()
+	counter, ok := s.counters[key]
+	if !ok || !counter.expiresAt.After(now) {
+		counter = memoryCounter{expiresAt: now.Add(ttl)}
+	}
+	counter.value += delta
+	s.counters[key] = counter
+	return counter.value
+}
+
+func (s *MemoryStore) GetCounter(key string) int64 {
+	s.counterMutex.Lock()
+	defer s.counterMutex.Unlock()
+	counter, ok := s.counters[key]
+	if !ok {
+		return 0
+	}
+	if !counter.expiresAt.After(time.Now()) {
+		delete(s.counters, key)
+		return 0
+	}
+	return counter.value
+}
+
+func (s *MemoryStore) AddUsage(at time.Time, value string) {
+	s.usageMutex.Lock()
+	defer s.usageMutex.Unlock()
+	// 保持按时间排序
+	i := sort.Search(len(s.usages), func(i int) bool {
+		return s.usages[i].at.After(at)
+	})
+	s.usages = append(s.usages, memoryUsage{})
+	copy(s.usages[i+1:], s.usages[i:])
+	s.usages[i] = memoryUsage{at: at, value: value}
+}
+
+func (s *MemoryStore) Usages(from, to time.Time) []string {
+	s.usageMutex.RLock()
+	defer s.usageMutex.RUnlock()
+	var usages []string
+	for i := sort.Search(len(s.usages), func(i int) bool {
+		return !s.usages[i].at.Before(from)
+	}); i < len(s.usages) && s.usages[i].at.Before(to); i++ {
+		usages = append(usages, s.usages[i].value)
+	}
+	return usages
+}
+
+func (s *MemoryStore) DeleteUsagesBefore(before time.Time) int {
+	s.usageMutex.Lock()
+	defer s.usageMutex.Unlock()
+	n := sort.Search(len(s.usages), func(i int) bool {
+		return !s.usages[i].at.Before(before)
+	})
+	s.usages = append([]memoryUsage{}, s.usages[n:]...)
+	return n
+}
+
+func (s *MemoryStore) TakeTokens(key string, capacity int64, refillPerSecond float64, cost int64, force bool) TokenBucketResult {
+	s.tokenBucketMutex.Lock()
+	defer s.tokenBucketMutex.Unlock()
+
+	now := time.Now()
+	bucket, ok := s.tokenBuckets[key]
+	if !ok {
+		bucket = &memoryTokenBucket{tokens: float64(capacity), updatedAt: now}
+		s.tokenBuckets[key] = bucket
+	}
+	bucket.tokens = math.Min(float64(capacity), bucket.tokens+now.Sub(bucket.updatedAt).Seconds()*refillPerSecond)
+	bucket.updatedAt = now
+
+	allowed := force || bucket.tokens >= math.Max(float64(cost), 1)
+	if allowed {
+		bucket.tokens -= float64(cost)
+	}
+	// 清理长时间未使用的令牌桶,此时桶早已补满,删除不影响限速
+	if len(s.tokenBuckets) > memoryTokenBucketCleanupSize {
+		for k, b := range s.tokenBuckets {
+			if now.Sub(b.updatedAt) > time.Hour {
+				delete(s.tokenBuckets, k)
+			}
+		}
+	}
+	return newTokenBucketResult(allowed, bucket.tokens, capacity, refillPerSecond, cost)
+}
+
+func (s *MemoryStore) Request(key string, maxRequestNum int, duration int64) bool {
+	return s.rateLimiter.Request(key, maxRequestNum, duration)
+}
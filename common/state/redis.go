@@ -0,0 +1,442 @@
+package state
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	redisKeyPrefix         = "genspark2api:"
+	redisCookieLockKey     = redisKeyPrefix + "cookie_lock:"
+	redisCookieDenyKey     = redisKeyPrefix + "cookie_model_deny:"
+	redisCookieNotLoginKey = redisKeyPrefix + "cookie_not_login:"
+	redisSessionKey        = redisKeyPrefix + "sessions"
+	redisProjectKey        = redisKeyPrefix + "projects"
+	redisModelChatKey      = redisKeyPrefix + "model_chats"
+	redisApiKeyKey         = redisKeyPrefix + "api_keys"
+	redisCounterKey        = redisKeyPrefix + "counter:"
+	redisUsageKey          = redisKeyPrefix + "usages"
+	redisTokenBucketKey    = redisKeyPrefix + "token_bucket:"
+	redisRateLimitKey      = redisKeyPrefix + "rate_limit:"
+	redisOperationTimeout  = 3 * time.Second
+)
+
+// 滑动窗口限速: 移除窗口外的请求记录,未超出限制时记录本次请求
+var redisRateLimitScript = redis.NewScript(`
+local key = KEYS[1]
+local now = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+local limit = tonumber(ARGV[3])
+local member = ARGV[4]
+redis.call('ZREMRANGEBYSCORE', key, '-inf', now - window)
+if redis.call('ZCARD', key) >= limit then
+	return 0
+end
+redis.call('ZADD', key, now, member)
+redis.call('PEXPIRE', key, window)
+return 1
+`)
+
+// 计数器增加并在首次创建时设置过期时间
+var redisIncrCounterScript = redis.NewScript(`
+local value = redis.call('INCRBY', KEYS[1], ARGV[1])
+if redis.call('PTTL', KEYS[1]) < 0 then
+	redis.call('PEXPIRE', KEYS[1], ARGV[2])
+end
+return value
+`)
+
+// 令牌桶: 按时间补充令牌,令牌足够(或 force)时扣除,返回 [是否通过, 剩余令牌]
+var redisTokenBucketScript = redis.NewScript(`
+local key = KEYS[1]
+local capacity = tonumber(ARGV[1])
+local rate = tonumber(ARGV[2])
+local cost = tonumber(ARGV[3])
+local force = ARGV[4] == "1"
+local now = tonumber(ARGV[5])
+local bucket = redis.call('HMGET', key, 'tokens', 'ts')
+local tokens = tonumber(bucket[1])
+local ts = tonumber(bucket[2])
+if tokens == nil then
+	tokens = capacity
+	ts = now
+end
+tokens = math.min(capacity, tokens + math.max(0, now - ts) / 1000 * rate)
+local allowed = 0
+if force or tokens >= math.max(cost, 1) then
+	tokens = tokens - cost
+	allowed = 1
+end
+redis.call('HSET', key, 'tokens', tostring(tokens), 'ts', now)
+redis.call('PEXPIRE', key, math.ceil((capacity - tokens) / rate * 1000) + 1000)
+return {allowed, tostring(tokens)}
+`)
+
+// RedisStore 多副本共享的 Redis 实现
+type RedisStore struct {
+	client *redis.Client
+}
+
+// NewRedisStore 根据连接串创建 RedisStore,如 redis://:password@127.0.0.1:6379/0
+func NewRedisStore(connString string) (*RedisStore, error) {
+	options, err := redis.ParseURL(connString)
+	if err != nil {
+		return nil, fmt.Errorf("parse redis conn string error: %v", err)
+	}
+	client := redis.NewClient(options)
+
+	ctx, cancel := context.WithTimeout(context.Background(), redisOperationTimeout)
+	defer cancel()
+	if err := client.Ping(ctx).Err(); err != nil {
+		return nil, fmt.Errorf("ping redis error: %v", err)
+	}
+	return &RedisStore{client: client}, nil
+}
+
+func (s *RedisStore) LockCookie(key string, until time.Time) {
+	if err := s.setUntil(redisCookieLockKey+hashKey(key), until); err != nil {
+		OnError(fmt.Errorf("redis lock cookie error: %v", err))
+	}
+}
+
+func (s *RedisStore) CookieLockedUntil(key string) (time.Time, bool) {
+	until, ok, err := s.getUntil(redisCookieLockKey + hashKey(key))
+	if err != nil {
+		OnError(fmt.Errorf("redis get cookie lock error: %v", err))
+	}
+	return until, ok
+}
+
+func (s *RedisStore) DenyCookieModel(key string, until time.Time) {
+	if err := s.setUntil(redisCookieDenyKey+hashKey(key), until); err != nil {
+		OnError(fmt.Errorf("redis deny cookie model error: %v", err))
+	}
+}
+
+func (s *RedisStore) CookieModelDeniedUntil(key string) (time.Time, bool) {
+	until, ok, err := s.getUntil(redisCookieDenyKey + hashKey(key))
+	if err != nil {
+		OnError(fmt.Errorf("redis get cookie model deny error: %v", err))
+	}
+	return until, ok
+}
+
+func (s *RedisStore) MarkCookieNotLogin(key string, until time.Time) {
+	if err := s.setUntil(redisCookieNotLoginKey+hashKey(key), until); err != nil {
+		OnError(fmt.Errorf("redis mark cookie not login error: %v", err))
+	}
+}
+
+func (s *RedisStore) CookieNotLoginUntil(key string) (time.Time, bool) {
+	until, ok, err := s.getUntil(redisCookieNotLoginKey + hashKey(key))
+	if err != nil {
+		OnError(fmt.Errorf("redis get cookie not login error: %v", err))
+	}
+	return until, ok
+}
+
+func (s *RedisStore) ClearCookieNotLogin(key string) {
+	ctx, cancel := redisContext()
+	defer cancel()
+	if err := s.client.Del(ctx, redisCookieNotLoginKey+hashKey(key)).Err(); err != nil {
+		OnError(fmt.Errorf("redis clear cookie not login error: %v", err))
+	}
+}
+
+// setUntil 保存截止时间,到期后自动删除
+func (s *RedisStore) setUntil(key string, until time.Time) error {
+	ttl := time.Until(until)
+	if ttl <= 0 {
+		return nil
+	}
+	ctx, cancel := redisContext()
+	defer cancel()
+	return s.client.Set(ctx, key, until.UnixMilli(), ttl).Err()
+}
+
+// getUntil 获取未过期的截止时间
+func (s *RedisStore) getUntil(key string) (time.Time, bool, error) {
+	ctx, cancel := redisContext()
+	defer cancel()
+	value, err := s.client.Get(ctx, key).Result()
+	if err != nil {
+		if err == redis.Nil {
+			return time.Time{}, false, nil
+		}
+		return time.Time{}, false, err
+	}
+	millis, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return time.Time{}, false, nil
+	}
+	until := time.UnixMilli(millis)
+	return until, until.After(time.Now()), nil
+}
+
+func (s *RedisStore) CountLockedCookies() int {
+	ctx, cancel := redisContext()
+	defer cancel()
+	count := 0
+	iter := s.client.Scan(ctx, 0, redisCookieLockKey+"*", 100).Iterator()
+	for iter.Next(ctx) {
+		count++
+	}
+	if err := iter.Err(); err != nil {
+		OnError(fmt.Errorf("redis scan cookie locks error: %v", err))
+	}
+	return count
+}
+
+func (s *RedisStore) GetSession(key string) (string, bool) {
+	ctx, cancel := redisContext()
+	defer cancel()
+	value, err := s.client.HGet(ctx, redisSessionKey, key).Result()
+	if err != nil {
+		if err != redis.Nil {
+			OnError(fmt.Errorf("redis get session error: %v", err))
+		}
+		return "", false
+	}
+	return value, true
+}
+
+func (s *RedisStore) SetSession(key string, value string) {
+	ctx, cancel := redisContext()
+	defer cancel()
+	if err := s.client.HSet(ctx, redisSessionKey, key, value).Err(); err != nil {
+		OnError(fmt.Errorf("redis set session error: %v", err))
+	}
+}
+
+func (s *RedisStore) DeleteSession(key string) {
+	ctx, cancel := redisContext()
+	defer cancel()
+	if err := s.client.HDel(ctx, redisSessionKey, key).Err(); err != nil {
+		OnError(fmt.Errorf("redis delete session error: %v", err))
+	}
+}
+
+func (s *RedisStore) Sessions() map[string]string {
+	ctx, cancel := redisContext()
+	defer cancel()
+	sessions, err := s.client.HGetAll(ctx, redisSessionKey).Result()
+	if err != nil {
+		OnError(fmt.Errorf("redis get sessions error: %v", err))
+		return map[string]string{}
+	}
+	return sessions
+}
+
+func (s *RedisStore) SetProject(id string, value string) {
+	ctx, cancel := redisContext()
+	defer cancel()
+	if err := s.client.HSet(ctx, redisProjectKey, id, value).Err(); err != nil {
+		OnError(fmt.Errorf("redis set project error: %v", err))
+	}
+}
+
+func (s *RedisStore) DeleteProject(id string) {
+	ctx, cancel := redisContext()
+	defer cancel()
+	if err := s.client.HDel(ctx, redisProjectKey, id).Err(); err != nil {
+		OnError(fmt.Errorf("redis delete project error: %v", err))
+	}
+}
+
+func (s *RedisStore) Projects() map[string]string {
+	ctx, cancel := redisContext()
+	defer cancel()
+	projects, err := s.client.HGetAll(ctx, redisProjectKey).Result()
+	if err != nil {
+		OnError(fmt.Errorf("redis get projects error: %v", err))
+		return map[string]string{}
+	}
+	return projects
+}
+
+func (s *RedisStore) GetModelChat(key string) (string, bool) {
+	ctx, cancel := redisContext()
+	defer cancel()
+	value, err := s.client.HGet(ctx, redisModelChatKey, key).Result()
+	if err != nil {
+		if err != redis.Nil {
+			OnError(fmt.Errorf("redis get model chat error: %v", err))
+		}
+		return "", false
+	}
+	return value, true
+}
+
+func (s *RedisStore) SetModelChat(key string, value string) {
+	ctx, cancel := redisContext()
+	defer cancel()
+	if err := s.client.HSet(ctx, redisModelChatKey, key, value).Err(); err != nil {
+		OnError(fmt.Errorf("redis set model chat error: %v", err))
+	}
+}
+
+func (s *RedisStore) DeleteModelChat(key string) {
+	ctx, cancel := redisContext()
+	defer cancel()
+	if err := s.client.HDel(ctx, redisModelChatKey, key).Err(); err != nil {
+		OnError(fmt.Errorf("redis delete model chat error: %v", err))
+	}
+}
+
+func (s *RedisStore) ModelChats() map[string]string {
+	ctx, cancel := redisContext()
+	defer cancel()
+	modelChats, err := s.client.HGetAll(ctx, redisModelChatKey).Result()
+	if err != nil {
+		OnError(fmt.Errorf("redis get model chats error: %v", err))
+		return map[string]string{}
+	}
+	return modelChats
+}
+
+func (s *RedisStore) GetApiKey(key string) (string, bool) {
+	ctx, cancel := redisContext()
+	defer cancel()
+	value, err := s.client.HGet(ctx, redisApiKeyKey, key).Result()
+	if err != nil {
+		if err != redis.Nil {
+			OnError(fmt.Errorf("redis get api key error: %v", err))
+		}
+		return "", false
+	}
+	return value, true
+}
+
+func (s *RedisStore) SetApiKey(key string, value string) {
+	ctx, cancel := redisContext()
+	defer cancel()
+	if err := s.client.HSet(ctx, redisApiKeyKey, key, value).Err(); err != nil {
+		OnError(fmt.Errorf("redis set api key error: %v", err))
+	}
+}
+
+func (s *RedisStore) DeleteApiKey(key string) {
+	ctx, cancel := redisContext()
+	defer cancel()
+	if err := s.client.HDel(ctx, redisApiKeyKey, key).Err(); err != nil {
+		OnError(fmt.Errorf("redis delete api key error: %v", err))
+	}
+}
+
+func (s *RedisStore) ApiKeys() map[string]string {
+	ctx, cancel := redisContext()
+	defer cancel()
+	apiKeys, err := s.client.HGetAll(ctx, redisApiKeyKey).Result()
+	if err != nil {
+		OnError(fmt.Errorf("redis get api keys error: %v", err))
+		return map[string]string{}
+	}
+	return apiKeys
+}
+
+func (s *RedisStore) IncrCounter(key string, delta int64, ttl time.Duration) int64 {
+	ctx, cancel := redisContext()
+	defer cancel()
+	value, err := redisIncrCounterScript.Run(ctx, s.client, []string{redisCounterKey + key}, delta, ttl.Milliseconds()).Int64()
+	if err != nil {
+		OnError(fmt.Errorf("redis incr counter error: %v", err))
+		return 0
+	}
+	return value
+}
+
+func (s *RedisStore) GetCounter(key string) int64 {
+	ctx, cancel := redisContext()
+	defer cancel()
+	value, err := s.client.Get(ctx, redisCounterKey+key).Int64()
+	if err != nil {
+		if err != redis.Nil {
+			OnError(fmt.Errorf("redis get counter error: %v", err))
+		}
+		return 0
+	}
+	return value
+}
+
+func (s *RedisStore) AddUsage(at time.Time, value string) {
+	ctx, cancel := redisContext()
+	defer cancel()
+	if err := s.client.ZAdd(ctx, redisUsageKey, redis.Z{Score: float64(at.UnixMilli()), Member: value}).Err(); err != nil {
+		OnError(fmt.Errorf("redis add usage error: %v", err))
+	}
+}
+
+func (s *RedisStore) Usages(from, to time.Time) []string {
+	ctx, cancel := redisContext()
+	defer cancel()
+	usages, err := s.client.ZRangeByScore(ctx, redisUsageKey, &redis.ZRangeBy{
+		Min: strconv.FormatInt(from.UnixMilli(), 10),
+		Max: "(" + strconv.FormatInt(to.UnixMilli(), 10),
+	}).Result()
+	if err != nil {
+		OnError(fmt.Errorf("redis get usages error: %v", err))
+		return nil
+	}
+	return usages
+}
+
+func (s *RedisStore) DeleteUsagesBefore(before time.Time) int {
+	ctx, cancel := redisContext()
+	defer cancel()
+	n, err := s.client.ZRemRangeByScore(ctx, redisUsageKey, "-inf", "("+strconv.FormatInt(before.UnixMilli(), 10)).Result()
+	if err != nil {
+		OnError(fmt.Errorf("redis delete usages error: %v", err))
+		return 0
+	}
+	return int(n)
+}
+
+func (s *RedisStore) TakeTokens(key string, capacity int64, refillPerSecond float64, cost int64, force bool) TokenBucketResult {
+	ctx, cancel := redisContext()
+	defer cancel()
+	forceArg := "0"
+	if force {
+		forceArg = "1"
+	}
+	values, err := redisTokenBucketScript.Run(ctx, s.client, []string{redisTokenBucketKey + hashKey(key)},
+		capacity, refillPerSecond, cost, forceArg, time.Now().UnixMilli()).Slice()
+	if err != nil || len(values) != 2 {
+		// Redis 不可用时不限速
+		OnError(fmt.Errorf("redis token bucket error: %v", err))
+		return TokenBucketResult{Allowed: true, Remaining: capacity}
+	}
+	allowed, _ := values[0].(int64)
+	tokens, _ := strconv.ParseFloat(fmt.Sprint(values[1]), 64)
+	return newTokenBucketResult(allowed == 1, tokens, capacity, refillPerSecond, cost)
+}
+
+func (s *RedisStore) Request(key string, maxRequestNum int, duration int64) bool {
+	ctx, cancel := redisContext()
+	defer cancel()
+	now := time.Now().UnixMilli()
+	member := fmt.Sprintf("%d-%d", now, time.Now().UnixNano())
+	allowed, err := redisRateLimitScript.Run(ctx, s.client, []string{redisRateLimitKey + key},
+		now, duration*1000, maxRequestNum, member).Int()
+	if err != nil {
+		// Redis 不可用时放行,避免影响正常请求
+		OnError(fmt.Errorf("redis rate limit error: %v", err))
+		return true
+	}
+	return allowed == 1
+}
+
+func redisContext() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), redisOperationTimeout)
+}
+
+// hashKey cookie 较长且属于敏感信息,以摘要作为 Redis key
+func hashKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
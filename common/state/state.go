@@ -0,0 +1,109 @@
+package state
+
+import (
+	"math"
+	"time"
+)
+
+// Store 需要在多个副本间共享的运行时状态
+// 默认使用内存实现,配置 REDIS_CONN_STRING 后使用 Redis 实现
+type Store interface {
+	// LockCookie 锁定 cookie(限速、无模型权限等)直到 until
+	LockCookie(key string, until time.Time)
+	// CookieLockedUntil 获取 cookie 的锁定截止时间,未锁定或已过期时返回 false
+	CookieLockedUntil(key string) (time.Time, bool)
+	// CountLockedCookies 当前处于锁定状态的 cookie 数量,不包含 DenyCookieModel 记录的模型禁用
+	CountLockedCookies() int
+
+	// DenyCookieModel 禁止 cookie 请求某模型直到 until,key 包含 cookie 及模型
+	DenyCookieModel(key string, until time.Time)
+	// CookieModelDeniedUntil 获取 cookie 请求某模型的禁用截止时间,未禁用或已过期时返回 false
+	CookieModelDeniedUntil(key string) (time.Time, bool)
+
+	// MarkCookieNotLogin 标记 cookie 已失效(未登录)直到 until
+	MarkCookieNotLogin(key string, until time.Time)
+	// CookieNotLoginUntil 获取 cookie 失效标记的截止时间,未标记或已过期时返回 false
+	CookieNotLoginUntil(key string) (time.Time, bool)
+	// ClearCookieNotLogin 清除 cookie 的失效标记
+	ClearCookieNotLogin(key string)
+
+	// GetSession 获取会话映射
+	GetSession(key string) (string, bool)
+	// SetSession 保存会话映射
+	SetSession(key string, value string)
+	// DeleteSession 删除会话映射
+	DeleteSession(key string)
+	// Sessions 获取全部会话映射
+	Sessions() map[string]string
+
+	// SetProject 记录已创建的 Genspark 对话
+	SetProject(id string, value string)
+	// DeleteProject 删除对话记录
+	DeleteProject(id string)
+	// Projects 获取全部对话记录
+	Projects() map[string]string
+
+	// GetModelChat 获取模型专属对话映射
+	GetModelChat(key string) (string, bool)
+	// SetModelChat 保存模型专属对话映射
+	SetModelChat(key string, value string)
+	// DeleteModelChat 删除模型专属对话映射
+	DeleteModelChat(key string)
+	// ModelChats 获取全部模型专属对话映射
+	ModelChats() map[string]string
+
+	// GetApiKey 获取 API Key,key 为 API Key 的哈希
+	GetApiKey(key string) (string, bool)
+	// SetApiKey 保存 API Key
+	SetApiKey(key string, value string)
+	// DeleteApiKey 删除 API Key
+	DeleteApiKey(key string)
+	// ApiKeys 获取全部 API Key
+	ApiKeys() map[string]string
+
+	// IncrCounter 计数器增加 delta 并返回增加后的值,计数器在 ttl 后过期
+	IncrCounter(key string, delta int64, ttl time.Duration) int64
+	// GetCounter 获取计数器的值,不存在或已过期时返回0
+	GetCounter(key string) int64
+
+	// AddUsage 记录一次请求的用量
+	AddUsage(at time.Time, value string)
+	// Usages 获取 [from, to) 时间范围内的用量记录,按时间排序
+	Usages(from, to time.Time) []string
+	// DeleteUsagesBefore 删除 before 之前的用量记录,返回删除的数量
+	DeleteUsagesBefore(before time.Time) int
+
+	// TakeTokens 令牌桶限速,桶容量为 capacity,每秒补充 refillPerSecond 个令牌
+	// 令牌足够时扣除 cost 个令牌;cost 为0时仅检查是否还有令牌;force 为 true 时总是扣除(可为负数,之后的请求需等待补足)
+	TakeTokens(key string, capacity int64, refillPerSecond float64, cost int64, force bool) TokenBucketResult
+
+	// Request 请求限速,duration 单位为秒,返回 false 表示已超出限制
+	Request(key string, maxRequestNum int, duration int64) bool
+}
+
+// TokenBucketResult 令牌桶限速结果
+type TokenBucketResult struct {
+	Allowed   bool
+	Remaining int64
+	// RetryAfter 未通过时距离令牌足够的时间
+	RetryAfter time.Duration
+	// Reset 距离令牌桶补满的时间
+	Reset time.Duration
+}
+
+// newTokenBucketResult 根据扣除后的令牌数计算限速结果
+func newTokenBucketResult(allowed bool, tokens float64, capacity int64, refillPerSecond float64, cost int64) TokenBucketResult {
+	result := TokenBucketResult{
+		Allowed:   allowed,
+		Remaining: int64(math.Floor(math.Max(tokens, 0))),
+		Reset:     time.Duration((float64(capacity) - tokens) / refillPerSecond * float64(time.Second)),
+	}
+	if !allowed {
+		need := math.Max(float64(cost), 1) - tokens
+		result.RetryAfter = time.Duration(need / refillPerSecond * float64(time.Second))
+	}
+	return result
+}
+
+// OnError Store 内部出错时的回调(如 Redis 不可用),默认忽略
+var OnError = func(err error) {}
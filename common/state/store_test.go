@@ -0,0 +1,265 @@
+package state
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// 设置后同时测试 RedisStore,需使用专用的测试数据库,如 redis://127.0.0.1:6379/15
+const redisTestEnv = "STATE_TEST_REDIS_CONN_STRING"
+
+func TestMemoryStore(t *testing.T) {
+	testStore(t, NewMemoryStore(time.Minute))
+}
+
+func TestBoltStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.db")
+	store, err := NewBoltStore(path, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testStore(t, store)
+
+	// 重新打开后持久化的数据仍然存在
+	store.SetSession("persist", "value")
+	if err := store.db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	store, err = NewBoltStore(path, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = store.db.Close() })
+	if value, ok := store.GetSession("persist"); !ok || value != "value" {
+		t.Fatalf("session after reopen = %q, %v", value, ok)
+	}
+}
+
+func TestRedisStore(t *testing.T) {
+	connString := os.Getenv(redisTestEnv)
+	if connString == "" {
+		t.Skipf("%s is not set", redisTestEnv)
+	}
+	store, err := NewRedisStore(connString)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = store.client.Close() })
+	testStore(t, store)
+}
+
+// testStore 各实现共同的行为,key 带有随机后缀以免与 Redis 中已有的数据冲突
+func testStore(t *testing.T, store Store) {
+	OnError = func(err error) { t.Error(err) }
+	t.Cleanup(func() { OnError = func(err error) {} })
+	suffix := strconv.FormatInt(time.Now().UnixNano(), 36)
+
+	t.Run("cookie lock", func(t *testing.T) {
+		cookie := "cookie-" + suffix
+		locked := store.CountLockedCookies()
+
+		until := time.Now().Add(time.Minute)
+		store.LockCookie(cookie, until)
+		if got, ok := store.CookieLockedUntil(cookie); !ok || got.UnixMilli() != until.UnixMilli() {
+			t.Fatalf("CookieLockedUntil = %v, %v, want %v", got, ok, until)
+		}
+		if got := store.CountLockedCookies(); got != locked+1 {
+			t.Fatalf("CountLockedCookies = %d, want %d", got, locked+1)
+		}
+
+		store.LockCookie("expired-"+suffix, time.Now().Add(-time.Second))
+		if _, ok := store.CookieLockedUntil("expired-" + suffix); ok {
+			t.Fatal("expired lock is still locked")
+		}
+		if _, ok := store.CookieLockedUntil("unknown-" + suffix); ok {
+			t.Fatal("unknown cookie is locked")
+		}
+	})
+
+	t.Run("cookie model deny", func(t *testing.T) {
+		cookie := "deny-" + suffix
+		locked := store.CountLockedCookies()
+
+		store.DenyCookieModel(cookie+"|o1", time.Now().Add(time.Minute))
+		if _, ok := store.CookieModelDeniedUntil(cookie + "|o1"); !ok {
+			t.Fatal("model is not denied")
+		}
+		if _, ok := store.CookieModelDeniedUntil(cookie + "|gpt-4o"); ok {
+			t.Fatal("other model is denied")
+		}
+		// 模型禁用与 cookie 锁定互不影响,也不计入锁定的 cookie 数
+		if _, ok := store.CookieLockedUntil(cookie + "|o1"); ok {
+			t.Fatal("model deny is visible as cookie lock")
+		}
+		if got := store.CountLockedCookies(); got != locked {
+			t.Fatalf("CountLockedCookies = %d, want %d", got, locked)
+		}
+
+		store.DenyCookieModel(cookie+"|expired", time.Now().Add(-time.Second))
+		if _, ok := store.CookieModelDeniedUntil(cookie + "|expired"); ok {
+			t.Fatal("expired deny is still denied")
+		}
+	})
+
+	t.Run("cookie not login", func(t *testing.T) {
+		cookie := "not-login-" + suffix
+		locked := store.CountLockedCookies()
+
+		until := time.Now().Add(time.Minute)
+		store.MarkCookieNotLogin(cookie, until)
+		if got, ok := store.CookieNotLoginUntil(cookie); !ok || got.UnixMilli() != until.UnixMilli() {
+			t.Fatalf("CookieNotLoginUntil = %v, %v, want %v", got, ok, until)
+		}
+		// 失效标记与 cookie 锁定互不影响
+		if _, ok := store.CookieLockedUntil(cookie); ok {
+			t.Fatal("not login mark is visible as cookie lock")
+		}
+		if got := store.CountLockedCookies(); got != locked {
+			t.Fatalf("CountLockedCookies = %d, want %d", got, locked)
+		}
+
+		store.ClearCookieNotLogin(cookie)
+		if _, ok := store.CookieNotLoginUntil(cookie); ok {
+			t.Fatal("cookie is still marked after clear")
+		}
+		store.MarkCookieNotLogin("expired-not-login-"+suffix, time.Now().Add(-time.Second))
+		if _, ok := store.CookieNotLoginUntil("expired-not-login-" + suffix); ok {
+			t.Fatal("expired mark is still marked")
+		}
+	})
+
+	maps := []struct {
+		name string
+		get  func(key string) (string, bool)
+		set  func(key, value string)
+		del  func(key string)
+		all  func() map[string]string
+	}{
+		{"session", store.GetSession, store.SetSession, store.DeleteSession, store.Sessions},
+		{"project", nil, store.SetProject, store.DeleteProject, store.Projects},
+		{"model chat", store.GetModelChat, store.SetModelChat, store.DeleteModelChat, store.ModelChats},
+		{"api key", store.GetApiKey, store.SetApiKey, store.DeleteApiKey, store.ApiKeys},
+	}
+	for _, m := range maps {
+		t.Run(m.name, func(t *testing.T) {
+			key := m.name + "-" + suffix
+			m.set(key, "v1")
+			m.set(key, "v2")
+			if m.get != nil {
+				if value, ok := m.get(key); !ok || value != "v2" {
+					t.Fatalf("get = %q, %v, want v2", value, ok)
+				}
+			}
+			if value := m.all()[key]; value != "v2" {
+				t.Fatalf("all()[%s] = %q, want v2", key, value)
+			}
+
+			// 修改返回的 map 不影响存储
+			m.all()[key] = "modified"
+			if value := m.all()[key]; value != "v2" {
+				t.Fatalf("all()[%s] = %q after modifying the result", key, value)
+			}
+
+			m.del(key)
+			if m.get != nil {
+				if _, ok := m.get(key); ok {
+					t.Fatal("key exists after delete")
+				}
+			}
+			if _, ok := m.all()[key]; ok {
+				t.Fatal("all() contains deleted key")
+			}
+		})
+	}
+
+	t.Run("counter", func(t *testing.T) {
+		key := "counter-" + suffix
+		if got := store.GetCounter(key); got != 0 {
+			t.Fatalf("GetCounter = %d, want 0", got)
+		}
+		store.IncrCounter(key, 2, time.Minute)
+		if got := store.IncrCounter(key, 3, time.Minute); got != 5 {
+			t.Fatalf("IncrCounter = %d, want 5", got)
+		}
+		if got := store.GetCounter(key); got != 5 {
+			t.Fatalf("GetCounter = %d, want 5", got)
+		}
+
+		short := "short-" + suffix
+		store.IncrCounter(short, 1, 50*time.Millisecond)
+		time.Sleep(100 * time.Millisecond)
+		if got := store.GetCounter(short); got != 0 {
+			t.Fatalf("GetCounter after ttl = %d, want 0", got)
+		}
+		if got := store.IncrCounter(short, 1, time.Minute); got != 1 {
+			t.Fatalf("IncrCounter after ttl = %d, want 1", got)
+		}
+	})
+
+	t.Run("usage", func(t *testing.T) {
+		base := time.Date(2001, 1, 1, 0, 0, 0, 0, time.UTC)
+		store.AddUsage(base.Add(2*time.Second), "c-"+suffix)
+		store.AddUsage(base, "a-"+suffix)
+		store.AddUsage(base.Add(time.Second), "b-"+suffix)
+
+		want := []string{"a-" + suffix, "b-" + suffix}
+		if got := store.Usages(base, base.Add(2*time.Second)); !reflect.DeepEqual(got, want) {
+			t.Fatalf("Usages = %v, want %v", got, want)
+		}
+		if got := store.DeleteUsagesBefore(base.Add(time.Second)); got < 1 {
+			t.Fatalf("DeleteUsagesBefore = %d, want at least 1", got)
+		}
+		want = []string{"b-" + suffix, "c-" + suffix}
+		if got := store.Usages(base, base.Add(3*time.Second)); !reflect.DeepEqual(got, want) {
+			t.Fatalf("Usages after delete = %v, want %v", got, want)
+		}
+		store.DeleteUsagesBefore(base.Add(3 * time.Second))
+	})
+
+	t.Run("token bucket", func(t *testing.T) {
+		key := "bucket-" + suffix
+		// 每秒补充 0.001 个令牌,测试期间可忽略
+		for i := 0; i < 2; i++ {
+			if result := store.TakeTokens(key, 2, 0.001, 1, false); !result.Allowed {
+				t.Fatalf("take %d is not allowed", i)
+			}
+		}
+		result := store.TakeTokens(key, 2, 0.001, 1, false)
+		if result.Allowed || result.Remaining != 0 || result.RetryAfter <= 0 {
+			t.Fatalf("take after empty = %+v", result)
+		}
+
+		// cost 为0时仅检查,不扣除令牌
+		check := "check-" + suffix
+		for i := 0; i < 3; i++ {
+			if result := store.TakeTokens(check, 1, 0.001, 0, false); !result.Allowed || result.Remaining != 1 {
+				t.Fatalf("check %d = %+v", i, result)
+			}
+		}
+
+		// force 总是扣除,令牌可为负数
+		force := "force-" + suffix
+		if result := store.TakeTokens(force, 10, 0.001, 15, true); !result.Allowed || result.Remaining != 0 {
+			t.Fatalf("force = %+v", result)
+		}
+		if result := store.TakeTokens(force, 10, 0.001, 0, false); result.Allowed {
+			t.Fatalf("check after force = %+v", result)
+		}
+	})
+
+	t.Run("request", func(t *testing.T) {
+		key := "request-" + suffix
+		for i := 0; i < 2; i++ {
+			if !store.Request(key, 2, 60) {
+				t.Fatalf("request %d is not allowed", i)
+			}
+		}
+		if store.Request(key, 2, 60) {
+			t.Fatal("request over limit is allowed")
+		}
+	})
+}
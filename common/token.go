@@ -7,12 +7,17 @@ import (
 	"genspark2api/model"
 	"github.com/pkoukk/tiktoken-go"
 	"strings"
+	"sync"
+	"sync/atomic"
 )
 
-// tokenEncoderMap won't grow after initialization
-var tokenEncoderMap = map[string]*tiktoken.Tiktoken{}
+// tokenEncoders tiktoken 编码名 -> 编码器
+var tokenEncoders sync.Map
 var defaultTokenEncoder *tiktoken.Tiktoken
 
+// modelEncoders 模型 -> tiktoken 编码名,由模型注册表设置
+var modelEncoders atomic.Pointer[map[string]string]
+
 func InitTokenEncoders() {
 	logger.SysLog("initializing token encoders...")
 	gpt35TokenEncoder, err := tiktoken.EncodingForModel("gpt-3.5-turbo")
@@ -20,41 +25,43 @@ func InitTokenEncoders() {
 		logger.FatalLog(fmt.Sprintf("failed to get gpt-3.5-turbo token encoder: %s", err.Error()))
 	}
 	defaultTokenEncoder = gpt35TokenEncoder
-	gpt4oTokenEncoder, err := tiktoken.EncodingForModel("gpt-4o")
-	if err != nil {
-		logger.FatalLog(fmt.Sprintf("failed to get gpt-4o token encoder: %s", err.Error()))
-	}
-	gpt4TokenEncoder, err := tiktoken.EncodingForModel("gpt-4")
-	if err != nil {
-		logger.FatalLog(fmt.Sprintf("failed to get gpt-4 token encoder: %s", err.Error()))
+	encoders := modelEncoders.Load()
+	if encoders == nil {
+		logger.SysLog("token encoders initialized.")
+		return
 	}
-	for _, model := range DefaultOpenaiModelList {
-		if strings.HasPrefix(model, "gpt-3.5") {
-			tokenEncoderMap[model] = gpt35TokenEncoder
-		} else if strings.HasPrefix(model, "gpt-4o") {
-			tokenEncoderMap[model] = gpt4oTokenEncoder
-		} else if strings.HasPrefix(model, "gpt-4") {
-			tokenEncoderMap[model] = gpt4TokenEncoder
-		} else {
-			tokenEncoderMap[model] = nil
+	for _, encoding := range *encoders {
+		if _, err := loadTokenEncoder(encoding); err != nil {
+			logger.FatalLog(fmt.Sprintf("failed to get %s token encoder: %s", encoding, err.Error()))
 		}
 	}
 	logger.SysLog("token encoders initialized.")
 }
 
-func getTokenEncoder(model string) *tiktoken.Tiktoken {
-	tokenEncoder, ok := tokenEncoderMap[model]
-	if ok && tokenEncoder != nil {
-		return tokenEncoder
+// SetModelEncoders 设置各模型计算 token 数使用的 tiktoken 编码,未设置的模型使用 gpt-3.5-turbo 的编码
+func SetModelEncoders(encoders map[string]string) {
+	modelEncoders.Store(&encoders)
+}
+
+func loadTokenEncoder(encoding string) (*tiktoken.Tiktoken, error) {
+	if tokenEncoder, ok := tokenEncoders.Load(encoding); ok {
+		return tokenEncoder.(*tiktoken.Tiktoken), nil
 	}
-	if ok {
-		tokenEncoder, err := tiktoken.EncodingForModel(model)
-		if err != nil {
-			//logger.SysError(fmt.Sprintf("[IGNORE] | failed to get token encoder for model %s: %s, using encoder for gpt-3.5-turbo", model, err.Error()))
-			tokenEncoder = defaultTokenEncoder
+	tokenEncoder, err := tiktoken.GetEncoding(encoding)
+	if err != nil {
+		return nil, err
+	}
+	tokenEncoders.Store(encoding, tokenEncoder)
+	return tokenEncoder, nil
+}
+
+func getTokenEncoder(model string) *tiktoken.Tiktoken {
+	if encoders := modelEncoders.Load(); encoders != nil {
+		if encoding, ok := (*encoders)[strings.TrimSuffix(model, "-search")]; ok {
+			if tokenEncoder, err := loadTokenEncoder(encoding); err == nil {
+				return tokenEncoder
+			}
 		}
-		tokenEncoderMap[model] = tokenEncoder
-		return tokenEncoder
 	}
 	return defaultTokenEncoder
 }
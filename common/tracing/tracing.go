@@ -0,0 +1,102 @@
+package tracing
+
+import (
+	"context"
+	"genspark2api/common"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+	"go.opentelemetry.io/otel/trace"
+	"os"
+)
+
+const (
+	tracerName  = "genspark2api"
+	serviceName = "genspark2api"
+
+	// GenAIProvider gen_ai.provider.name 的值
+	GenAIProvider = "genspark"
+	// OperationChat/OperationImage gen_ai.operation.name 的值
+	OperationChat  = "chat"
+	OperationImage = "image_generation"
+)
+
+// 自定义属性
+const (
+	AttrAttempt   = attribute.Key("genspark.attempt")
+	AttrCookie    = attribute.Key("genspark.cookie")
+	AttrOutcome   = attribute.Key("genspark.outcome")
+	AttrRequestId = attribute.Key("genspark.request_id")
+	AttrImages    = attribute.Key("genspark.images")
+	AttrTasks     = attribute.Key("genspark.tasks")
+)
+
+// Init 初始化 OpenTelemetry,配置 OTEL_EXPORTER_OTLP_ENDPOINT 或 OTEL_EXPORTER_OTLP_TRACES_ENDPOINT 时通过 OTLP/HTTP 导出,否则输出到标准输出
+// 服务名、采样率等使用 OpenTelemetry 标准环境变量(OTEL_SERVICE_NAME、OTEL_TRACES_SAMPLER 等)
+// 返回的 shutdown 在退出前调用,导出尚未发送的 span
+func Init(ctx context.Context) (shutdown func(context.Context) error, err error) {
+	var exporter sdktrace.SpanExporter
+	if os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") != "" || os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT") != "" {
+		exporter, err = otlptracehttp.New(ctx)
+	} else {
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := resource.New(ctx,
+		resource.WithAttributes(semconv.ServiceName(serviceName), semconv.ServiceVersion(common.Version)),
+		resource.WithFromEnv(),
+		resource.WithTelemetrySDK(),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	return provider.Shutdown, nil
+}
+
+// Start 开始 span,未初始化时为空操作
+func Start(ctx context.Context, name string, opts ...trace.SpanStartOption) (context.Context, trace.Span) {
+	return otel.Tracer(tracerName).Start(ctx, name, opts...)
+}
+
+// End 结束 span,err 不为空时记录错误
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// GenAIAttributes GenAI 语义约定的请求属性
+func GenAIAttributes(operation, model string) []attribute.KeyValue {
+	return []attribute.KeyValue{
+		semconv.GenAIProviderNameKey.String(GenAIProvider),
+		semconv.GenAIOperationNameKey.String(operation),
+		semconv.GenAIRequestModel(model),
+	}
+}
+
+// GenAIUsageAttributes GenAI 语义约定的用量属性
+func GenAIUsageAttributes(model string, inputTokens, outputTokens int) []attribute.KeyValue {
+	return []attribute.KeyValue{
+		semconv.GenAIResponseModel(model),
+		semconv.GenAIUsageInputTokens(inputTokens),
+		semconv.GenAIUsageOutputTokens(outputTokens),
+	}
+}
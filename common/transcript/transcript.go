@@ -0,0 +1,215 @@
+package transcript
+
+import (
+	"encoding/json"
+	"fmt"
+	"genspark2api/model"
+	"strings"
+	"unicode/utf8"
+)
+
+// 截断时每条消息保留的最大字符数
+const truncatedLength = 200
+
+// line 对话历史中的一条消息
+type line struct {
+	text string
+	// ask 最后一条 user 消息,总是保留
+	ask bool
+	// omitted 超出 token 上限被省略
+	omitted bool
+}
+
+// Build 将完整的 OpenAI 对话历史折叠进最后一条 user 消息,countTokens 计算文本的 token 数
+// 超出 maxTokens 时保留 system 消息及最近的消息,更早的消息被省略,并在最早保留的消息前标明省略的数量
+// truncate 为 true 时先将放不下的消息截断为前 truncatedLength 个字符保留(不是语义摘要),预算不足时再省略
+// 最后一条 user 消息之后还有消息(如工具调用及其结果)时,按原顺序放在对话历史中
+func Build(messages []model.OpenAIChatMessage, maxTokens int, truncate bool, countTokens func(string) int) []model.OpenAIChatMessage {
+	lastUser := -1
+	for i := len(messages) - 1; i >= 0; i-- {
+		if messages[i].Role == "user" {
+			lastUser = i
+			break
+		}
+	}
+	if lastUser < 0 || len(messages) == 1 {
+		return messages
+	}
+
+	ask := messages[lastUser]
+	askText := contentText(ask.Content)
+	trailing := lastUser < len(messages)-1
+
+	var systemLines []string
+	var lines []line
+	history := 0
+	for i, message := range messages {
+		if i == lastUser {
+			if trailing {
+				lines = append(lines, line{text: messageLine(message), ask: true})
+			}
+			continue
+		}
+		text := messageLine(message)
+		if text == "" {
+			continue
+		}
+		if message.Role == "system" {
+			systemLines = append(systemLines, text)
+		} else {
+			lines = append(lines, line{text: text})
+			history++
+		}
+	}
+	if len(systemLines) == 0 && history == 0 {
+		return messages
+	}
+
+	budget := maxTokens
+	if !trailing {
+		budget -= countTokens(askText)
+	}
+	for _, text := range systemLines {
+		budget -= countTokens(text)
+	}
+	for _, l := range lines {
+		if l.ask {
+			budget -= countTokens(l.text)
+		}
+	}
+
+	// 从最近的消息开始保留,放不下时截断,仍放不下时省略该消息及更早的消息
+	full := true
+	for i := len(lines) - 1; i >= 0; i-- {
+		l := &lines[i]
+		if l.ask {
+			continue
+		}
+		if full {
+			cost := countTokens(l.text)
+			if maxTokens <= 0 || cost <= budget {
+				budget -= cost
+				continue
+			}
+			full = false
+		}
+		if truncate {
+			truncated := truncateLine(l.text)
+			if cost := countTokens(truncated); cost <= budget {
+				budget -= cost
+				l.text = truncated
+				continue
+			}
+		}
+		for j := i; j >= 0; j-- {
+			lines[j].omitted = !lines[j].ask
+		}
+		break
+	}
+
+	var builder strings.Builder
+	builder.WriteString("<conversation_history>\n")
+	for _, text := range systemLines {
+		builder.WriteString(text + "\n\n")
+	}
+	omitted := 0
+	for _, l := range lines {
+		if l.omitted {
+			omitted++
+			continue
+		}
+		if omitted > 0 {
+			builder.WriteString(fmt.Sprintf("[%d earlier message(s) omitted]\n\n", omitted))
+			omitted = 0
+		}
+		if l.text != "" {
+			builder.WriteString(l.text + "\n\n")
+		}
+	}
+	if omitted > 0 {
+		// 最后一条 user 消息之后的消息全部被省略
+		if trailing {
+			builder.WriteString(fmt.Sprintf("[%d later message(s) omitted]\n\n", omitted))
+		} else {
+			builder.WriteString(fmt.Sprintf("[%d earlier message(s) omitted]\n\n", omitted))
+		}
+	}
+	builder.WriteString("</conversation_history>")
+	transcript := builder.String()
+
+	// 保留最后一条 user 消息中的图片/文件,提问已在对话历史中时不再重复其文本
+	switch content := ask.Content.(type) {
+	case []interface{}:
+		parts := []interface{}{map[string]interface{}{
+			"type": "text",
+			"text": transcript + "\n\n",
+		}}
+		for _, item := range content {
+			if part, ok := item.(map[string]interface{}); trailing && ok && part["type"] == "text" {
+				continue
+			}
+			parts = append(parts, item)
+		}
+		ask.Content = parts
+	default:
+		if trailing {
+			ask.Content = transcript
+		} else {
+			ask.Content = transcript + "\n\n" + askText
+		}
+	}
+	return []model.OpenAIChatMessage{ask}
+}
+
+func messageLine(message model.OpenAIChatMessage) string {
+	text := contentText(message.Content)
+	if message.ToolCalls != nil {
+		if bytes, err := json.Marshal(message.ToolCalls); err == nil {
+			text = strings.TrimSpace(text + "\ntool_calls: " + string(bytes))
+		}
+	}
+	if text == "" {
+		return ""
+	}
+	// tool 消息标明对应的工具调用,多个工具调用时可与 tool_calls 中的 id 对应
+	if message.ToolCallId != "" {
+		return fmt.Sprintf("%s (tool_call_id: %s): %s", message.Role, message.ToolCallId, text)
+	}
+	return message.Role + ": " + text
+}
+
+func contentText(content interface{}) string {
+	switch v := content.(type) {
+	case string:
+		return strings.TrimSpace(v)
+	case []interface{}:
+		var parts []string
+		for _, item := range v {
+			part, ok := item.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			switch part["type"] {
+			case "text":
+				if text, ok := part["text"].(string); ok {
+					parts = append(parts, text)
+				}
+			case "image_url":
+				parts = append(parts, "[image]")
+			case "private_file":
+				parts = append(parts, "[file]")
+			}
+		}
+		return strings.TrimSpace(strings.Join(parts, "\n"))
+	}
+	return ""
+}
+
+// truncateLine 合并空白后截断为前 truncatedLength 个字符
+func truncateLine(text string) string {
+	text = strings.Join(strings.Fields(text), " ")
+	if utf8.RuneCountInString(text) <= truncatedLength {
+		return text
+	}
+	return string([]rune(text)[:truncatedLength]) + "…"
+}
@@ -0,0 +1,167 @@
+package transcript
+
+import (
+	"genspark2api/model"
+	"reflect"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+// countWords 测试中按空白分隔的单词数计算 token 数
+func countWords(text string) int {
+	return len(strings.Fields(text))
+}
+
+func countRunes(text string) int {
+	return utf8.RuneCountInString(text)
+}
+
+func message(role string, content interface{}) model.OpenAIChatMessage {
+	return model.OpenAIChatMessage{Role: role, Content: content}
+}
+
+func buildContent(t *testing.T, messages []model.OpenAIChatMessage, maxTokens int, truncate bool, countTokens func(string) int) interface{} {
+	t.Helper()
+	result := Build(messages, maxTokens, truncate, countTokens)
+	if len(result) != 1 || result[0].Role != "user" {
+		t.Fatalf("Build = %+v, want a single user message", result)
+	}
+	return result[0].Content
+}
+
+func TestBuildWithoutHistory(t *testing.T) {
+	messages := []model.OpenAIChatMessage{message("user", "hi")}
+	if got := Build(messages, 10, false, countWords); !reflect.DeepEqual(got, messages) {
+		t.Fatalf("Build = %+v, want unchanged", got)
+	}
+	messages = []model.OpenAIChatMessage{message("assistant", "hello")}
+	if got := Build(messages, 10, false, countWords); !reflect.DeepEqual(got, messages) {
+		t.Fatalf("Build without user message = %+v, want unchanged", got)
+	}
+}
+
+func TestBuildFullHistory(t *testing.T) {
+	content := buildContent(t, []model.OpenAIChatMessage{
+		message("system", "be brief"),
+		message("user", "q1"),
+		message("assistant", "a1"),
+		message("user", "q2"),
+	}, 0, false, countWords)
+
+	want := "<conversation_history>\nsystem: be brief\n\nuser: q1\n\nassistant: a1\n\n</conversation_history>\n\nq2"
+	if content != want {
+		t.Fatalf("content = %q, want %q", content, want)
+	}
+}
+
+func TestBuildOmitsOldest(t *testing.T) {
+	// 提问 1 + 保留的两条消息各 2,更早的两条消息被省略
+	content := buildContent(t, []model.OpenAIChatMessage{
+		message("system", "rules"),
+		message("user", "one two three"),
+		message("assistant", "four five"),
+		message("user", "six"),
+		message("assistant", "seven"),
+		message("user", "ask"),
+	}, 2+1+2+2, false, countWords)
+
+	want := "<conversation_history>\nsystem: rules\n\n[2 earlier message(s) omitted]\n\nuser: six\n\nassistant: seven\n\n</conversation_history>\n\nask"
+	if content != want {
+		t.Fatalf("content = %q, want %q", content, want)
+	}
+}
+
+func TestBuildTruncate(t *testing.T) {
+	long := strings.Repeat("x", 300)
+	messages := []model.OpenAIChatMessage{
+		message("user", "old"),
+		message("assistant", long),
+		message("user", "recent"),
+		message("user", "go"),
+	}
+	truncated := truncateLine("assistant: " + long)
+	if countRunes(truncated) != truncatedLength+1 {
+		t.Fatalf("truncated length = %d", countRunes(truncated))
+	}
+
+	// 放不下的 assistant 消息截断后保留,更早的消息截断后仍放不下,被省略
+	maxTokens := countRunes("go") + countRunes("user: recent") + countRunes(truncated) + 5
+	content := buildContent(t, messages, maxTokens, true, countRunes)
+	want := "<conversation_history>\n[1 earlier message(s) omitted]\n\n" + truncated + "\n\nuser: recent\n\n</conversation_history>\n\ngo"
+	if content != want {
+		t.Fatalf("content = %q, want %q", content, want)
+	}
+
+	// 未开启截断时直接省略
+	content = buildContent(t, messages, maxTokens, false, countRunes)
+	want = "<conversation_history>\n[2 earlier message(s) omitted]\n\nuser: recent\n\n</conversation_history>\n\ngo"
+	if content != want {
+		t.Fatalf("content without truncate = %q, want %q", content, want)
+	}
+}
+
+func TestBuildTrailingToolMessages(t *testing.T) {
+	messages := []model.OpenAIChatMessage{
+		message("user", "weather?"),
+		{Role: "assistant", ToolCalls: []interface{}{map[string]interface{}{"id": "call_1", "type": "function"}}},
+		{Role: "tool", Content: "sunny", ToolCallId: "call_1"},
+	}
+
+	// 工具调用及结果按原顺序放在提问之后
+	content := buildContent(t, messages, 0, false, countWords)
+	want := "<conversation_history>\nuser: weather?\n\n" +
+		`assistant: tool_calls: [{"id":"call_1","type":"function"}]` + "\n\n" +
+		"tool (tool_call_id: call_1): sunny\n\n</conversation_history>"
+	if content != want {
+		t.Fatalf("content = %q, want %q", content, want)
+	}
+
+	// 提问之后的消息同样受 token 上限限制
+	content = buildContent(t, messages, countWords("user: weather?")+countWords("tool (tool_call_id: call_1): sunny"), false, countWords)
+	want = "<conversation_history>\nuser: weather?\n\n[1 earlier message(s) omitted]\n\n" +
+		"tool (tool_call_id: call_1): sunny\n\n</conversation_history>"
+	if content != want {
+		t.Fatalf("content with budget = %q, want %q", content, want)
+	}
+	content = buildContent(t, messages, countWords("user: weather?"), false, countWords)
+	want = "<conversation_history>\nuser: weather?\n\n[2 later message(s) omitted]\n\n</conversation_history>"
+	if content != want {
+		t.Fatalf("content without budget = %q, want %q", content, want)
+	}
+}
+
+func TestBuildKeepsAttachments(t *testing.T) {
+	image := map[string]interface{}{"type": "image_url", "image_url": map[string]interface{}{"url": "https://example.com/a.png"}}
+	text := map[string]interface{}{"type": "text", "text": "what is it?"}
+	history := []model.OpenAIChatMessage{
+		message("user", "hello"),
+		message("assistant", "hi"),
+	}
+
+	content := buildContent(t, append(history, message("user", []interface{}{text, image})), 0, false, countWords)
+	parts, ok := content.([]interface{})
+	if !ok || len(parts) != 3 {
+		t.Fatalf("content = %+v, want transcript, text and image", content)
+	}
+	if !reflect.DeepEqual(parts[1:], []interface{}{text, image}) {
+		t.Fatalf("parts = %+v", parts[1:])
+	}
+	transcriptText := parts[0].(map[string]interface{})["text"].(string)
+	if !strings.Contains(transcriptText, "user: hello\n\nassistant: hi") {
+		t.Fatalf("transcript = %q", transcriptText)
+	}
+
+	// 提问已在对话历史中时不再重复其文本
+	messages := []model.OpenAIChatMessage{
+		message("user", []interface{}{text, image}),
+		{Role: "tool", Content: "a cat", ToolCallId: "call_1"},
+	}
+	parts = buildContent(t, messages, 0, false, countWords).([]interface{})
+	if len(parts) != 2 || !reflect.DeepEqual(parts[1], image) {
+		t.Fatalf("parts = %+v, want transcript and image", parts)
+	}
+	if transcriptText := parts[0].(map[string]interface{})["text"].(string); !strings.Contains(transcriptText, "user: what is it?\n[image]\n\ntool (tool_call_id: call_1): a cat") {
+		t.Fatalf("transcript = %q", transcriptText)
+	}
+}
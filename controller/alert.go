@@ -0,0 +1,18 @@
+package controller
+
+import (
+	"genspark2api/common/alert"
+	"genspark2api/common/config"
+)
+
+// markNotLogin 标记cookie已失效并告警
+func markNotLogin(cookie string) {
+	config.MarkNotLogin(cookie)
+	alert.NotLogin(cookie)
+}
+
+// markFreeLimit 标记cookie达到免费额度限制并告警
+func markFreeLimit(cookie string, modelName string) {
+	config.MarkFreeLimit(cookie, modelName)
+	alert.FreeLimit(cookie, modelName)
+}
@@ -1,14 +1,295 @@
 package controller
 
 import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"genspark2api/common"
+	"genspark2api/common/alert"
+	"genspark2api/common/config"
+	logger "genspark2api/common/loggger"
+	"genspark2api/common/metrics"
 	"github.com/deanxv/CycleTLS/cycletls"
 	"github.com/gin-gonic/gin"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
 )
 
-// ChatForOpenAI 处理OpenAI聊天请求
+// 创建专属对话时发送的消息
+const provisionPrompt = "Hi"
+
+// ModelChatProvisionStatus 专属对话创建进度
+type ModelChatProvisionStatus struct {
+	Running    bool      `json:"running"`
+	Total      int       `json:"total"`
+	Created    int       `json:"created"`
+	Skipped    int       `json:"skipped"`
+	Failed     int       `json:"failed"`
+	Errors     []string  `json:"errors,omitempty"`
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+}
+
+var (
+	provisionStatus ModelChatProvisionStatus
+	provisionMutex  sync.Mutex
+)
+
+type modelChatProvisionRequest struct {
+	// Cookies cookie指纹或序号,为空时为全部cookie
+	Cookies []string `json:"cookies"`
+	// Models 为空时为全部文本模型
+	Models []string `json:"models"`
+	// Force 已存在专属对话时重新创建
+	Force bool `json:"force"`
+}
+
+type modelChatOverrideRequest struct {
+	Cookie string `json:"cookie"`
+	Model  string `json:"model"`
+	ChatID string `json:"chat_id"`
+}
+
+// GetModelChatMap 查看专属对话映射
+func GetModelChatMap(c *gin.Context) {
+	provisionMutex.Lock()
+	status := provisionStatus
+	provisionMutex.Unlock()
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":   true,
+		"data":      config.ModelChats(),
+		"provision": status,
+	})
+}
+
+// InitModelChatMap 为cookie的文本模型创建专属对话,后台执行,进度可通过 GetModelChatMap 查看
 func InitModelChatMap(c *gin.Context) {
+	var req modelChatProvisionRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.BindJSON(&req); err != nil {
+			return
+		}
+	}
+
+	cookies := config.GetGSCookies()
+	if len(req.Cookies) > 0 {
+		cookies = nil
+		for _, ref := range req.Cookies {
+			cookie, ok := config.FindCookie(ref)
+			if !ok {
+				c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": fmt.Sprintf("cookie %s 不存在", ref)})
+				return
+			}
+			cookies = append(cookies, cookie)
+		}
+	}
+	registry := config.Current().Models
+	models := registry.Ids(config.ModelKindText)
+	if len(req.Models) > 0 {
+		for _, m := range req.Models {
+			if !registry.IsKind(m, config.ModelKindText) {
+				c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": fmt.Sprintf("%s 不是文本模型", m)})
+				return
+			}
+		}
+		models = req.Models
+	}
+
+	if !startModelChatProvision(cookies, models, req.Force) {
+		c.JSON(http.StatusConflict, gin.H{"success": false, "message": "专属对话正在创建中"})
+		return
+	}
+	c.JSON(http.StatusAccepted, gin.H{"success": true, "message": "开始创建专属对话"})
+}
+
+// SetModelChatMap 手动指定cookie下模型的专属对话,chat_id 为空时删除映射
+func SetModelChatMap(c *gin.Context) {
+	var req modelChatOverrideRequest
+	if err := c.BindJSON(&req); err != nil {
+		return
+	}
+	setModelChat(c, req)
+}
+
+// DeleteModelChatMap 删除cookie下模型的专属对话映射
+func DeleteModelChatMap(c *gin.Context) {
+	setModelChat(c, modelChatOverrideRequest{
+		Cookie: c.Query("cookie"),
+		Model:  c.Query("model"),
+	})
+}
+
+func setModelChat(c *gin.Context, req modelChatOverrideRequest) {
+	cookie, ok := config.FindCookie(req.Cookie)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": fmt.Sprintf("cookie %s 不存在", req.Cookie)})
+		return
+	}
+	if !config.Current().Models.IsKind(req.Model, config.ModelKindText) {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": fmt.Sprintf("%s 不是文本模型", req.Model)})
+		return
+	}
+
+	chatId := strings.TrimSpace(req.ChatID)
+	if chatId == "" {
+		config.DeleteModelChat(cookie, req.Model)
+		c.JSON(http.StatusOK, gin.H{"success": true, "message": "已删除专属对话映射"})
+		return
+	}
+	config.SetModelChat(cookie, req.Model, chatId, config.ModelChatSourceManual)
+	config.TrackProject(cookie, req.Model, chatId)
+	c.JSON(http.StatusOK, gin.H{"success": true, "message": "已设置专属对话"})
+}
+
+// ProvisionModelChats 为全部cookie缺少专属对话的文本模型创建对话
+func ProvisionModelChats() {
+	startModelChatProvision(config.GetGSCookies(), config.Current().Models.Ids(config.ModelKindText), false)
+}
+
+// startModelChatProvision 后台创建专属对话,已在执行时返回 false
+func startModelChatProvision(cookies []string, models []string, force bool) bool {
+	provisionMutex.Lock()
+	defer provisionMutex.Unlock()
+	if provisionStatus.Running {
+		return false
+	}
+	provisionStatus = ModelChatProvisionStatus{
+		Running:   true,
+		Total:     len(cookies) * len(models),
+		StartedAt: time.Now(),
+	}
+
+	go func() {
+		first := true
+		for _, cookie := range cookies {
+			for _, m := range models {
+				created, err := provisionModelChat(cookie, m, force, first)
+				if created {
+					first = false
+				}
+
+				provisionMutex.Lock()
+				switch {
+				case err != nil:
+					provisionStatus.Failed++
+					provisionStatus.Errors = append(provisionStatus.Errors, fmt.Sprintf("%s/%s: %v", config.CookieFingerprint(cookie), m, err))
+				case created:
+					provisionStatus.Created++
+				default:
+					provisionStatus.Skipped++
+				}
+				provisionMutex.Unlock()
+			}
+		}
+
+		provisionMutex.Lock()
+		provisionStatus.Running = false
+		provisionStatus.FinishedAt = time.Now()
+		logger.SysLog(fmt.Sprintf("genspark2api model chat provision finished, created: %d, skipped: %d, failed: %d",
+			provisionStatus.Created, provisionStatus.Skipped, provisionStatus.Failed))
+		provisionMutex.Unlock()
+	}()
+	return true
+}
+
+// provisionModelChat 为cookie的模型创建专属对话,返回是否实际创建
+func provisionModelChat(cookie, modelName string, force bool, first bool) (bool, error) {
+	if !force {
+		if _, ok := config.GetModelChat(cookie, modelName); ok {
+			return false, nil
+		}
+	}
+	if !config.IsCookieUsable(cookie) || !config.IsCookieEligible(cookie, modelName) {
+		return false, nil
+	}
+	if !first {
+		// 限速
+		time.Sleep(time.Duration(config.ModelChatProvisionInterval) * time.Millisecond)
+	}
+
+	chatId, err := createModelChat(cookie, modelName)
+	if err != nil {
+		logger.SysError(fmt.Sprintf("genspark2api provision model chat %s for cookie %s err: %v", modelName, config.CookieFingerprint(cookie), err))
+		return true, err
+	}
+	config.SetModelChat(cookie, modelName, chatId, config.ModelChatSourceProvision)
+	config.TrackProject(cookie, modelName, chatId)
+	logger.SysLog(fmt.Sprintf("genspark2api provision model chat %s for cookie %s: %s", modelName, config.CookieFingerprint(cookie), chatId))
+	return true, nil
+}
+
+// createModelChat 以指定模型发起一次对话,返回新建的对话ID
+func createModelChat(cookie, modelName string) (string, error) {
 	client := cycletls.Init()
 	defer safeClose(client)
 
-	// TODO
+	requestBody, err := cheatRequestBody(context.Background(), map[string]interface{}{
+		"type":                 chatType,
+		"current_query_string": fmt.Sprintf("type=%s", chatType),
+		"messages": []map[string]interface{}{{
+			"role":    "user",
+			"content": provisionPrompt,
+		}},
+		"action_params": map[string]interface{}{},
+		"extra_data": map[string]interface{}{
+			"models":                 []string{modelName},
+			"run_with_another_model": false,
+			"writingContent":         nil,
+			"request_web_knowledge":  false,
+		},
+	})
+	if err != nil {
+		return "", err
+	}
+	jsonData, err := json.Marshal(requestBody)
+	if err != nil {
+		return "", err
+	}
+
+	response, err := makeRequest(client, jsonData, cookie, false)
+	if err != nil {
+		return "", err
+	}
+
+	scanner := bufio.NewScanner(strings.NewReader(response.Body))
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case common.IsCloudflareChallenge(line):
+			metrics.UpstreamError(metrics.UpstreamCloudflareChallenge)
+			alert.CloudflareChallenge()
+			return "", fmt.Errorf("cloudflare blocked")
+		case common.IsCloudflareBlock(line):
+			metrics.UpstreamError(metrics.UpstreamCloudflareBlock)
+			return "", fmt.Errorf("cloudflare blocked")
+		case common.IsRateLimit(line):
+			metrics.UpstreamError(metrics.UpstreamRateLimit)
+			config.AddRateLimitCookie(cookie, time.Now().Add(time.Duration(config.Current().RateLimitCookieLockDuration)*time.Second))
+			return "", fmt.Errorf("cookie rate limited")
+		case common.IsFreeLimit(line):
+			metrics.UpstreamError(metrics.UpstreamFreeLimit)
+			markFreeLimit(cookie, modelName)
+			return "", fmt.Errorf("cookie free limited")
+		case common.IsNotLogin(line):
+			metrics.UpstreamError(metrics.UpstreamNotLogin)
+			markNotLogin(cookie)
+			return "", fmt.Errorf("cookie not login")
+		case strings.HasPrefix(line, "data: "):
+			var event struct {
+				Type string `json:"type"`
+				Id   string `json:"id"`
+			}
+			if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &event); err != nil {
+				continue
+			}
+			if event.Type == "project_start" && event.Id != "" {
+				return event.Id, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("no project id in response, status: %d", response.Status)
 }
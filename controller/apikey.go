@@ -0,0 +1,173 @@
+package controller
+
+import (
+	"errors"
+	"genspark2api/common/config"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// apiKeyRequest 创建/修改 API Key 的请求,修改时仅更新传入的字段
+type apiKeyRequest struct {
+	Name              *string    `json:"name"`
+	Enabled           *bool      `json:"enabled"`
+	ExpiresAt         *time.Time `json:"expires_at"`
+	ClearExpiresAt    bool       `json:"clear_expires_at"`
+	AllowedModels     *[]string  `json:"allowed_models"`
+	MaxConcurrency    *int       `json:"max_concurrency"`
+	MonthlyTokenQuota *int64     `json:"monthly_token_quota"`
+	RPM               *int       `json:"rpm"`
+	TPM               *int       `json:"tpm"`
+	MaxStreams        *int       `json:"max_streams"`
+	// DisableDialogRecord 不记录该 Key 的对话
+	DisableDialogRecord *bool `json:"disable_dialog_record"`
+}
+
+// apiKeyResponse API Key 及其本月用量
+type apiKeyResponse struct {
+	*config.ApiKey
+	MonthlyTokensUsed int64 `json:"monthly_tokens_used"`
+}
+
+func newApiKeyResponse(apiKey *config.ApiKey) apiKeyResponse {
+	return apiKeyResponse{
+		ApiKey:            apiKey,
+		MonthlyTokensUsed: config.TenantMonthlyTokens(apiKey.ID),
+	}
+}
+
+// ListApiKeys 查看全部 API Key
+func ListApiKeys(c *gin.Context) {
+	data := make([]apiKeyResponse, 0)
+	for _, apiKey := range config.ListApiKeys() {
+		data = append(data, newApiKeyResponse(apiKey))
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": data})
+}
+
+// GetApiKey 查看 API Key
+func GetApiKey(c *gin.Context) {
+	apiKey, ok := config.GetApiKey(c.Param("id"))
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"success": false, "message": "API Key 不存在"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": newApiKeyResponse(apiKey)})
+}
+
+// CreateApiKey 创建 API Key,明文 Key 仅在创建时返回
+func CreateApiKey(c *gin.Context) {
+	var req apiKeyRequest
+	if err := c.BindJSON(&req); err != nil {
+		return
+	}
+	if req.Name == nil || strings.TrimSpace(*req.Name) == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": "name 不能为空"})
+		return
+	}
+
+	apiKey := &config.ApiKey{Enabled: true}
+	if !applyApiKeyRequest(c, apiKey, req) {
+		return
+	}
+	key, err := config.CreateApiKey(apiKey)
+	if errors.Is(err, config.ErrApiKeyNotPersistent) {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "message": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": newApiKeyResponse(apiKey), "key": key})
+}
+
+// UpdateApiKey 修改 API Key
+func UpdateApiKey(c *gin.Context) {
+	apiKey, ok := config.GetApiKey(c.Param("id"))
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"success": false, "message": "API Key 不存在"})
+		return
+	}
+	var req apiKeyRequest
+	if err := c.BindJSON(&req); err != nil {
+		return
+	}
+	if !applyApiKeyRequest(c, apiKey, req) {
+		return
+	}
+	if err := config.SaveApiKey(apiKey); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "message": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": newApiKeyResponse(apiKey)})
+}
+
+// DeleteApiKey 删除 API Key
+func DeleteApiKey(c *gin.Context) {
+	if !config.DeleteApiKey(c.Param("id")) {
+		c.JSON(http.StatusNotFound, gin.H{"success": false, "message": "API Key 不存在"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "message": "已删除 API Key"})
+}
+
+func applyApiKeyRequest(c *gin.Context, apiKey *config.ApiKey, req apiKeyRequest) bool {
+	if req.Name != nil {
+		if strings.TrimSpace(*req.Name) == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": "name 不能为空"})
+			return false
+		}
+		apiKey.Name = strings.TrimSpace(*req.Name)
+	}
+	if req.Enabled != nil {
+		apiKey.Enabled = *req.Enabled
+	}
+	if req.ExpiresAt != nil {
+		apiKey.ExpiresAt = req.ExpiresAt
+	}
+	if req.ClearExpiresAt {
+		apiKey.ExpiresAt = nil
+	}
+	if req.AllowedModels != nil {
+		apiKey.AllowedModels = *req.AllowedModels
+	}
+	if req.DisableDialogRecord != nil {
+		apiKey.DisableDialogRecord = *req.DisableDialogRecord
+	}
+	if req.MaxConcurrency != nil {
+		if *req.MaxConcurrency < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": "max_concurrency 不能小于0"})
+			return false
+		}
+		apiKey.MaxConcurrency = *req.MaxConcurrency
+	}
+	if req.MonthlyTokenQuota != nil {
+		if *req.MonthlyTokenQuota < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": "monthly_token_quota 不能小于0"})
+			return false
+		}
+		apiKey.MonthlyTokenQuota = *req.MonthlyTokenQuota
+	}
+	for _, limit := range []struct {
+		name  string
+		value *int
+		field *int
+	}{
+		{"rpm", req.RPM, &apiKey.RPM},
+		{"tpm", req.TPM, &apiKey.TPM},
+		{"max_streams", req.MaxStreams, &apiKey.MaxStreams},
+	} {
+		if limit.value == nil {
+			continue
+		}
+		if *limit.value < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": limit.name + " 不能小于0"})
+			return false
+		}
+		*limit.field = *limit.value
+	}
+	return true
+}
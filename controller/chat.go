@@ -3,16 +3,23 @@ package controller
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"genspark2api/common"
+	"genspark2api/common/alert"
 	"genspark2api/common/config"
+	"genspark2api/common/helper"
 	logger "genspark2api/common/loggger"
+	"genspark2api/common/metrics"
+	"genspark2api/common/tracing"
+	"genspark2api/common/transcript"
 	"genspark2api/model"
 	"github.com/deanxv/CycleTLS/cycletls"
 	"github.com/gin-gonic/gin"
 	"github.com/samber/lo"
+	"go.opentelemetry.io/otel/attribute"
 	"io"
 	"io/ioutil"
 	"net/http"
@@ -62,22 +69,61 @@ func ChatForOpenAI(c *gin.Context) {
 		return
 	}
 
-	// 模型映射
-	if strings.HasPrefix(openAIReq.Model, "deepseek") {
-		openAIReq.Model = strings.Replace(openAIReq.Model, "deepseek", "deep-seek", 1)
+	// 模型别名转换为模型 id
+	openAIReq.Model = config.Current().Models.Resolve(openAIReq.Model)
+
+	getUsage(c).Model = openAIReq.Model
+	logger.SetField(c.Request.Context(), logger.FieldModel, openAIReq.Model)
+	getUsage(c).Stream = openAIReq.Stream
+	if !checkTenantModel(c, openAIReq.Model) {
+		return
+	}
+
+	fallback := newModelFallback(c, &openAIReq)
+	for {
+		c.Header(modelHeader, openAIReq.Model)
+		reason := chatCompletion(c, client, openAIReq, fallback)
+		if reason == "" {
+			return
+		}
+		next, err := fallback.next()
+		if err != nil {
+			logger.Errorf(c.Request.Context(), "fallback err: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		logger.Warnf(c.Request.Context(), "Model %s %s, fallback to %s", openAIReq.Model, reason, next.Model)
+		if !checkFallbackModel(c, next.Model) {
+			return
+		}
+		openAIReq = next
+		getUsage(c).Model = openAIReq.Model
+		logger.SetField(c.Request.Context(), logger.FieldModel, openAIReq.Model)
 	}
+}
 
+// chatCompletion 使用 openAIReq.Model 完成对话,需要降级时不返回响应,返回降级原因
+func chatCompletion(c *gin.Context, client cycletls.CycleTLS, openAIReq model.OpenAIChatCompletionRequest, fallback *modelFallback) string {
 	// 初始化cookie
 
-	cookieManager := config.NewCookieManager()
+	cookieManager := config.NewCookieManager(openAIReq.Model)
 	cookie, err := cookieManager.GetRandomCookie()
 	if err != nil {
 		logger.Errorf(c.Request.Context(), "Failed to get initial cookie: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": errNoValidCookies})
-		return
+		if fallback.allows(c, config.FallbackPoolExhausted) {
+			return config.FallbackPoolExhausted
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("%s: %v", errNoValidCookies, err)})
+		return ""
 	}
 
-	if lo.Contains(common.ImageModelList, openAIReq.Model) {
+	// 按对话映射时固定使用对话所在的cookie
+	conversation := newConversation(c, &openAIReq)
+	if conversation != nil && conversation.Cookie != "" && lo.Contains(cookieManager.Cookies, conversation.Cookie) {
+		cookie = conversation.Cookie
+	}
+
+	if config.Current().Models.IsKind(openAIReq.Model, config.ModelKindImage) {
 		responseId := fmt.Sprintf(responseIDFormat, time.Now().Format("20060102150405"))
 
 		if len(openAIReq.GetUserContent()) == 0 {
@@ -89,14 +135,14 @@ func ChatForOpenAI(c *gin.Context) {
 					Code:    "500",
 				},
 			})
-			return
+			return ""
 		}
 
 		jsonData, err := json.Marshal(openAIReq.GetUserContent()[0])
 		if err != nil {
 			logger.Errorf(c.Request.Context(), err.Error())
 			c.JSON(500, gin.H{"error": "Failed to marshal request body"})
-			return
+			return ""
 		}
 		resp, err := ImageProcess(c, client, model.OpenAIImagesGenerationRequest{
 			Model:  openAIReq.Model,
@@ -112,16 +158,21 @@ func ChatForOpenAI(c *gin.Context) {
 					Code:    "500",
 				},
 			})
-			return
+			return ""
 		} else {
 			data := resp.Data
+			getUsage(c).Images = len(data)
 			var content []string
+			var images []string
 			for _, item := range data {
 				content = append(content, fmt.Sprintf("![Image](%s)", item.URL))
+				images = append(images, item.URL)
 			}
+			c.Set(helper.ImagesKey, images)
 
 			if openAIReq.Stream {
 				streamResp := createStreamResponse(responseId, openAIReq.Model, jsonData, model.OpenAIDelta{Content: strings.Join(content, "\n"), Role: "assistant"}, nil)
+				recordUsage(c, streamResp.Usage.PromptTokens, streamResp.Usage.CompletionTokens)
 				err := sendSSEvent(c, streamResp)
 				if err != nil {
 					logger.Errorf(c.Request.Context(), err.Error())
@@ -132,15 +183,17 @@ func ChatForOpenAI(c *gin.Context) {
 							Code:    "500",
 						},
 					})
-					return
+					return ""
 				}
 				c.SSEvent("", " [DONE]")
-				return
+				return ""
 			} else {
 
 				jsonBytes, _ := json.Marshal(openAIReq.Messages)
 				promptTokens := common.CountTokenText(string(jsonBytes), openAIReq.Model)
 				completionTokens := common.CountTokenText(strings.Join(content, "\n"), openAIReq.Model)
+				recordUsage(c, promptTokens, completionTokens)
+				appendCompletionContent(c, strings.Join(content, "\n"))
 
 				finishReason := "stop"
 				// 创建并返回 OpenAIChatCompletionResponse 结构
@@ -165,7 +218,7 @@ func ChatForOpenAI(c *gin.Context) {
 					},
 				}
 				c.JSON(200, resp)
-				return
+				return ""
 			}
 
 		}
@@ -176,11 +229,11 @@ func ChatForOpenAI(c *gin.Context) {
 		isSearchModel = true
 	}
 
-	requestBody, err := createRequestBody(c, client, cookie, &openAIReq)
+	requestBody, err := createRequestBody(c, client, cookie, &openAIReq, conversation)
 
 	if err != nil {
 		c.JSON(500, gin.H{"error": err.Error()})
-		return
+		return ""
 	}
 
 	//jsonData, err := json.Marshal(requestBody)
@@ -190,10 +243,9 @@ func ChatForOpenAI(c *gin.Context) {
 	//}
 
 	if openAIReq.Stream {
-		handleStreamRequest(c, client, cookie, cookieManager, requestBody, openAIReq.Model, isSearchModel)
-	} else {
-		handleNonStreamRequest(c, client, cookie, cookieManager, requestBody, openAIReq.Model, isSearchModel)
+		return handleStreamRequest(c, client, cookie, cookieManager, requestBody, openAIReq.Model, isSearchModel, conversation, fallback)
 	}
+	return handleNonStreamRequest(c, client, cookie, cookieManager, requestBody, openAIReq.Model, isSearchModel, conversation, fallback)
 
 }
 
@@ -227,7 +279,9 @@ func processUrl(c *gin.Context, client cycletls.CycleTLS, cookie string, url str
 	// 判断是否为URL
 	if strings.HasPrefix(url, "http://") || strings.HasPrefix(url, "https://") {
 		// 下载文件
+		endFetch := startSpan(c, "fetch_image")
 		bytes, err := fetchImageBytes(url)
+		endFetch(err)
 		if err != nil {
 			logger.Errorf(c.Request.Context(), fmt.Sprintf("fetchImageBytes err  %v\n", err))
 			return fmt.Errorf("fetchImageBytes err  %v\n", err)
@@ -271,56 +325,66 @@ func processBytes(c *gin.Context, client cycletls.CycleTLS, cookie string, bytes
 		// 是图片类型，转换为base64
 		base64Data := "data:image/jpeg;base64," + base64.StdEncoding.EncodeToString(bytes)
 		imageMap["url"] = base64Data
-	} else {
-		response, err := makeGetUploadUrlRequest(client, cookie)
-		if err != nil {
-			logger.Errorf(c.Request.Context(), fmt.Sprintf("makeGetUploadUrlRequest err  %v\n", err))
-			return fmt.Errorf("makeGetUploadUrlRequest err: %v\n", err)
-		}
+		return nil
+	}
 
-		var jsonResponse map[string]interface{}
-		if err := json.Unmarshal([]byte(response.Body), &jsonResponse); err != nil {
-			logger.Errorf(c.Request.Context(), fmt.Sprintf("Unmarshal err  %v\n", err))
-			return fmt.Errorf("Unmarshal err: %v\n", err)
-		}
+	end := startSpan(c, "upload", attribute.Int("size", len(bytes)))
+	privateFile, err := uploadPrivateFile(c, client, cookie, bytes, contentType)
+	end(err)
+	if err != nil {
+		return err
+	}
+	// 替换数组中的元素
+	contentArray[index] = privateFile
+	return nil
+}
 
-		uploadImageUrl, ok := jsonResponse["data"].(map[string]interface{})["upload_image_url"].(string)
-		privateStorageUrl, ok := jsonResponse["data"].(map[string]interface{})["private_storage_url"].(string)
+// uploadPrivateFile 上传非图片文件,返回 private_file 格式的内容
+func uploadPrivateFile(c *gin.Context, client cycletls.CycleTLS, cookie string, bytes []byte, contentType string) (map[string]interface{}, error) {
+	response, err := makeGetUploadUrlRequest(client, cookie)
+	if err != nil {
+		logger.Errorf(c.Request.Context(), fmt.Sprintf("makeGetUploadUrlRequest err  %v\n", err))
+		return nil, fmt.Errorf("makeGetUploadUrlRequest err: %v\n", err)
+	}
 
-		if !ok {
-			//fmt.Println("Failed to extract upload_image_url")
-			return fmt.Errorf("Failed to extract upload_image_url")
-		}
+	var jsonResponse map[string]interface{}
+	if err := json.Unmarshal([]byte(response.Body), &jsonResponse); err != nil {
+		logger.Errorf(c.Request.Context(), fmt.Sprintf("Unmarshal err  %v\n", err))
+		return nil, fmt.Errorf("Unmarshal err: %v\n", err)
+	}
 
-		// 发送OPTIONS预检请求
-		//_, err = makeOptionsRequest(client, uploadImageUrl)
-		//if err != nil {
-		//	return
-		//}
-		// 上传文件
-		_, err = makeUploadRequest(client, uploadImageUrl, bytes)
-		if err != nil {
-			logger.Errorf(c.Request.Context(), fmt.Sprintf("makeUploadRequest err  %v\n", err))
-			return fmt.Errorf("makeUploadRequest err: %v\n", err)
-		}
-		//fmt.Println(resp)
-
-		// 创建新的 private_file 格式的内容
-		privateFile := map[string]interface{}{
-			"type": "private_file",
-			"private_file": map[string]interface{}{
-				"name":                "file", // 你可能需要从原始文件名或其他地方获取
-				"type":                contentType,
-				"size":                len(bytes),
-				"ext":                 strings.Split(contentType, "/")[1], // 简单处理，可能需要更复杂的逻辑
-				"private_storage_url": privateStorageUrl,
-			},
-		}
+	uploadImageUrl, ok := jsonResponse["data"].(map[string]interface{})["upload_image_url"].(string)
+	privateStorageUrl, ok := jsonResponse["data"].(map[string]interface{})["private_storage_url"].(string)
 
-		// 替换数组中的元素
-		contentArray[index] = privateFile
+	if !ok {
+		//fmt.Println("Failed to extract upload_image_url")
+		return nil, fmt.Errorf("Failed to extract upload_image_url")
 	}
-	return nil
+
+	// 发送OPTIONS预检请求
+	//_, err = makeOptionsRequest(client, uploadImageUrl)
+	//if err != nil {
+	//	return
+	//}
+	// 上传文件
+	_, err = makeUploadRequest(client, cookie, uploadImageUrl, bytes)
+	if err != nil {
+		logger.Errorf(c.Request.Context(), fmt.Sprintf("makeUploadRequest err  %v\n", err))
+		return nil, fmt.Errorf("makeUploadRequest err: %v\n", err)
+	}
+	//fmt.Println(resp)
+
+	// 创建新的 private_file 格式的内容
+	return map[string]interface{}{
+		"type": "private_file",
+		"private_file": map[string]interface{}{
+			"name":                "file", // 你可能需要从原始文件名或其他地方获取
+			"type":                contentType,
+			"size":                len(bytes),
+			"ext":                 strings.Split(contentType, "/")[1], // 简单处理，可能需要更复杂的逻辑
+			"private_storage_url": privateStorageUrl,
+		},
+	}, nil
 }
 
 // 获取文件字节数组的函数
@@ -334,11 +398,16 @@ func fetchImageBytes(url string) ([]byte, error) {
 	return ioutil.ReadAll(resp.Body)
 }
 
-func createRequestBody(c *gin.Context, client cycletls.CycleTLS, cookie string, openAIReq *model.OpenAIChatCompletionRequest) (map[string]interface{}, error) {
-	openAIReq.SystemMessagesProcess(openAIReq.Model)
+func createRequestBody(c *gin.Context, client cycletls.CycleTLS, cookie string, openAIReq *model.OpenAIChatCompletionRequest, conversation *conversation) (body map[string]interface{}, err error) {
+	end := startSpan(c, "createRequestBody", tracing.AttrCookie.String(config.CookieFingerprint(cookie)))
+	defer func() { end(err) }()
+
+	if info, ok := config.Current().Models.Lookup(openAIReq.Model); ok && info.SystemAsUser {
+		openAIReq.SystemMessagesProcess(info.UpstreamName())
+	}
 
 	// 处理消息中的图像 URL
-	err := processMessages(c, client, cookie, openAIReq.Messages)
+	err = processMessages(c, client, cookie, openAIReq.Messages)
 	if err != nil {
 		logger.Errorf(c.Request.Context(), "processMessages err: %v", err)
 		return nil, fmt.Errorf("processMessages err: %v", err)
@@ -346,23 +415,27 @@ func createRequestBody(c *gin.Context, client cycletls.CycleTLS, cookie string,
 
 	currentQueryString := fmt.Sprintf("type=%s", chatType)
 	//查找 key 对应的 value
-	if chatId, ok := config.ModelChatMap[openAIReq.Model]; ok {
+	if chatId, ok := config.GetModelChat(cookie, openAIReq.Model); ok {
 		currentQueryString = fmt.Sprintf("id=%s&type=%s", chatId, chatType)
+	} else if conversation != nil {
+		// 按对话映射时已映射的对话只发送新消息
+		if chatId, ok := conversation.chatIdFor(cookie); ok {
+			currentQueryString = fmt.Sprintf("id=%s&type=%s", chatId, chatType)
+			openAIReq.FilterUserMessage()
+		} else {
+			foldUnmappedMessages(openAIReq)
+		}
 	} else if chatId, ok := config.GlobalSessionManager.GetChatID(cookie, openAIReq.Model); ok {
 		currentQueryString = fmt.Sprintf("id=%s&type=%s", chatId, chatType)
 	} else {
-		openAIReq.FilterUserMessage()
+		foldUnmappedMessages(openAIReq)
 	}
 	requestWebKnowledge := false
-	models := []string{openAIReq.Model}
-	if strings.HasSuffix(openAIReq.Model, "-search") {
-		openAIReq.Model = strings.Replace(openAIReq.Model, "-search", "", 1)
+	if strings.HasSuffix(openAIReq.Model, config.SearchModelSuffix) {
+		openAIReq.Model = strings.TrimSuffix(openAIReq.Model, config.SearchModelSuffix)
 		requestWebKnowledge = true
-		models = []string{openAIReq.Model}
-	}
-	if !lo.Contains(common.TextModelList, openAIReq.Model) {
-		models = common.MixtureModelList
 	}
+	models := config.Current().Models.UpstreamModels(openAIReq.Model)
 
 	// 创建请求体
 	requestBody := map[string]interface{}{
@@ -380,42 +453,63 @@ func createRequestBody(c *gin.Context, client cycletls.CycleTLS, cookie string,
 
 	logger.Debug(c.Request.Context(), fmt.Sprintf("RequestBody: %v", requestBody))
 
-	if strings.TrimSpace(config.CheatUrl) == "" ||
-		(!strings.HasPrefix(config.CheatUrl, "http://") &&
-			!strings.HasPrefix(config.CheatUrl, "https://")) {
+	return cheatRequestBody(c.Request.Context(), requestBody)
+}
+
+// cheatRequestBody 配置 CHEAT_URL 时由其对请求体进行签名
+func cheatRequestBody(ctx context.Context, requestBody map[string]interface{}) (map[string]interface{}, error) {
+	if !config.CheatEnabled() {
 		return requestBody, nil
-	} else {
-		jsonData, err := json.Marshal(requestBody)
-		if err != nil {
-			return nil, fmt.Errorf("marshal request body error: %v", err)
-		}
+	}
 
-		resp, err := http.Post(config.CheatUrl, "application/json", bytes.NewBuffer(jsonData))
-		if err != nil {
-			return nil, fmt.Errorf("send request to test api error: %v", err)
-		}
-		defer resp.Body.Close()
+	ctx, span := tracing.Start(ctx, "cheatRequestBody")
+	response, err := postCheatRequest(requestBody)
+	tracing.End(span, err)
+	if err != nil {
+		alert.CheatFailure(err)
+		return nil, err
+	}
+	logger.Debugf(ctx, fmt.Sprintf("Cheat success!"))
+	return response, nil
+}
 
-		// 读取响应
-		var response map[string]interface{}
-		if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
-			return nil, fmt.Errorf("decode response error: %v", err)
-		}
-		logger.Debugf(c.Request.Context(), fmt.Sprintf("Cheat success!"))
-		return response, nil
+func postCheatRequest(requestBody map[string]interface{}) (map[string]interface{}, error) {
+	jsonData, err := json.Marshal(requestBody)
+	if err != nil {
+		return nil, fmt.Errorf("marshal request body error: %v", err)
+	}
+
+	resp, err := http.Post(config.CheatUrl, "application/json", bytes.NewBuffer(jsonData))
+	if err != nil {
+		return nil, fmt.Errorf("send request to test api error: %v", err)
 	}
+	defer resp.Body.Close()
 
+	// 读取响应
+	var response map[string]interface{}
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return nil, fmt.Errorf("decode response error: %v", err)
+	}
+	return response, nil
+}
+
+// foldUnmappedMessages 未绑定Chat时的消息处理,开启 TRANSCRIPT_MODE 时折叠完整对话历史,否则仅保留最后一条user消息
+func foldUnmappedMessages(openAIReq *model.OpenAIChatCompletionRequest) {
+	if cfg := config.Current(); cfg.TranscriptMode == 1 {
+		openAIReq.Messages = transcript.Build(openAIReq.Messages, cfg.TranscriptMaxTokens, cfg.TranscriptCondense == 1, func(text string) int {
+			return common.CountTokenText(text, openAIReq.Model)
+		})
+		return
+	}
+	openAIReq.FilterUserMessage()
 }
 
 func createImageRequestBody(c *gin.Context, cookie string, openAIReq *model.OpenAIImagesGenerationRequest, chatId string) (map[string]interface{}, error) {
 
-	if openAIReq.Model == "dall-e-3" {
-		openAIReq.Model = "dalle-3"
-	}
 	// 创建模型配置
 	modelConfigs := []map[string]interface{}{
 		{
-			"model":                   openAIReq.Model,
+			"model":                   config.Current().Models.Upstream(openAIReq.Model),
 			"aspect_ratio":            "auto",
 			"use_personalized_models": false,
 			"fashion_profile_id":      nil,
@@ -509,31 +603,7 @@ func createImageRequestBody(c *gin.Context, cookie string, openAIReq *model.Open
 
 	logger.Debug(c.Request.Context(), fmt.Sprintf("RequestBody: %v", requestBody))
 
-	if strings.TrimSpace(config.CheatUrl) == "" ||
-		(!strings.HasPrefix(config.CheatUrl, "http://") &&
-			!strings.HasPrefix(config.CheatUrl, "https://")) {
-		return requestBody, nil
-	} else {
-		jsonData, err := json.Marshal(requestBody)
-		if err != nil {
-			return nil, fmt.Errorf("marshal request body error: %v", err)
-		}
-
-		resp, err := http.Post(config.CheatUrl, "application/json", bytes.NewBuffer(jsonData))
-		if err != nil {
-			return nil, fmt.Errorf("send request to test api error: %v", err)
-		}
-		defer resp.Body.Close()
-
-		// 读取响应
-		var response map[string]interface{}
-		if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
-			return nil, fmt.Errorf("decode response error: %v", err)
-		}
-		logger.Debugf(c.Request.Context(), fmt.Sprintf("Cheat success!"))
-		return response, nil
-	}
-
+	return cheatRequestBody(c.Request.Context(), requestBody)
 }
 
 // createStreamResponse 创建流式响应
@@ -573,7 +643,8 @@ func handleMessageFieldDelta(c *gin.Context, event map[string]interface{}, respo
 		fieldName == "session_state.streaming_markmap"
 
 	// 需要显示思考过程时需要额外处理的字段
-	if config.ReasoningHide != 1 {
+	showReasoning := config.Current().ReasoningHide != 1
+	if showReasoning {
 		baseAllowed = baseAllowed ||
 			fieldName == "session_state.answerthink_is_started" ||
 			fieldName == "session_state.answerthink" ||
@@ -587,7 +658,7 @@ func handleMessageFieldDelta(c *gin.Context, event map[string]interface{}, respo
 	// 获取 delta 内容
 	var delta string
 	switch {
-	case (modelName == "o1" || modelName == "o3-mini-high") && fieldName == "session_state.answer":
+	case fieldName == "session_state.answer" && modelAnswerFieldValue(modelName):
 		delta, _ = event["field_value"].(string)
 	default:
 		delta, _ = event["delta"].(string)
@@ -611,7 +682,7 @@ func handleMessageFieldDelta(c *gin.Context, event map[string]interface{}, respo
 	}
 
 	// 处理思考过程标记
-	if config.ReasoningHide != 1 {
+	if showReasoning {
 		switch fieldName {
 		case "session_state.answerthink_is_started":
 			err = sendSSEvent(c, createResponse("<think>\n"))
@@ -623,6 +694,18 @@ func handleMessageFieldDelta(c *gin.Context, event map[string]interface{}, respo
 	return err
 }
 
+// modelAnswerFieldValue 模型的流式回答是否在 field_value 中返回
+func modelAnswerFieldValue(modelName string) bool {
+	info, ok := config.Current().Models.Lookup(modelName)
+	return ok && info.AnswerFieldValue
+}
+
+// modelSearchDetailAnswer 模型联网时回答是否在 detailAnswer 中返回
+func modelSearchDetailAnswer(modelName string) bool {
+	info, ok := config.Current().Models.Lookup(modelName)
+	return ok && info.SearchDetailAnswer
+}
+
 type Content struct {
 	DetailAnswer string `json:"detailAnswer"`
 }
@@ -649,7 +732,7 @@ func handleMessageResult(c *gin.Context, event map[string]interface{}, responseI
 	finishReason := "stop"
 	var delta string
 	var err error
-	if modelName == "o1" && searchModel {
+	if searchModel && modelSearchDetailAnswer(modelName) {
 		delta, err = getDetailAnswer(event)
 		if err != nil {
 			logger.Errorf(c.Request.Context(), "getDetailAnswer err: %v", err)
@@ -675,19 +758,31 @@ func sendSSEvent(c *gin.Context, response model.OpenAIChatCompletionResponse) er
 	}
 	c.SSEvent("", " "+string(jsonResp))
 	c.Writer.Flush()
+	if len(response.Choices) > 0 {
+		if response.Choices[0].Delta.Content != "" && getCompletionContent(c) == "" {
+			c.Set(helper.FirstTokenKey, time.Now())
+			if !getUsage(c).Time.IsZero() {
+				metrics.ObserveTTFT(response.Model, time.Since(getUsage(c).Time))
+			}
+		}
+		appendCompletionContent(c, response.Choices[0].Delta.Content)
+	}
 	return nil
 }
 
 // makeRequest 发送HTTP请求
 func makeRequest(client cycletls.CycleTLS, jsonData []byte, cookie string, isStream bool) (cycletls.Response, error) {
+	cfg := config.Current()
 	accept := "application/json"
+	timeout := cfg.RequestOutTime
 	if isStream {
 		accept = "text/event-stream"
+		timeout = cfg.StreamRequestOutTime
 	}
 
 	return client.Do(apiEndpoint, cycletls.Options{
-		Timeout: 10 * 60 * 60,
-		Proxy:   config.ProxyUrl, // 在每个请求中设置代理
+		Timeout: timeout,
+		Proxy:   config.GetProxy(cookie), // 每个cookie固定使用绑定的代理
 		Body:    string(jsonData),
 		Method:  "POST",
 		Headers: map[string]string{
@@ -707,8 +802,8 @@ func makeImageRequest(client cycletls.CycleTLS, jsonData []byte, cookie string)
 
 	return client.Do(apiEndpoint, cycletls.Options{
 		UserAgent: "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/537.36 (KHTML, like Gecko) Chrome",
-		Timeout:   10 * 60 * 60,
-		Proxy:     config.ProxyUrl, // 在每个请求中设置代理
+		Timeout:   config.Current().RequestOutTime,
+		Proxy:     config.GetProxy(cookie), // 每个cookie固定使用绑定的代理
 		Body:      string(jsonData),
 		Method:    "POST",
 		Headers: map[string]string{
@@ -725,28 +820,15 @@ func makeImageRequest(client cycletls.CycleTLS, jsonData []byte, cookie string)
 func makeDeleteRequest(client cycletls.CycleTLS, cookie, projectId string) (cycletls.Response, error) {
 
 	// 不删除环境变量中的map中的对话
-
-	for _, v := range config.ModelChatMap {
-		if v == projectId {
-			return cycletls.Response{}, nil
-		}
-	}
-	for _, v := range config.GlobalSessionManager.GetChatIDsByCookie(cookie) {
-		if v == projectId {
-			return cycletls.Response{}, nil
-		}
-	}
-	for _, v := range config.SessionImageChatMap {
-		if v == projectId {
-			return cycletls.Response{}, nil
-		}
+	if config.IsProtectedProject(cookie, projectId) {
+		return cycletls.Response{}, nil
 	}
 
 	accept := "application/json"
 
 	return client.Do(fmt.Sprintf(deleteEndpoint, projectId), cycletls.Options{
 		Timeout: 10 * 60 * 60,
-		Proxy:   config.ProxyUrl, // 在每个请求中设置代理
+		Proxy:   config.GetProxy(cookie), // 每个cookie固定使用绑定的代理
 		Method:  "GET",
 		Headers: map[string]string{
 			"Content-Type": "application/json",
@@ -764,7 +846,7 @@ func makeGetUploadUrlRequest(client cycletls.CycleTLS, cookie string) (cycletls.
 
 	return client.Do(fmt.Sprintf(uploadEndpoint), cycletls.Options{
 		Timeout: 10 * 60 * 60,
-		Proxy:   config.ProxyUrl, // 在每个请求中设置代理
+		Proxy:   config.GetProxy(cookie), // 每个cookie固定使用绑定的代理
 		Method:  "GET",
 		Headers: map[string]string{
 			"Content-Type": "application/json",
@@ -792,10 +874,10 @@ func makeGetUploadUrlRequest(client cycletls.CycleTLS, cookie string) (cycletls.
 //	}, "OPTIONS")
 //}
 
-func makeUploadRequest(client cycletls.CycleTLS, uploadUrl string, fileBytes []byte) (cycletls.Response, error) {
+func makeUploadRequest(client cycletls.CycleTLS, cookie string, uploadUrl string, fileBytes []byte) (cycletls.Response, error) {
 	return client.Do(uploadUrl, cycletls.Options{
 		Timeout: 10 * 60 * 60,
-		Proxy:   config.ProxyUrl, // 在每个请求中设置代理
+		Proxy:   config.GetProxy(cookie), // 每个cookie固定使用绑定的代理
 		Method:  "PUT",
 		Body:    string(fileBytes),
 		Headers: map[string]string{
@@ -819,7 +901,7 @@ func makeUploadRequest(client cycletls.CycleTLS, uploadUrl string, fileBytes []b
 //
 //	responseId := fmt.Sprintf(responseIDFormat, time.Now().Format("20060102150405"))
 //
-//	c.Stream(func(w io.Writer) bool {
+//	stream(c, func(w io.Writer) bool {
 //		sseChan, err := makeStreamRequest(c, client, jsonData, cookie)
 //		if err != nil {
 //			logger.Errorf(c.Request.Context(), "makeStreamRequest err: %v", err)
@@ -830,7 +912,7 @@ func makeUploadRequest(client cycletls.CycleTLS, uploadUrl string, fileBytes []b
 //	})
 //}
 
-func handleStreamRequest(c *gin.Context, client cycletls.CycleTLS, cookie string, cookieManager *config.CookieManager, requestBody map[string]interface{}, modelName string, searchModel bool) {
+func handleStreamRequest(c *gin.Context, client cycletls.CycleTLS, cookie string, cookieManager *config.CookieManager, requestBody map[string]interface{}, modelName string, searchModel bool, conversation *conversation, fallback *modelFallback) (fallbackReason string) {
 	const (
 		errNoValidCookies         = "No valid cookies available"
 		errCloudflareChallengeMsg = "Detected Cloudflare Challenge Page"
@@ -845,10 +927,16 @@ func handleStreamRequest(c *gin.Context, client cycletls.CycleTLS, cookie string
 
 	responseId := fmt.Sprintf(responseIDFormat, time.Now().Format("20060102150405"))
 	ctx := c.Request.Context()
-	maxRetries := len(cookieManager.Cookies)
+	maxRetries := len(cookieManager.Cookies) + config.GlobalProxyPool.Size()
+
+	var upstream *upstreamAttempt
+	defer func() { upstream.end() }()
 
-	c.Stream(func(w io.Writer) bool {
+	stream(c, func(w io.Writer) bool {
 		for attempt := 0; attempt < maxRetries; attempt++ {
+			upstream.end()
+			upstream = startAttempt(ctx, tracing.OperationChat, modelName, cookie, attempt)
+			logger.SetCookie(ctx, cookie)
 			jsonData, err := json.Marshal(requestBody)
 			if err != nil {
 				c.JSON(500, gin.H{"error": "Failed to marshal request body"})
@@ -856,6 +944,7 @@ func handleStreamRequest(c *gin.Context, client cycletls.CycleTLS, cookie string
 			}
 			sseChan, err := makeStreamRequest(c, client, jsonData, cookie)
 			if err != nil {
+				upstream.fail(err)
 				logger.Errorf(ctx, "makeStreamRequest err on attempt %d: %v", attempt+1, err)
 				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 				return false
@@ -863,6 +952,7 @@ func handleStreamRequest(c *gin.Context, client cycletls.CycleTLS, cookie string
 
 			var projectId string
 			isRateLimit := false
+			isProxyBlocked := false
 		SSELoop:
 			for response := range sseChan {
 				if response.Done {
@@ -874,41 +964,63 @@ func handleStreamRequest(c *gin.Context, client cycletls.CycleTLS, cookie string
 				if data == "" {
 					continue
 				}
+				upstream.receivedFirstByte()
 
 				logger.Debug(ctx, strings.TrimSpace(data))
 
 				switch {
 				case common.IsCloudflareChallenge(data):
+					upstream.upstreamError(metrics.UpstreamCloudflareChallenge)
 					logger.Errorf(ctx, errCloudflareChallengeMsg)
 					c.JSON(http.StatusInternalServerError, gin.H{"error": errCloudflareChallengeMsg})
 					return false
 				case common.IsCloudflareBlock(data):
+					upstream.upstreamError(metrics.UpstreamCloudflareBlock)
 					logger.Errorf(ctx, errCloudflareBlock)
+					if config.RotateProxy(cookie) {
+						isProxyBlocked = true
+						logger.Warnf(ctx, "Proxy blocked, switching to next proxy, attempt %d/%d", attempt+1, maxRetries)
+						break SSELoop
+					}
 					c.JSON(http.StatusInternalServerError, gin.H{"error": errCloudflareBlock})
 					return false
 				case common.IsServiceUnavailablePage(data):
+					upstream.upstreamError(metrics.UpstreamServiceUnavailable)
 					logger.Errorf(ctx, errServiceUnavailable)
+					if fallback.allows(c, config.FallbackOverload) {
+						fallbackReason = config.FallbackOverload
+						return false
+					}
 					c.JSON(http.StatusInternalServerError, gin.H{"error": errServiceUnavailable})
 					return false
 				case common.IsServerError(data):
+					upstream.upstreamError(metrics.UpstreamServerError)
 					logger.Errorf(ctx, errServerErrMsg)
+					if fallback.allows(c, config.FallbackOverload) {
+						fallbackReason = config.FallbackOverload
+						return false
+					}
 					c.JSON(http.StatusInternalServerError, gin.H{"error": errServerErrMsg})
 					return false
 				case common.IsRateLimit(data):
+					upstream.upstreamError(metrics.UpstreamRateLimit)
 					isRateLimit = true
-					logger.Warnf(ctx, "Cookie rate limited, switching to next cookie, attempt %d/%d, COOKIE:%s", attempt+1, maxRetries, cookie)
-					config.AddRateLimitCookie(cookie, time.Now().Add(time.Duration(config.RateLimitCookieLockDuration)*time.Second))
+					logger.Warnf(ctx, "Cookie rate limited, switching to next cookie, attempt %d/%d", attempt+1, maxRetries)
+					config.AddRateLimitCookie(cookie, time.Now().Add(time.Duration(config.Current().RateLimitCookieLockDuration)*time.Second))
 					break SSELoop // 使用 label 跳出 SSE 循环
 				case common.IsFreeLimit(data):
+					upstream.upstreamError(metrics.UpstreamFreeLimit)
 					isRateLimit = true
-					logger.Warnf(ctx, "Cookie free rate limited, switching to next cookie, attempt %d/%d, COOKIE:%s", attempt+1, maxRetries, cookie)
-					config.AddRateLimitCookie(cookie, time.Now().Add(24*60*60*time.Second))
+					logger.Warnf(ctx, "Cookie free rate limited, switching to next cookie, attempt %d/%d", attempt+1, maxRetries)
+					markFreeLimit(cookie, modelName)
 					// 删除cookie
 					//config.RemoveCookie(cookie)
 					break SSELoop // 使用 label 跳出 SSE 循环
 				case common.IsNotLogin(data):
+					upstream.upstreamError(metrics.UpstreamNotLogin)
 					isRateLimit = true
-					logger.Warnf(ctx, "Cookie Not Login, switching to next cookie, attempt %d/%d, COOKIE:%s", attempt+1, maxRetries, cookie)
+					logger.Warnf(ctx, "Cookie Not Login, switching to next cookie, attempt %d/%d", attempt+1, maxRetries)
+					markNotLogin(cookie)
 					//err := cookieManager.RemoveCookie(cookie)
 					//if err != nil {
 					//	logger.Errorf(ctx, "Failed to remove cookie: %v", err)
@@ -917,39 +1029,104 @@ func handleStreamRequest(c *gin.Context, client cycletls.CycleTLS, cookie string
 				}
 
 				// 处理事件流数据
-				if shouldContinue := processStreamData(c, data, &projectId, cookie, responseId, modelName, jsonData, searchModel); !shouldContinue {
+				if shouldContinue := processStreamData(c, data, &projectId, cookie, responseId, modelName, jsonData, searchModel, conversation); !shouldContinue {
 					return false
 				}
 			}
 
+			// 代理已切换,使用同一cookie重试
+			if isProxyBlocked {
+				continue
+			}
+
 			if !isRateLimit {
 				return true
 			}
 
 			// 获取下一个可用的cookie继续尝试
+			previous := cookie
 			cookie, err = cookieManager.GetNextCookie()
 			if err != nil {
-				logger.Errorf(ctx, "No more valid cookies available after attempt %d", attempt+1)
-				c.JSON(http.StatusInternalServerError, gin.H{"error": errNoValidCookies})
+				logger.Errorf(ctx, "No more valid cookies available after attempt %d: %v", attempt+1, err)
+				if fallback.allows(c, config.FallbackPoolExhausted) {
+					fallbackReason = config.FallbackPoolExhausted
+					return false
+				}
+				c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("%s: %v", errNoValidCookies, err)})
 				return false
 			}
+			recordFailover(ctx, previous, cookie)
 
 			// requestBody重制chatId
-			currentQueryString := fmt.Sprintf("type=%s", chatType)
-			if chatId, ok := config.GlobalSessionManager.GetChatID(cookie, modelName); ok {
-				currentQueryString = fmt.Sprintf("id=%s&type=%s", chatId, chatType)
-			}
-			requestBody["current_query_string"] = currentQueryString
+			requestBody["current_query_string"] = retryQueryString(cookie, modelName, conversation)
 		}
 
 		logger.Errorf(ctx, "All cookies exhausted after %d attempts", maxRetries)
+		if fallback.allows(c, config.FallbackPoolExhausted) {
+			fallbackReason = config.FallbackPoolExhausted
+			return false
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "All cookies are temporarily unavailable."})
 		return false
 	})
+	return fallbackReason
+}
+
+// stream 同 gin.Context.Stream,未写入内容时不发送响应头,以便降级到其它模型后重新设置响应头
+func stream(c *gin.Context, step func(w io.Writer) bool) {
+	w := c.Writer
+	for {
+		select {
+		case <-c.Request.Context().Done():
+			return
+		default:
+			keepOpen := step(w)
+			if w.Written() {
+				w.Flush()
+			}
+			if !keepOpen {
+				return
+			}
+		}
+	}
+}
+
+// saveChatSession 对话完成后保存映射或删除临时会话
+func saveChatSession(cookie, modelName, projectId string, conversation *conversation, answer string) {
+	go func() {
+		if conversation != nil {
+			// 按对话保存映射
+			conversation.save(cookie, projectId, answer)
+		} else if config.AutoModelChatMapType == 1 {
+			// 保存映射
+			config.GlobalSessionManager.AddSession(cookie, modelName, projectId)
+		} else {
+			if config.Current().AutoDelChat == 1 {
+				if _, err := DeleteProject(cookie, projectId); err != nil {
+					logger.SysError(fmt.Sprintf("delete chat %s err: %v", projectId, err))
+				}
+			}
+		}
+	}()
+}
+
+// retryQueryString 切换cookie重试时重新获取该cookie下映射的chatId
+func retryQueryString(cookie, modelName string, conversation *conversation) string {
+	if chatId, ok := config.GetModelChat(cookie, modelName); ok {
+		return fmt.Sprintf("id=%s&type=%s", chatId, chatType)
+	}
+	if conversation != nil {
+		if chatId, ok := conversation.chatIdFor(cookie); ok {
+			return fmt.Sprintf("id=%s&type=%s", chatId, chatType)
+		}
+	} else if chatId, ok := config.GlobalSessionManager.GetChatID(cookie, modelName); ok {
+		return fmt.Sprintf("id=%s&type=%s", chatId, chatType)
+	}
+	return fmt.Sprintf("type=%s", chatType)
 }
 
 // 处理流式数据的辅助函数，返回bool表示是否继续处理
-func processStreamData(c *gin.Context, data string, projectId *string, cookie, responseId, model string, jsonData []byte, searchModel bool) bool {
+func processStreamData(c *gin.Context, data string, projectId *string, cookie, responseId, model string, jsonData []byte, searchModel bool, conversation *conversation) bool {
 	data = strings.TrimSpace(data)
 	if !strings.HasPrefix(data, "data: ") {
 		return true
@@ -971,6 +1148,7 @@ func processStreamData(c *gin.Context, data string, projectId *string, cookie, r
 	switch eventType {
 	case "project_start":
 		*projectId, _ = event["id"].(string)
+		config.TrackProject(cookie, model, *projectId)
 	case "message_field":
 		if err := handleMessageFieldDelta(c, event, responseId, model, jsonData); err != nil {
 			logger.Errorf(c.Request.Context(), "handleMessageFieldDelta err: %v", err)
@@ -984,20 +1162,10 @@ func processStreamData(c *gin.Context, data string, projectId *string, cookie, r
 			return false
 		}
 	case "message_result":
-		go func() {
-			if config.AutoModelChatMapType == 1 {
-				// 保存映射
-				config.GlobalSessionManager.AddSession(cookie, model, *projectId)
-			} else {
-				if config.AutoDelChat == 1 {
-					client := cycletls.Init()
-					defer safeClose(client)
-					makeDeleteRequest(client, cookie, *projectId)
-				}
-			}
-		}()
-
-		return handleMessageResult(c, event, responseId, model, jsonData, searchModel)
+		shouldContinue := handleMessageResult(c, event, responseId, model, jsonData, searchModel)
+		saveChatSession(cookie, model, *projectId, conversation, getCompletionContent(c))
+		recordUsage(c, common.CountTokenText(string(jsonData), model), common.CountTokenText(getCompletionContent(c), model))
+		return shouldContinue
 	}
 
 	return true
@@ -1005,8 +1173,8 @@ func processStreamData(c *gin.Context, data string, projectId *string, cookie, r
 
 func makeStreamRequest(c *gin.Context, client cycletls.CycleTLS, jsonData []byte, cookie string) (<-chan cycletls.SSEResponse, error) {
 	options := cycletls.Options{
-		Timeout: 10 * 60 * 60,
-		Proxy:   config.ProxyUrl, // 在每个请求中设置代理
+		Timeout: config.Current().StreamRequestOutTime,
+		Proxy:   config.GetProxy(cookie), // 每个cookie固定使用绑定的代理
 		Body:    string(jsonData),
 		Method:  "POST",
 		Headers: map[string]string{
@@ -1018,8 +1186,6 @@ func makeStreamRequest(c *gin.Context, client cycletls.CycleTLS, jsonData []byte
 		},
 	}
 
-	logger.Debug(c.Request.Context(), fmt.Sprintf("cookie: %v", cookie))
-
 	sseChan, err := client.DoSSE(apiEndpoint, options, "POST")
 	if err != nil {
 		logger.Errorf(c, "Failed to make stream request: %v", err)
@@ -1112,7 +1278,7 @@ func makeStreamRequest(c *gin.Context, client cycletls.CycleTLS, jsonData []byte
 //
 //		c.JSON(200, resp)
 //	}
-func handleNonStreamRequest(c *gin.Context, client cycletls.CycleTLS, cookie string, cookieManager *config.CookieManager, requestBody map[string]interface{}, modelName string, searchModel bool) {
+func handleNonStreamRequest(c *gin.Context, client cycletls.CycleTLS, cookie string, cookieManager *config.CookieManager, requestBody map[string]interface{}, modelName string, searchModel bool, conversation *conversation, fallback *modelFallback) string {
 	const (
 		errCloudflareChallengeMsg = "Detected Cloudflare Challenge Page"
 		errCloudflareBlock        = "CloudFlare: Sorry, you have been blocked"
@@ -1122,28 +1288,39 @@ func handleNonStreamRequest(c *gin.Context, client cycletls.CycleTLS, cookie str
 	)
 
 	ctx := c.Request.Context()
-	maxRetries := len(cookieManager.Cookies)
+	maxRetries := len(cookieManager.Cookies) + config.GlobalProxyPool.Size()
+
+	var upstream *upstreamAttempt
+	defer func() { upstream.end() }()
 
 	for attempt := 0; attempt < maxRetries; attempt++ {
+		upstream.end()
+		upstream = startAttempt(ctx, tracing.OperationChat, modelName, cookie, attempt)
+		logger.SetCookie(ctx, cookie)
 		jsonData, err := json.Marshal(requestBody)
 		if err != nil {
 			c.JSON(500, gin.H{"error": "Failed to marshal request body"})
-			return
+			return ""
 		}
 		response, err := makeRequest(client, jsonData, cookie, false)
 		if err != nil {
+			upstream.fail(err)
 			logger.Errorf(ctx, "makeRequest err: %v", err)
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-			return
+			return ""
 		}
 
+		upstream.receivedFirstByte()
+
 		scanner := bufio.NewScanner(strings.NewReader(response.Body))
 		var content string
 		var answerThink string
 		var firstLine string
 		var projectId string
 		isRateLimit := false
+		isProxyBlocked := false
 
+	ScanLoop:
 		for scanner.Scan() {
 			line := scanner.Text()
 			if firstLine == "" {
@@ -1156,41 +1333,60 @@ func handleNonStreamRequest(c *gin.Context, client cycletls.CycleTLS, cookie str
 
 			switch {
 			case common.IsCloudflareChallenge(line):
+				upstream.upstreamError(metrics.UpstreamCloudflareChallenge)
 				logger.Errorf(ctx, errCloudflareChallengeMsg)
 				c.JSON(http.StatusInternalServerError, gin.H{"error": errCloudflareChallengeMsg})
-				return
+				return ""
 			case common.IsCloudflareBlock(line):
+				upstream.upstreamError(metrics.UpstreamCloudflareBlock)
 				logger.Errorf(ctx, errCloudflareBlock)
+				if config.RotateProxy(cookie) {
+					isProxyBlocked = true
+					logger.Warnf(ctx, "Proxy blocked, switching to next proxy, attempt %d/%d", attempt+1, maxRetries)
+					break ScanLoop
+				}
 				c.JSON(http.StatusInternalServerError, gin.H{"error": errCloudflareBlock})
-				return
+				return ""
 			case common.IsRateLimit(line):
+				upstream.upstreamError(metrics.UpstreamRateLimit)
 				isRateLimit = true
-				logger.Warnf(ctx, "Cookie rate limited, switching to next cookie, attempt %d/%d, COOKIE:%s", attempt+1, maxRetries, cookie)
-				config.AddRateLimitCookie(cookie, time.Now().Add(time.Duration(config.RateLimitCookieLockDuration)*time.Second))
+				logger.Warnf(ctx, "Cookie rate limited, switching to next cookie, attempt %d/%d", attempt+1, maxRetries)
+				config.AddRateLimitCookie(cookie, time.Now().Add(time.Duration(config.Current().RateLimitCookieLockDuration)*time.Second))
 				break
 			case common.IsFreeLimit(line):
+				upstream.upstreamError(metrics.UpstreamFreeLimit)
 				isRateLimit = true
-				logger.Warnf(ctx, "Cookie free rate limited, switching to next cookie, attempt %d/%d, COOKIE:%s", attempt+1, maxRetries, cookie)
-				config.AddRateLimitCookie(cookie, time.Now().Add(24*60*60*time.Second))
+				logger.Warnf(ctx, "Cookie free rate limited, switching to next cookie, attempt %d/%d", attempt+1, maxRetries)
+				markFreeLimit(cookie, modelName)
 				// 删除cookie
 				//config.RemoveCookie(cookie)
 				break
 			case common.IsNotLogin(line):
+				upstream.upstreamError(metrics.UpstreamNotLogin)
 				isRateLimit = true
-				logger.Warnf(ctx, "Cookie Not Login, switching to next cookie, attempt %d/%d, COOKIE:%s", attempt+1, maxRetries, cookie)
+				logger.Warnf(ctx, "Cookie Not Login, switching to next cookie, attempt %d/%d", attempt+1, maxRetries)
+				markNotLogin(cookie)
 				//err := cookieManager.RemoveCookie(cookie)
 				//if err != nil {
 				//	logger.Errorf(ctx, "Failed to remove cookie: %v", err)
 				//}
 				break
 			case common.IsServiceUnavailablePage(line):
+				upstream.upstreamError(metrics.UpstreamServiceUnavailable)
 				logger.Errorf(ctx, errServiceUnavailable)
+				if fallback.allows(c, config.FallbackOverload) {
+					return config.FallbackOverload
+				}
 				c.JSON(http.StatusInternalServerError, gin.H{"error": errServiceUnavailable})
-				return
+				return ""
 			case common.IsServerError(line):
+				upstream.upstreamError(metrics.UpstreamServerError)
 				logger.Errorf(ctx, errServerErrMsg)
+				if fallback.allows(c, config.FallbackOverload) {
+					return config.FallbackOverload
+				}
 				c.JSON(http.StatusInternalServerError, gin.H{"error": errServerErrMsg})
-				return
+				return ""
 			case strings.HasPrefix(line, "data: "):
 
 				data := strings.TrimPrefix(line, "data: ")
@@ -1203,14 +1399,15 @@ func handleNonStreamRequest(c *gin.Context, client cycletls.CycleTLS, cookie str
 				}
 				if err := json.Unmarshal([]byte(data), &parsedResponse); err != nil {
 					c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-					return
+					return ""
 				}
 				if parsedResponse.Type == "project_start" {
 					projectId = parsedResponse.Id
+					config.TrackProject(cookie, modelName, projectId)
 				}
 				if parsedResponse.Type == "message_field" {
 					// 提取思考过程
-					if config.ReasoningHide != 1 {
+					if config.Current().ReasoningHide != 1 {
 						if parsedResponse.FieldName == "session_state.answerthink_is_started" {
 							answerThink = "<think>\n"
 						}
@@ -1221,42 +1418,36 @@ func handleNonStreamRequest(c *gin.Context, client cycletls.CycleTLS, cookie str
 				}
 				if parsedResponse.Type == "message_field_delta" {
 					// 提取思考过程
-					if config.ReasoningHide != 1 {
+					if config.Current().ReasoningHide != 1 {
 						if parsedResponse.FieldName == "session_state.answerthink" {
 							answerThink = answerThink + parsedResponse.Delta
 						}
 					}
 				}
 				if parsedResponse.Type == "message_result" {
-					// 删除临时会话
-					go func() {
-						if config.AutoModelChatMapType == 1 {
-							// 保存映射
-							config.GlobalSessionManager.AddSession(cookie, modelName, projectId)
-						} else {
-							if config.AutoDelChat == 1 {
-								client := cycletls.Init()
-								defer safeClose(client)
-								makeDeleteRequest(client, cookie, projectId)
-							}
-						}
-					}()
-					if modelName == "o1" && searchModel {
+					if searchModel && modelSearchDetailAnswer(modelName) {
 						// 解析内层的 JSON
 						var content Content
 						if err := json.Unmarshal([]byte(parsedResponse.Content), &content); err != nil {
 							logger.Errorf(ctx, "Failed to unmarshal response content: %v err %s", parsedResponse.Content, err.Error())
 							c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to unmarshal response content"})
-							return
+							return ""
 						}
 						parsedResponse.Content = content.DetailAnswer
 					}
 					content = strings.TrimSpace(answerThink + parsedResponse.Content)
+					// 保存映射或删除临时会话
+					saveChatSession(cookie, modelName, projectId, conversation, content)
 					break
 				}
 			}
 		}
 
+		// 代理已切换,使用同一cookie重试
+		if isProxyBlocked {
+			continue
+		}
+
 		if !isRateLimit {
 			if content == "" {
 				logger.Warnf(ctx, firstLine)
@@ -1264,6 +1455,8 @@ func handleNonStreamRequest(c *gin.Context, client cycletls.CycleTLS, cookie str
 			} else {
 				promptTokens := common.CountTokenText(string(jsonData), modelName)
 				completionTokens := common.CountTokenText(content, modelName)
+				recordUsage(c, promptTokens, completionTokens)
+				appendCompletionContent(c, content)
 				finishReason := "stop"
 
 				c.JSON(http.StatusOK, model.OpenAIChatCompletionResponse{
@@ -1284,45 +1477,30 @@ func handleNonStreamRequest(c *gin.Context, client cycletls.CycleTLS, cookie str
 						TotalTokens:      promptTokens + completionTokens,
 					},
 				})
-				return
+				return ""
 			}
 		}
 
+		previous := cookie
 		cookie, err = cookieManager.GetNextCookie()
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "No more valid cookies available"})
-			return
+			if fallback.allows(c, config.FallbackPoolExhausted) {
+				return config.FallbackPoolExhausted
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("No more valid cookies available: %v", err)})
+			return ""
 		}
+		recordFailover(ctx, previous, cookie)
 		// requestBody重制chatId
-		currentQueryString := fmt.Sprintf("type=%s", chatType)
-		if chatId, ok := config.GlobalSessionManager.GetChatID(cookie, modelName); ok {
-			currentQueryString = fmt.Sprintf("id=%s&type=%s", chatId, chatType)
-		}
-		requestBody["current_query_string"] = currentQueryString
+		requestBody["current_query_string"] = retryQueryString(cookie, modelName, conversation)
 	}
 
 	logger.Errorf(ctx, "All cookies exhausted after %d attempts", maxRetries)
-	c.JSON(http.StatusInternalServerError, gin.H{"error": "All cookies are temporarily unavailable."})
-}
-
-func OpenaiModels(c *gin.Context) {
-	var modelsResp []string
-
-	modelsResp = common.DefaultOpenaiModelList
-
-	var openaiModelListResponse model.OpenaiModelListResponse
-	var openaiModelResponse []model.OpenaiModelResponse
-	openaiModelListResponse.Object = "list"
-
-	for _, modelResp := range modelsResp {
-		openaiModelResponse = append(openaiModelResponse, model.OpenaiModelResponse{
-			ID:     modelResp,
-			Object: "model",
-		})
+	if fallback.allows(c, config.FallbackPoolExhausted) {
+		return config.FallbackPoolExhausted
 	}
-	openaiModelListResponse.Data = openaiModelResponse
-	c.JSON(http.StatusOK, openaiModelListResponse)
-	return
+	c.JSON(http.StatusInternalServerError, gin.H{"error": "All cookies are temporarily unavailable."})
+	return ""
 }
 
 func ImagesForOpenAI(c *gin.Context) {
@@ -1335,6 +1513,12 @@ func ImagesForOpenAI(c *gin.Context) {
 		c.JSON(400, gin.H{"error": err.Error()})
 		return
 	}
+	openAIReq.Model = config.Current().Models.Resolve(openAIReq.Model)
+	getUsage(c).Model = openAIReq.Model
+	logger.SetField(c.Request.Context(), logger.FieldModel, openAIReq.Model)
+	if !checkTenantModel(c, openAIReq.Model) {
+		return
+	}
 	// 初始化cookie
 	//cookieManager := config.NewCookieManager()
 	//cookie, err := cookieManager.GetRandomCookie()
@@ -1357,6 +1541,12 @@ func ImagesForOpenAI(c *gin.Context) {
 		})
 		return
 	} else {
+		getUsage(c).Images = len(resp.Data)
+		images := make([]string, 0, len(resp.Data))
+		for _, data := range resp.Data {
+			images = append(images, data.URL)
+		}
+		c.Set(helper.ImagesKey, images)
 		c.JSON(200, resp)
 	}
 
@@ -1377,27 +1567,34 @@ func ImageProcess(c *gin.Context, client cycletls.CycleTLS, openAIReq model.Open
 		chatId     string
 	)
 
-	cookieManager := config.NewCookieManager()
+	cookieManager := config.NewCookieManager(openAIReq.Model)
 	//sessionImageChatManager = config.NewSessionMapManager()
 	ctx := c.Request.Context()
 
 	// Initialize session manager and get initial cookie
 	//if len(config.SessionImageChatMap) == 0 {
 	//logger.Warnf(ctx, "未配置环境变量 SESSION_IMAGE_CHAT_MAP, 可能会生图失败!")
-	maxRetries = len(cookieManager.Cookies)
+	maxRetries = len(cookieManager.Cookies) + config.GlobalProxyPool.Size()
 
 	var err error
 	cookie, err = cookieManager.GetRandomCookie()
 	if err != nil {
 		logger.Errorf(ctx, "Failed to get initial cookie: %v", err)
-		return nil, fmt.Errorf(errNoValidCookies)
+		return nil, fmt.Errorf("%s: %v", errNoValidCookies, err)
 	}
 	//} else {
 	//	maxRetries = sessionImageChatManager.GetSize()
 	//	cookie, chatId, _ = sessionImageChatManager.GetRandomKeyValue()
 	//}
 
+	var upstream *upstreamAttempt
+	defer func() { upstream.end() }()
+
 	for attempt := 0; attempt < maxRetries; attempt++ {
+		upstream.end()
+		upstream = startAttempt(ctx, tracing.OperationImage, openAIReq.Model, cookie, attempt)
+		logger.SetCookie(ctx, cookie)
+
 		// Create request body
 		requestBody, err := createImageRequestBody(c, cookie, &openAIReq, chatId)
 		if err != nil {
@@ -1415,16 +1612,27 @@ func ImageProcess(c *gin.Context, client cycletls.CycleTLS, openAIReq model.Open
 		// Make request
 		response, err := makeImageRequest(client, jsonData, cookie)
 		if err != nil {
+			upstream.fail(err)
 			logger.Errorf(ctx, "Failed to make image request: %v", err)
 			return nil, err
 		}
 
+		upstream.receivedFirstByte()
 		body := response.Body
 
 		// Handle different response cases
 		switch {
+		case common.IsCloudflareBlock(body):
+			upstream.upstreamError(metrics.UpstreamCloudflareBlock)
+			if config.RotateProxy(cookie) {
+				logger.Warnf(ctx, "Proxy blocked, switching to next proxy, attempt %d/%d", attempt+1, maxRetries)
+				continue
+			}
+			logger.Errorf(ctx, "CloudFlare: Sorry, you have been blocked")
+			return nil, fmt.Errorf("CloudFlare: Sorry, you have been blocked")
 		case common.IsRateLimit(body):
-			logger.Warnf(ctx, "Cookie rate limited, switching to next cookie, attempt %d/%d, COOKIE:%s", attempt+1, maxRetries, cookie)
+			upstream.upstreamError(metrics.UpstreamRateLimit)
+			logger.Warnf(ctx, "Cookie rate limited, switching to next cookie, attempt %d/%d", attempt+1, maxRetries)
 			//if sessionImageChatManager != nil {
 			//	cookie, chatId, err = sessionImageChatManager.GetNextKeyValue()
 			//	if err != nil {
@@ -1434,17 +1642,20 @@ func ImageProcess(c *gin.Context, client cycletls.CycleTLS, openAIReq model.Open
 			//	}
 			//} else {
 			//cookieManager := config.NewCookieManager()
-			config.AddRateLimitCookie(cookie, time.Now().Add(time.Duration(config.RateLimitCookieLockDuration)*time.Second))
+			config.AddRateLimitCookie(cookie, time.Now().Add(time.Duration(config.Current().RateLimitCookieLockDuration)*time.Second))
+			previous := cookie
 			cookie, err = cookieManager.GetNextCookie()
 			if err != nil {
-				logger.Errorf(ctx, "No more valid cookies available after attempt %d", attempt+1)
+				logger.Errorf(ctx, "No more valid cookies available after attempt %d: %v", attempt+1, err)
 				c.JSON(http.StatusInternalServerError, gin.H{"error": errNoValidCookies})
-				return nil, fmt.Errorf(errNoValidCookies)
+				return nil, fmt.Errorf("%s: %v", errNoValidCookies, err)
 				//}
 			}
+			recordFailover(ctx, previous, cookie)
 			continue
 		case common.IsFreeLimit(body):
-			logger.Warnf(ctx, "Cookie free rate limited, switching to next cookie, attempt %d/%d, COOKIE:%s", attempt+1, maxRetries, cookie)
+			upstream.upstreamError(metrics.UpstreamFreeLimit)
+			logger.Warnf(ctx, "Cookie free rate limited, switching to next cookie, attempt %d/%d", attempt+1, maxRetries)
 			//if sessionImageChatManager != nil {
 			//	cookie, chatId, err = sessionImageChatManager.GetNextKeyValue()
 			//	if err != nil {
@@ -1454,19 +1665,23 @@ func ImageProcess(c *gin.Context, client cycletls.CycleTLS, openAIReq model.Open
 			//	}
 			//} else {
 			//cookieManager := config.NewCookieManager()
-			config.AddRateLimitCookie(cookie, time.Now().Add(24*60*60*time.Second))
+			markFreeLimit(cookie, openAIReq.Model)
 			// 删除cookie
 			//config.RemoveCookie(cookie)
+			previous := cookie
 			cookie, err = cookieManager.GetNextCookie()
 			if err != nil {
-				logger.Errorf(ctx, "No more valid cookies available after attempt %d", attempt+1)
+				logger.Errorf(ctx, "No more valid cookies available after attempt %d: %v", attempt+1, err)
 				c.JSON(http.StatusInternalServerError, gin.H{"error": errNoValidCookies})
-				return nil, fmt.Errorf(errNoValidCookies)
+				return nil, fmt.Errorf("%s: %v", errNoValidCookies, err)
 				//}
 			}
+			recordFailover(ctx, previous, cookie)
 			continue
 		case common.IsNotLogin(body):
-			logger.Warnf(ctx, "Cookie Not Login, switching to next cookie, attempt %d/%d, COOKIE:%s", attempt+1, maxRetries, cookie)
+			upstream.upstreamError(metrics.UpstreamNotLogin)
+			logger.Warnf(ctx, "Cookie Not Login, switching to next cookie, attempt %d/%d", attempt+1, maxRetries)
+			markNotLogin(cookie)
 			//if sessionImageChatManager != nil {
 			//	//sessionImageChatManager.RemoveKey(cookie)
 			//	cookie, chatId, err = sessionImageChatManager.GetNextKeyValue()
@@ -1481,33 +1696,43 @@ func ImageProcess(c *gin.Context, client cycletls.CycleTLS, openAIReq model.Open
 			//if err != nil {
 			//	logger.Errorf(ctx, "Failed to remove cookie: %v", err)
 			//}
+			previous := cookie
 			cookie, err = cookieManager.GetNextCookie()
 			if err != nil {
-				logger.Errorf(ctx, "No more valid cookies available after attempt %d", attempt+1)
+				logger.Errorf(ctx, "No more valid cookies available after attempt %d: %v", attempt+1, err)
 				c.JSON(http.StatusInternalServerError, gin.H{"error": errNoValidCookies})
-				return nil, fmt.Errorf(errNoValidCookies)
+				return nil, fmt.Errorf("%s: %v", errNoValidCookies, err)
 				//}
 
 			}
+			recordFailover(ctx, previous, cookie)
 			continue
 		case common.IsServerError(body):
+			upstream.upstreamError(metrics.UpstreamServerError)
 			logger.Errorf(ctx, errServerErrMsg)
 			return nil, fmt.Errorf(errServerErrMsg)
 		case common.IsServerOverloaded(body):
+			upstream.upstreamError(metrics.UpstreamOverload)
 			logger.Errorf(ctx, fmt.Sprintf("Server overloaded, please try again later.%s", "官方服务超载或环境变量 SESSION_IMAGE_CHAT_MAP 未配置"))
 			return nil, fmt.Errorf("Server overloaded, please try again later.")
 		}
 
 		// Extract task IDs
 		projectId, taskIDs := extractTaskIDs(response.Body)
+		config.TrackProject(cookie, openAIReq.Model, projectId)
 		if len(taskIDs) == 0 {
 			logger.Errorf(ctx, "Response body: %s", response.Body)
 			return nil, fmt.Errorf(errNoValidTaskIDs)
 		}
 
 		// Poll for image URLs
+		pollStart := time.Now()
+		endPoll := startSpan(c, "image.poll", tracing.AttrTasks.Int(len(taskIDs)))
 		imageURLs := pollTaskStatus(c, client, taskIDs, cookie)
+		endPoll(nil)
+		metrics.ObserveImagePoll(openAIReq.Model, time.Since(pollStart))
 		if len(imageURLs) == 0 {
+			upstream.fail(fmt.Errorf("no image URLs received"))
 			logger.Warnf(ctx, "No image URLs received, retrying with next cookie")
 			continue
 		}
@@ -1526,7 +1751,9 @@ func ImageProcess(c *gin.Context, client cycletls.CycleTLS, openAIReq model.Open
 			}
 
 			if openAIReq.ResponseFormat == "b64_json" {
+				endBase64 := startSpan(c, "image.base64")
 				base64Str, err := getBase64ByUrl(data.URL)
+				endBase64(err)
 				if err != nil {
 					logger.Errorf(ctx, "getBase64ByUrl error: %v", err)
 					continue
@@ -1539,12 +1766,13 @@ func ImageProcess(c *gin.Context, client cycletls.CycleTLS, openAIReq model.Open
 
 		// Handle successful case
 		if len(result.Data) > 0 {
+			upstream.span.SetAttributes(tracing.AttrImages.Int(len(result.Data)))
 			// Delete temporary session if needed
-			if config.AutoDelChat == 1 {
+			if config.Current().AutoDelChat == 1 {
 				go func() {
-					client := cycletls.Init()
-					defer safeClose(client)
-					makeDeleteRequest(client, cookie, projectId)
+					if _, err := DeleteProject(cookie, projectId); err != nil {
+						logger.SysError(fmt.Sprintf("delete image chat %s err: %v", projectId, err))
+					}
 				}()
 			}
 			return result, nil
@@ -1625,7 +1853,7 @@ func pollTaskStatus(c *gin.Context, client cycletls.CycleTLS, taskIDs []string,
 			// 发送请求
 			response, err := client.Do(url, cycletls.Options{
 				Timeout: 10 * 60 * 60,
-				Proxy:   config.ProxyUrl, // 在每个请求中设置代理
+				Proxy:   config.GetProxy(cookie), // 每个cookie固定使用绑定的代理
 				Method:  "GET",
 				Headers: map[string]string{
 					"Cookie": cookie,
@@ -0,0 +1,30 @@
+package controller
+
+import (
+	"genspark2api/check"
+	"genspark2api/common/config"
+	"github.com/gin-gonic/gin"
+	"net/http"
+)
+
+// GetConfig 查看当前生效的配置,敏感配置项已脱敏
+func GetConfig(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": config.ConfigItems()})
+}
+
+// ReloadConfig 重新读取配置文件,读取或校验失败时保留原配置
+func ReloadConfig(c *gin.Context) {
+	if config.ConfigFile == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": "未设置配置文件 CONFIG_FILE"})
+		return
+	}
+	changed, restart, err := check.ReloadConfigFile()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "message": "已重载配置文件", "data": gin.H{
+		"changed": changed,
+		"restart": restart,
+	}})
+}
@@ -0,0 +1,152 @@
+package controller
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"genspark2api/common/config"
+	"genspark2api/common/helper"
+	"genspark2api/model"
+	"github.com/gin-gonic/gin"
+	"regexp"
+	"strings"
+)
+
+const conversationIdHeader = "X-Conversation-Id"
+
+var thinkPattern = regexp.MustCompile(`(?s)<think>.*?</think>`)
+
+// conversation 一次 OpenAI 对话与 Genspark 对话的映射(AUTO_MODEL_CHAT_MAP_TYPE=2)
+type conversation struct {
+	Model string
+	// Key 本轮请求用于查找映射的对话标识,为空表示新对话
+	Key string
+	// Explicit 对话标识由请求头 X-Conversation-Id 或 user 字段指定
+	Explicit bool
+	// Cookie 及 ChatID 为已映射的 Genspark 对话
+	Cookie string
+	ChatID string
+
+	// history 规范化后的全部消息,用于计算下一轮的指纹
+	history []string
+}
+
+// newConversation 解析请求对应的对话,未开启按对话映射时返回 nil
+func newConversation(c *gin.Context, openAIReq *model.OpenAIChatCompletionRequest) *conversation {
+	if config.AutoModelChatMapType != 2 {
+		return nil
+	}
+
+	conv := &conversation{Model: openAIReq.Model}
+	for _, message := range openAIReq.Messages {
+		conv.history = append(conv.history, normalizeMessage(message))
+	}
+
+	if id := strings.TrimSpace(c.GetHeader(conversationIdHeader)); id != "" {
+		conv.Key = "id:" + id
+		conv.Explicit = true
+	} else if user := strings.TrimSpace(openAIReq.User); user != "" {
+		conv.Key = "user:" + user
+		conv.Explicit = true
+	} else {
+		// 以最后一条 user 消息之前的历史作为指纹,历史中没有 assistant 消息时视为新对话
+		lastUser := -1
+		for i := len(openAIReq.Messages) - 1; i >= 0; i-- {
+			if openAIReq.Messages[i].Role == "user" {
+				lastUser = i
+				break
+			}
+		}
+		if lastUser > 0 && hasAssistantMessage(openAIReq.Messages[:lastUser]) {
+			conv.Key = "fp:" + fingerprint(conv.history[:lastUser])
+		}
+	}
+
+	if conv.Key != "" {
+		conv.Cookie, conv.ChatID, _ = config.GlobalSessionManager.GetConversation(conv.Model, conv.Key)
+	}
+	return conv
+}
+
+// chatIdFor 获取对话在指定 cookie 下的 Genspark 对话ID,cookie 不一致时无法继续该对话
+func (conv *conversation) chatIdFor(cookie string) (string, bool) {
+	if conv.ChatID == "" || conv.Cookie != cookie {
+		return "", false
+	}
+	return conv.ChatID, true
+}
+
+// save 保存本轮对话的映射
+func (conv *conversation) save(cookie string, chatID string, answer string) {
+	if chatID == "" {
+		return
+	}
+	if conv.Explicit {
+		config.GlobalSessionManager.AddConversation(cookie, conv.Model, conv.Key, chatID)
+		return
+	}
+
+	// 指纹随对话推进而变化,保存包含本轮回答的新指纹并删除旧指纹
+	history := append(append([]string{}, conv.history...), normalizeContent("assistant", answer))
+	config.GlobalSessionManager.AddConversation(cookie, conv.Model, "fp:"+fingerprint(history), chatID)
+	if conv.Key != "" {
+		config.GlobalSessionManager.DeleteConversation(conv.Model, conv.Key)
+	}
+}
+
+func hasAssistantMessage(messages []model.OpenAIChatMessage) bool {
+	for _, message := range messages {
+		if message.Role == "assistant" {
+			return true
+		}
+	}
+	return false
+}
+
+func normalizeMessage(message model.OpenAIChatMessage) string {
+	switch content := message.Content.(type) {
+	case string:
+		return normalizeContent(message.Role, content)
+	default:
+		bytes, _ := json.Marshal(content)
+		return normalizeContent(message.Role, string(bytes))
+	}
+}
+
+// normalizeContent 去掉思考过程及首尾空白,客户端回传的 assistant 消息通常会做同样的处理
+func normalizeContent(role string, content string) string {
+	if role == "assistant" {
+		content = thinkPattern.ReplaceAllString(content, "")
+	}
+	return role + "\x00" + strings.TrimSpace(content)
+}
+
+func fingerprint(history []string) string {
+	hash := sha256.New()
+	for _, item := range history {
+		hash.Write([]byte(item))
+		hash.Write([]byte{0x1e})
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// appendCompletionContent 累计已返回的回答内容
+func appendCompletionContent(c *gin.Context, content string) {
+	if content == "" {
+		return
+	}
+	builder, ok := c.Get(helper.CompletionKey)
+	if !ok {
+		builder = &strings.Builder{}
+		c.Set(helper.CompletionKey, builder)
+	}
+	builder.(*strings.Builder).WriteString(content)
+}
+
+// getCompletionContent 获取已返回的全部回答内容
+func getCompletionContent(c *gin.Context) string {
+	if builder, ok := c.Get(helper.CompletionKey); ok {
+		return builder.(*strings.Builder).String()
+	}
+	return ""
+}
@@ -0,0 +1,68 @@
+package controller
+
+import (
+	"genspark2api/common/dialog"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// SearchDialogs 查找对话记录,按时间倒序返回
+// 参数: start_date/end_date(YYYY-MM-DD 包含结束日期,或 RFC3339 时间),key_id,model,request_id,q(请求及回答中的关键字),limit(默认 100)
+func SearchDialogs(c *gin.Context) {
+	if !dialog.Enabled() {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": "未开启对话记录 ALL_DIALOG_RECORD_ENABLE"})
+		return
+	}
+	q := dialog.Query{
+		KeyId:     c.Query("key_id"),
+		Model:     c.Query("model"),
+		RequestId: c.Query("request_id"),
+		Keyword:   c.Query("q"),
+	}
+	var err error
+	if v := c.Query("start_date"); v != "" {
+		if q.From, err = parseDialogTime(v, false); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": "start_date must be in YYYY-MM-DD or RFC3339 format"})
+			return
+		}
+	}
+	if v := c.Query("end_date"); v != "" {
+		if q.To, err = parseDialogTime(v, true); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": "end_date must be in YYYY-MM-DD or RFC3339 format"})
+			return
+		}
+	}
+	if v := c.Query("limit"); v != "" {
+		if q.Limit, err = strconv.Atoi(v); err != nil || q.Limit <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": "limit must be a positive integer"})
+			return
+		}
+	}
+
+	records, err := dialog.Search(q)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "message": err.Error()})
+		return
+	}
+	if records == nil {
+		records = []dialog.Record{}
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": records})
+}
+
+// parseDialogTime 解析日期或 RFC3339 时间,日期作为结束时间时包含当天
+func parseDialogTime(v string, end bool) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t, nil
+	}
+	t, err := time.ParseInLocation(usageDateFormat, v, time.Local)
+	if err != nil {
+		return t, err
+	}
+	if end {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t, nil
+}
@@ -0,0 +1,94 @@
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"genspark2api/common/config"
+	"genspark2api/model"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/samber/lo"
+)
+
+const (
+	// modelFallbackHeader 请求头,设置为 off/false/0 时本次请求不降级
+	modelFallbackHeader = "X-Model-Fallback"
+	// modelHeader 响应头,实际回答的模型
+	modelHeader = "X-Model"
+)
+
+// modelFallback 一次请求的模型降级状态
+type modelFallback struct {
+	// request 原始请求,降级时重新解析,避免使用已被修改的消息
+	request []byte
+	// chain 剩余的降级模型
+	chain []string
+}
+
+// newModelFallback 创建降级状态,请求关闭降级或模型没有降级链时返回 nil
+func newModelFallback(c *gin.Context, openAIReq *model.OpenAIChatCompletionRequest) *modelFallback {
+	switch strings.ToLower(strings.TrimSpace(c.GetHeader(modelFallbackHeader))) {
+	case "off", "false", "0":
+		return nil
+	}
+	if openAIReq.Fallback != nil && !*openAIReq.Fallback {
+		return nil
+	}
+	// 跳过当前 Key 无权使用的模型
+	tenant := getTenant(c)
+	chain := lo.Filter(config.GetModelFallbacks(openAIReq.Model), func(m string, _ int) bool {
+		return tenant.AllowsModel(m)
+	})
+	if len(chain) == 0 {
+		return nil
+	}
+	request, err := json.Marshal(openAIReq)
+	if err != nil {
+		return nil
+	}
+	return &modelFallback{request: request, chain: chain}
+}
+
+// allows 是否可以因 reason 降级,已向客户端返回内容时不再降级
+func (f *modelFallback) allows(c *gin.Context, reason string) bool {
+	return f != nil && len(f.chain) > 0 && !c.Writer.Written() &&
+		lo.Contains(config.Current().ModelFallbackConditions, reason)
+}
+
+// next 下一个降级模型的请求
+func (f *modelFallback) next() (model.OpenAIChatCompletionRequest, error) {
+	var openAIReq model.OpenAIChatCompletionRequest
+	if err := json.Unmarshal(f.request, &openAIReq); err != nil {
+		return openAIReq, err
+	}
+	openAIReq.Model, f.chain = f.chain[0], f.chain[1:]
+	return openAIReq, nil
+}
+
+// checkFallbackModel 降级前重新校验租户是否可以请求降级模型及模型限速,通过时扣除该模型的一次请求
+// 不允许时返回错误响应
+func checkFallbackModel(c *gin.Context, modelName string) bool {
+	if !checkTenantModel(c, modelName) {
+		return false
+	}
+	result, errType := config.CheckModelRateLimit(modelName)
+	if result.Allowed {
+		result, errType = config.TakeModelRequest(modelName), "requests"
+	}
+	if result.Allowed {
+		return true
+	}
+	c.Header("Retry-After", strconv.Itoa(int(math.Max(math.Ceil(result.RetryAfter.Seconds()), 1))))
+	c.JSON(http.StatusTooManyRequests, model.OpenAIErrorResponse{
+		OpenAIError: model.OpenAIError{
+			Message: fmt.Sprintf("Rate limit reached for model %s %s per minute, limit: %d.", modelName, errType, result.Limit),
+			Type:    errType,
+			Code:    "rate_limit_exceeded",
+		},
+	})
+	return false
+}
@@ -0,0 +1,102 @@
+package controller
+
+import (
+	"genspark2api/common"
+	"genspark2api/common/config"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// 签名服务探测结果的缓存时间,避免频繁的就绪探针请求签名服务
+	cheatCheckInterval = 30 * time.Second
+	cheatCheckTimeout  = 5 * time.Second
+)
+
+var cheatCheck struct {
+	checkedAt time.Time
+	err       error
+	mutex     sync.Mutex
+}
+
+// checkCheatUrl 检查签名服务是否可以连接,返回任意 HTTP 响应即视为可连接
+func checkCheatUrl() error {
+	cheatCheck.mutex.Lock()
+	defer cheatCheck.mutex.Unlock()
+	if time.Since(cheatCheck.checkedAt) < cheatCheckInterval {
+		return cheatCheck.err
+	}
+	client := &http.Client{Timeout: cheatCheckTimeout}
+	resp, err := client.Head(config.CheatUrl)
+	if err == nil {
+		resp.Body.Close()
+	}
+	cheatCheck.checkedAt = time.Now()
+	cheatCheck.err = err
+	return err
+}
+
+// Healthz 存活探针,进程正常运行即返回200
+func Healthz(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+// Readyz 就绪探针,至少有一个可用(未失效且未限速)的cookie,且配置 CHEAT_URL 时签名服务可以连接
+func Readyz(c *gin.Context) {
+	ready := true
+	pool := config.GetCookiePoolStats()
+	cookies := gin.H{"ok": pool.Usable > 0, "usable": pool.Usable, "total": pool.Total}
+	if pool.Usable == 0 {
+		ready = false
+		cookies["error"] = "no usable cookies"
+	}
+	checks := gin.H{"cookies": cookies}
+
+	if config.CheatEnabled() {
+		cheat := gin.H{"ok": true}
+		if err := checkCheatUrl(); err != nil {
+			ready = false
+			cheat = gin.H{"ok": false, "error": "cheat service unreachable"}
+		}
+		checks["cheat"] = cheat
+	}
+
+	if !ready {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"status": "unavailable", "checks": checks})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "ready", "checks": checks})
+}
+
+// GetStatus 查看版本、运行时间及cookie池、会话、限速状态
+func GetStatus(c *gin.Context) {
+	concurrency, streams := config.TenantSlotStats()
+	sessions := gin.H{
+		"projects":    len(config.StateStore.Projects()),
+		"model_chats": len(config.StateStore.ModelChats()),
+	}
+	if config.GlobalSessionManager != nil {
+		sessions["sessions"] = config.GlobalSessionManager.Size()
+	}
+	data := gin.H{
+		"version":    common.Version,
+		"start_time": time.Unix(common.StartTime, 0),
+		"uptime":     time.Now().Unix() - common.StartTime,
+		"cookies":    config.GetCookiePoolStats(),
+		"sessions":   sessions,
+		"limiter": gin.H{
+			"locked_cookies":  config.StateStore.CountLockedCookies(),
+			"active_requests": concurrency,
+			"active_streams":  streams,
+		},
+	}
+	if config.GlobalProxyPool.Size() > 0 {
+		data["proxies"] = config.GlobalProxyPool.Stats()
+	}
+	if config.ProjectCleanupEnable == 1 {
+		data["project_cleanup"] = config.LastProjectCleanupReport()
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": data})
+}
@@ -0,0 +1,21 @@
+package controller
+
+import (
+	"genspark2api/common/config"
+	"github.com/gin-gonic/gin"
+	"net/http"
+)
+
+// GetIPPolicy 查看当前生效的IP规则
+func GetIPPolicy(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": config.GetIPPolicy()})
+}
+
+// ReloadIPPolicy 重新读取IP规则,读取失败时保留原规则
+func ReloadIPPolicy(c *gin.Context) {
+	if err := config.ReloadIPPolicy(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "message": "已重载IP规则", "data": config.GetIPPolicy()})
+}
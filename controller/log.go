@@ -0,0 +1,42 @@
+package controller
+
+import (
+	logger "genspark2api/common/loggger"
+	"github.com/gin-gonic/gin"
+	"net/http"
+)
+
+// GetLogLevel 查看当前日志级别
+func GetLogLevel(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": logger.GetLevels()})
+}
+
+// SetLogLevel 设置日志级别,立即生效,重启后恢复为 LOG_LEVEL
+func SetLogLevel(c *gin.Context) {
+	var req logger.Levels
+	if err := c.BindJSON(&req); err != nil {
+		return
+	}
+	if req.Level == "" {
+		req.Level = logger.GetLevels().Level
+	}
+	if err := logger.SetLevels(req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": err.Error()})
+		return
+	}
+	logger.SysLog("log level changed to " + logger.GetLevels().String())
+	c.JSON(http.StatusOK, gin.H{"success": true, "message": "已设置日志级别", "data": logger.GetLevels()})
+}
+
+// RotateLogFiles 立即轮转日志文件
+func RotateLogFiles(c *gin.Context) {
+	if !logger.HasLogFiles() {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": "未设置日志目录 LOG_DIR"})
+		return
+	}
+	if err := logger.Rotate(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "message": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "message": "已轮转日志文件"})
+}
@@ -0,0 +1,77 @@
+package controller
+
+import (
+	"fmt"
+	"genspark2api/common"
+	"genspark2api/common/config"
+	"genspark2api/model"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"strings"
+)
+
+// OpenaiModels 当前 API Key 可用的全部模型,包含支持联网的模型的 -search 变体
+func OpenaiModels(c *gin.Context) {
+	tenant := getTenant(c)
+	openaiModelResponse := make([]model.OpenaiModelResponse, 0)
+	for _, info := range config.Current().Models.List() {
+		if tenant.AllowsModel(info.Id) {
+			openaiModelResponse = append(openaiModelResponse, newOpenaiModelResponse(info, false))
+		}
+		if info.Kind == config.ModelKindText && info.Search && tenant.AllowsModel(info.Id+config.SearchModelSuffix) {
+			openaiModelResponse = append(openaiModelResponse, newOpenaiModelResponse(info, true))
+		}
+	}
+	c.JSON(http.StatusOK, model.OpenaiModelListResponse{
+		Object: "list",
+		Data:   openaiModelResponse,
+	})
+}
+
+// RetrieveModel 查询模型,别名返回对应的模型,不存在或当前 API Key 无权使用时返回 404
+func RetrieveModel(c *gin.Context) {
+	// 模型名可能包含 /(如 flux-pro/ultra)
+	id := strings.TrimPrefix(c.Param("id"), "/")
+	registry := config.Current().Models
+	info, ok := registry.Lookup(id)
+	search := strings.HasSuffix(id, config.SearchModelSuffix)
+	if !ok || (search && info.Kind != config.ModelKindText) || !getTenant(c).AllowsModel(registry.Resolve(id)) {
+		c.JSON(http.StatusNotFound, model.OpenAIErrorResponse{
+			OpenAIError: model.OpenAIError{
+				Message: fmt.Sprintf("The model '%s' does not exist or you do not have access to it.", id),
+				Type:    "invalid_request_error",
+				Code:    "model_not_found",
+			},
+		})
+		return
+	}
+	c.JSON(http.StatusOK, newOpenaiModelResponse(info, search))
+}
+
+func newOpenaiModelResponse(info *config.ModelInfo, search bool) model.OpenaiModelResponse {
+	resp := model.OpenaiModelResponse{
+		ID:            info.Id,
+		Object:        "model",
+		Created:       common.StartTime,
+		OwnedBy:       info.OwnedBy,
+		Type:          info.Kind,
+		Aliases:       info.Aliases,
+		Models:        info.Models,
+		ContextWindow: info.ContextLength,
+		Capabilities: model.OpenaiModelCapabilities{
+			Vision:          info.Vision,
+			Reasoning:       info.Reasoning,
+			Search:          info.Search,
+			ImageGeneration: info.Kind == config.ModelKindImage,
+		},
+	}
+	if resp.OwnedBy == "" {
+		resp.OwnedBy = "genspark"
+	}
+	if search {
+		resp.ID = info.Id + config.SearchModelSuffix
+		resp.Parent = info.Id
+		resp.Aliases = nil
+	}
+	return resp
+}
@@ -0,0 +1,40 @@
+package controller
+
+import (
+	"fmt"
+	"genspark2api/common/config"
+	logger "genspark2api/common/loggger"
+	"github.com/deanxv/CycleTLS/cycletls"
+)
+
+// DeleteProject 删除 Genspark 对话,映射中的对话不会被删除,返回是否实际删除
+func DeleteProject(cookie, projectId string) (bool, error) {
+	if config.IsProtectedProject(cookie, projectId) {
+		return false, nil
+	}
+
+	client := cycletls.Init()
+	defer safeClose(client)
+
+	response, err := makeDeleteRequest(client, cookie, projectId)
+	if err != nil {
+		return false, err
+	}
+	if response.Status != 200 {
+		return false, fmt.Errorf("delete project %s failed, status: %d", projectId, response.Status)
+	}
+	config.UntrackProject(projectId)
+	return true, nil
+}
+
+// OnSessionEvicted 会话映射被淘汰后按配置删除对应的对话
+func OnSessionEvicted(cookie string, chatID string) {
+	if config.SessionEvictDelChat != 1 || cookie == "" || chatID == "" {
+		return
+	}
+	go func() {
+		if _, err := DeleteProject(cookie, chatID); err != nil {
+			logger.SysError(fmt.Sprintf("delete evicted session chat %s err: %v", chatID, err))
+		}
+	}()
+}
@@ -0,0 +1,51 @@
+package controller
+
+import (
+	"fmt"
+	"genspark2api/common/config"
+	"genspark2api/common/helper"
+	"genspark2api/model"
+	"github.com/gin-gonic/gin"
+	"net/http"
+)
+
+// getTenant 获取鉴权中间件解析的租户,未经过鉴权时为匿名租户
+func getTenant(c *gin.Context) *config.Tenant {
+	if tenant, ok := c.Get(helper.TenantKey); ok {
+		return tenant.(*config.Tenant)
+	}
+	return &config.Tenant{ID: config.TenantAnonymous, Name: config.TenantAnonymous}
+}
+
+// checkTenantModel 校验租户是否可以请求该模型,不允许时返回错误响应
+func checkTenantModel(c *gin.Context, modelName string) bool {
+	if getTenant(c).AllowsModel(modelName) {
+		return true
+	}
+	c.JSON(http.StatusForbidden, model.OpenAIErrorResponse{
+		OpenAIError: model.OpenAIError{
+			Message: fmt.Sprintf("The api key is not allowed to use model %s", modelName),
+			Type:    "invalid_request_error",
+			Code:    "model_not_allowed",
+		},
+	})
+	return false
+}
+
+// getUsage 获取本次请求的用量记录
+func getUsage(c *gin.Context) *config.UsageRecord {
+	if usage, ok := c.Get(helper.UsageKey); ok {
+		return usage.(*config.UsageRecord)
+	}
+	usage := &config.UsageRecord{}
+	c.Set(helper.UsageKey, usage)
+	return usage
+}
+
+// recordUsage 累计本次请求及租户的 token 用量
+func recordUsage(c *gin.Context, promptTokens, completionTokens int) {
+	usage := getUsage(c)
+	usage.PromptTokens += promptTokens
+	usage.CompletionTokens += completionTokens
+	config.AddTenantTokens(getTenant(c).ID, promptTokens+completionTokens)
+}
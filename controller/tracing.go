@@ -0,0 +1,97 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"genspark2api/common/alert"
+	"genspark2api/common/config"
+	"genspark2api/common/metrics"
+	"genspark2api/common/tracing"
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// startSpan 在请求上下文中开始 span,结束前通过 c.Request.Context() 创建的 span 均为其子 span
+func startSpan(c *gin.Context, name string, attrs ...attribute.KeyValue) func(err error) {
+	parent := c.Request.Context()
+	ctx, span := tracing.Start(parent, name, trace.WithAttributes(attrs...))
+	c.Request = c.Request.WithContext(ctx)
+	return func(err error) {
+		tracing.End(span, err)
+		c.Request = c.Request.WithContext(parent)
+	}
+}
+
+// 上游请求结果
+const outcomeSuccess = "success"
+
+// upstreamAttempt 使用某个cookie进行的一次上游请求
+type upstreamAttempt struct {
+	span      trace.Span
+	outcome   string
+	err       error
+	firstByte bool
+	ended     bool
+}
+
+// startAttempt 开始一次上游请求的 span,nil 安全
+func startAttempt(ctx context.Context, operation, modelName, cookie string, attempt int) *upstreamAttempt {
+	attrs := append(tracing.GenAIAttributes(operation, modelName),
+		tracing.AttrAttempt.Int(attempt+1),
+		tracing.AttrCookie.String(config.CookieFingerprint(cookie)),
+	)
+	_, span := tracing.Start(ctx, operation+" "+modelName,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attrs...),
+	)
+	return &upstreamAttempt{span: span, outcome: outcomeSuccess}
+}
+
+// receivedFirstByte 记录收到上游首个字节的时间
+func (a *upstreamAttempt) receivedFirstByte() {
+	if a == nil || a.firstByte {
+		return
+	}
+	a.firstByte = true
+	a.span.AddEvent("first_byte")
+}
+
+// upstreamError 记录上游错误分类
+func (a *upstreamAttempt) upstreamError(errType string) {
+	metrics.UpstreamError(errType)
+	if errType == metrics.UpstreamCloudflareChallenge {
+		alert.CloudflareChallenge()
+	}
+	if a != nil {
+		a.outcome = errType
+	}
+}
+
+// fail 记录请求失败
+func (a *upstreamAttempt) fail(err error) {
+	if a != nil {
+		a.err = err
+	}
+}
+
+// end 结束 span,重复调用时为空操作
+func (a *upstreamAttempt) end() {
+	if a == nil || a.ended {
+		return
+	}
+	a.ended = true
+	a.span.SetAttributes(tracing.AttrOutcome.String(a.outcome))
+	if a.err == nil && a.outcome != outcomeSuccess {
+		a.err = errors.New(a.outcome)
+	}
+	tracing.End(a.span, a.err)
+}
+
+// recordFailover 在请求 span 中记录cookie切换
+func recordFailover(ctx context.Context, from, to string) {
+	trace.SpanFromContext(ctx).AddEvent("cookie_failover", trace.WithAttributes(
+		attribute.String("from", config.CookieFingerprint(from)),
+		attribute.String("to", config.CookieFingerprint(to)),
+	))
+}
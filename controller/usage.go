@@ -0,0 +1,119 @@
+package controller
+
+import (
+	"encoding/csv"
+	"fmt"
+	"genspark2api/common/config"
+	"genspark2api/model"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const usageDateFormat = "2006-01-02"
+
+// GetUsage 按 API Key、模型及日期汇总用量
+// 参数: start_date/end_date(YYYY-MM-DD,包含结束日期,默认本月),key_id,model,group_by(默认 key,model,day),format(json/csv)
+// 管理员密钥可查看全部 Key 的用量,其它 Key 仅可查看自身的用量
+func GetUsage(c *gin.Context) {
+	now := time.Now()
+	from := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.Local)
+	to := from.AddDate(0, 1, 0)
+	var err error
+	if v := c.Query("start_date"); v != "" {
+		if from, err = time.ParseInLocation(usageDateFormat, v, time.Local); err != nil {
+			usageBadRequest(c, "start_date must be in YYYY-MM-DD format")
+			return
+		}
+	}
+	if v := c.Query("end_date"); v != "" {
+		if to, err = time.ParseInLocation(usageDateFormat, v, time.Local); err != nil {
+			usageBadRequest(c, "end_date must be in YYYY-MM-DD format")
+			return
+		}
+		to = to.AddDate(0, 0, 1)
+	}
+	if !to.After(from) {
+		usageBadRequest(c, "end_date must not be before start_date")
+		return
+	}
+
+	groupBy := []string{config.UsageGroupKey, config.UsageGroupModel, config.UsageGroupDay}
+	if v := c.Query("group_by"); v != "" {
+		groupBy = nil
+		for _, g := range strings.Split(v, ",") {
+			g = strings.TrimSpace(g)
+			if g != config.UsageGroupKey && g != config.UsageGroupModel && g != config.UsageGroupDay {
+				usageBadRequest(c, fmt.Sprintf("group_by %s is not supported, use key, model or day", g))
+				return
+			}
+			groupBy = append(groupBy, g)
+		}
+	}
+
+	tenant := getTenant(c)
+	keyId := c.Query("key_id")
+	if tenant.ID != config.TenantDefault {
+		// 非管理员(包括未设置 API_SECRET 时的匿名请求)仅可查看自身的用量
+		keyId = tenant.ID
+	}
+	modelName := c.Query("model")
+
+	var records []config.UsageRecord
+	for _, record := range config.UsageRecords(from, to) {
+		if keyId != "" && record.KeyId != keyId {
+			continue
+		}
+		if modelName != "" && record.Model != modelName {
+			continue
+		}
+		records = append(records, record)
+	}
+	summaries := config.SummarizeUsage(records, groupBy)
+
+	if c.Query("format") == "csv" {
+		writeUsageCSV(c, summaries, from, to)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"object":     "list",
+		"start_date": from.Format(usageDateFormat),
+		"end_date":   to.AddDate(0, 0, -1).Format(usageDateFormat),
+		"data":       summaries,
+	})
+}
+
+func writeUsageCSV(c *gin.Context, summaries []*config.UsageSummary, from, to time.Time) {
+	c.Header("Content-Type", "text/csv; charset=utf-8")
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=usage_%s_%s.csv",
+		from.Format(usageDateFormat), to.AddDate(0, 0, -1).Format(usageDateFormat)))
+	c.Status(http.StatusOK)
+
+	writer := csv.NewWriter(c.Writer)
+	_ = writer.Write([]string{"date", "key_id", "key_name", "model", "requests", "errors",
+		"prompt_tokens", "completion_tokens", "total_tokens", "images", "avg_latency_ms", "cost"})
+	for _, s := range summaries {
+		cost := ""
+		if s.Cost != nil {
+			cost = strconv.FormatFloat(*s.Cost, 'f', 6, 64)
+		}
+		_ = writer.Write([]string{s.Date, s.KeyId, s.KeyName, s.Model,
+			strconv.Itoa(s.Requests), strconv.Itoa(s.Errors),
+			strconv.FormatInt(s.PromptTokens, 10), strconv.FormatInt(s.CompletionTokens, 10),
+			strconv.FormatInt(s.TotalTokens, 10), strconv.FormatInt(s.Images, 10),
+			strconv.FormatInt(s.AvgLatencyMs, 10), cost})
+	}
+	writer.Flush()
+}
+
+func usageBadRequest(c *gin.Context, message string) {
+	c.JSON(http.StatusBadRequest, model.OpenAIErrorResponse{
+		OpenAIError: model.OpenAIError{
+			Message: message,
+			Type:    "invalid_request_error",
+			Code:    "invalid_parameter",
+		},
+	})
+}
@@ -0,0 +1,17 @@
+package job
+
+import (
+	"genspark2api/common/alert"
+	"time"
+)
+
+// 检查可用cookie数的间隔
+const alertPoolCheckInterval = 30 * time.Second
+
+// AlertPoolTask 定时检查可用cookie数,低于 ALERT_POOL_THRESHOLD 时告警
+func AlertPoolTask() {
+	for {
+		alert.CheckPool()
+		time.Sleep(alertPoolCheckInterval)
+	}
+}
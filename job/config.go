@@ -0,0 +1,19 @@
+package job
+
+import (
+	"fmt"
+	"genspark2api/check"
+	"genspark2api/common/config"
+	logger "genspark2api/common/loggger"
+)
+
+// ConfigWatchTask 监听 CONFIG_FILE,文件变化后重载可热重载的配置项
+func ConfigWatchTask() {
+	watchPath("ConfigWatchTask", config.ConfigFile, nil, reloadConfig)
+}
+
+func reloadConfig() {
+	if _, _, err := check.ReloadConfigFile(); err != nil {
+		logger.SysError(fmt.Sprintf("genspark2api reload config file err: %v", err))
+	}
+}
@@ -1,35 +1,34 @@
 package job
 
 import (
+	"fmt"
 	"genspark2api/common/config"
 	logger "genspark2api/common/loggger"
-	"math/rand"
-	"time"
+	"strings"
 )
 
-func LoadCookieTask() {
-	for {
-		source := rand.NewSource(time.Now().UnixNano())
-		randomNumber := rand.New(source).Intn(60) // 生成0到60之间的随机整数
-
-		// 计算距离下一个时间间隔
-		now := time.Now()
-		next := time.Date(now.Year(), now.Month(), now.Day(), 17, 5, 0, 0, now.Location())
-
-		// 如果当前时间已经超过9点，那么等待到第二天的9点
-		if now.After(next) {
-			next = next.Add(24 * time.Hour)
-		}
-
-		delay := next.Sub(now)
-
-		// 等待直到下一个间隔
-		time.Sleep(delay + time.Duration(randomNumber)*time.Second)
-
-		logger.SysLog("genspark2api Scheduled LoadCookieTask Task Job Start!")
+// CookieWatchTask 监听 GS_COOKIE_FILE,文件变化后重载cookie池
+func CookieWatchTask() {
+	watchPath("CookieWatchTask", config.GSCookieFile, config.IsCookieFileName, reloadCookies)
+}
 
-		config.InitGSCookies()
+func reloadCookies() {
+	added, removed, err := config.ReloadGSCookies()
+	if err != nil {
+		logger.SysError(fmt.Sprintf("genspark2api reload cookies err: %v", err))
+		return
+	}
+	if len(added) == 0 && len(removed) == 0 {
+		return
+	}
+	logger.SysLog(fmt.Sprintf("genspark2api reload cookies, added: %d %s, removed: %d %s, total: %d",
+		len(added), cookieFingerprints(added), len(removed), cookieFingerprints(removed), len(config.GetGSCookies())))
+}
 
-		logger.SysLog("genspark2api Scheduled LoadCookieTask Task Job  End!")
+func cookieFingerprints(cookies []string) string {
+	fingerprints := make([]string, 0, len(cookies))
+	for _, cookie := range cookies {
+		fingerprints = append(fingerprints, config.CookieFingerprint(cookie))
 	}
+	return "[" + strings.Join(fingerprints, ",") + "]"
 }
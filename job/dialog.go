@@ -0,0 +1,25 @@
+package job
+
+import (
+	"fmt"
+	"genspark2api/common/dialog"
+	logger "genspark2api/common/loggger"
+	"time"
+)
+
+// DialogRecordTask 每天0点轮转对话记录文件,并删除超出保留天数的文件
+func DialogRecordTask() {
+	for {
+		time.Sleep(time.Until(logger.NextRotation(time.Now(), 24*time.Hour)))
+		if err := dialog.Rotate(); err != nil {
+			logger.SysError(fmt.Sprintf("genspark2api DialogRecordTask rotate err: %v", err))
+		}
+		purged, err := dialog.Purge()
+		if err != nil {
+			logger.SysError(fmt.Sprintf("genspark2api DialogRecordTask purge err: %v", err))
+		}
+		if purged > 0 {
+			logger.SysLog(fmt.Sprintf("genspark2api DialogRecordTask purged %d dialog record file(s)", purged))
+		}
+	}
+}
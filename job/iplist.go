@@ -0,0 +1,20 @@
+package job
+
+import (
+	"fmt"
+	"genspark2api/common/config"
+	logger "genspark2api/common/loggger"
+)
+
+// IPListWatchTask 监听 IP_LIST_FILE,文件变化后重载IP规则
+func IPListWatchTask() {
+	watchPath("IPListWatchTask", config.IpListFile, nil, reloadIPPolicy)
+}
+
+func reloadIPPolicy() {
+	if err := config.ReloadIPPolicy(); err != nil {
+		logger.SysError(fmt.Sprintf("genspark2api reload ip list err: %v", err))
+		return
+	}
+	logger.SysLog("genspark2api reload ip list success")
+}
@@ -0,0 +1,26 @@
+package job
+
+import (
+	"fmt"
+	"genspark2api/common/config"
+	logger "genspark2api/common/loggger"
+	"time"
+)
+
+// JwksRefreshTask 定时刷新 JWKS URL,JWKS 为文件时监听文件变化
+func JwksRefreshTask() {
+	if !config.IsJwtURL() {
+		watchPath("JwksWatchTask", config.JwtJwks, nil, reloadJwks)
+		return
+	}
+	for {
+		time.Sleep(time.Duration(config.JwtJwksRefreshInterval) * time.Second)
+		reloadJwks()
+	}
+}
+
+func reloadJwks() {
+	if err := config.ReloadJwks(); err != nil {
+		logger.SysError(fmt.Sprintf("genspark2api reload jwks err: %v", err))
+	}
+}
@@ -0,0 +1,35 @@
+package job
+
+import (
+	"fmt"
+	"genspark2api/common/config"
+	logger "genspark2api/common/loggger"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// LogRotateTask 每隔 LOG_ROTATE_INTERVAL 小时轮转日志文件
+func LogRotateTask() {
+	interval := time.Duration(config.LogRotateInterval) * time.Hour
+	for {
+		time.Sleep(time.Until(logger.NextRotation(time.Now(), interval)))
+		if err := logger.Rotate(); err != nil {
+			logger.SysError(fmt.Sprintf("genspark2api LogRotateTask err: %v", err))
+		}
+	}
+}
+
+// LogReopenTask 收到 SIGHUP 时重新打开日志文件,配合 logrotate 等外部工具使用
+func LogReopenTask() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	for range signals {
+		if err := logger.Reopen(); err != nil {
+			logger.SysError(fmt.Sprintf("genspark2api reopen log files err: %v", err))
+			continue
+		}
+		logger.SysLog("genspark2api log files reopened")
+	}
+}
@@ -0,0 +1,116 @@
+package job
+
+import (
+	"fmt"
+	"genspark2api/common/config"
+	logger "genspark2api/common/loggger"
+	"genspark2api/controller"
+	"strings"
+	"time"
+)
+
+// ProjectCleanupTask 定时删除过期及遗留的 Genspark 对话
+func ProjectCleanupTask() {
+	for {
+		time.Sleep(time.Duration(config.ProjectCleanupInterval) * time.Second)
+
+		logger.SysLog("genspark2api Scheduled ProjectCleanupTask Task Job Start!")
+
+		report := CleanupProjects()
+		logger.SysLog(fmt.Sprintf("genspark2api ProjectCleanupTask tracked: %d, expired deleted: %d, orphaned deleted: %d, protected: %d, failed: %d, abandoned: %d, remaining: %d, duration: %s",
+			report.Tracked, len(report.Expired), len(report.Orphaned), report.Protected, len(report.Failed), len(report.Abandoned), report.Remaining, report.Duration))
+		if len(report.Expired)+len(report.Orphaned) > 0 {
+			logger.SysLog(fmt.Sprintf("genspark2api ProjectCleanupTask deleted: %s", strings.Join(append(append([]string{}, report.Expired...), report.Orphaned...), ",")))
+		}
+		if len(report.Failed) > 0 {
+			logger.SysError(fmt.Sprintf("genspark2api ProjectCleanupTask failed: %s", strings.Join(report.Failed, ",")))
+		}
+
+		logger.SysLog("genspark2api Scheduled ProjectCleanupTask Task Job  End!")
+	}
+}
+
+// CleanupProjects 执行一次对话清理
+// 映射中的对话(MODEL_CHAT_MAP、会话映射、SESSION_IMAGE_CHAT_MAP)不会被删除
+func CleanupProjects() *config.ProjectCleanupReport {
+	report := &config.ProjectCleanupReport{StartedAt: time.Now()}
+
+	cookies := make(map[string]bool)
+	for _, cookie := range config.GetGSCookies() {
+		cookies[cookie] = true
+	}
+
+	projects := config.TrackedProjects()
+	report.Tracked = len(projects)
+
+	now := time.Now()
+	ttl := time.Duration(config.ProjectCleanupTTL) * time.Second
+	grace := time.Duration(config.ProjectOrphanGracePeriod) * time.Second
+
+	guard := config.NewProjectGuard()
+	deleted := 0
+	for _, project := range projects {
+		if guard.Protects(project.Cookie, project.ID) {
+			report.Protected++
+			continue
+		}
+
+		idle := now.Sub(project.UsedAt)
+		expired := config.ProjectCleanupTTL > 0 && idle > ttl
+		// 未被映射且本应被自动删除的对话,或所属 cookie 已被移除的对话
+		orphaned := idle > grace && (config.Current().AutoDelChat == 1 || !cookies[project.Cookie])
+		if !expired && !orphaned {
+			continue
+		}
+
+		if deleted > 0 {
+			// 限速
+			time.Sleep(time.Duration(config.ProjectCleanupRateInterval) * time.Millisecond)
+		}
+		deleted++
+
+		ok, err := deleteProjectWithRetry(project)
+		if err != nil {
+			logger.SysError(fmt.Sprintf("genspark2api ProjectCleanupTask delete %s err: %v", project.ID, err))
+			if !cookies[project.Cookie] {
+				// cookie 已被移除,无法再删除,放弃该记录
+				config.UntrackProject(project.ID)
+				report.Abandoned = append(report.Abandoned, project.ID)
+			} else {
+				report.Failed = append(report.Failed, project.ID)
+			}
+			continue
+		}
+		if !ok {
+			// 清理期间对话被重新映射
+			report.Protected++
+			continue
+		}
+
+		if expired {
+			report.Expired = append(report.Expired, project.ID)
+		} else {
+			report.Orphaned = append(report.Orphaned, project.ID)
+		}
+	}
+
+	report.Remaining = report.Tracked - len(report.Expired) - len(report.Orphaned) - len(report.Abandoned)
+	report.Duration = time.Since(report.StartedAt)
+
+	config.SetProjectCleanupReport(report)
+	return report
+}
+
+func deleteProjectWithRetry(project config.TrackedProject) (bool, error) {
+	var err error
+	for attempt := 0; attempt <= config.ProjectCleanupRetries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(time.Duration(attempt) * 2 * time.Second)
+		}
+		var ok bool
+		if ok, err = controller.DeleteProject(project.Cookie, project.ID); err == nil {
+			return ok, nil
+		}
+	}
+	return false, err
+}
@@ -0,0 +1,19 @@
+package job
+
+import (
+	"fmt"
+	"genspark2api/common/config"
+	logger "genspark2api/common/loggger"
+	"time"
+)
+
+// SessionEvictTask 定时淘汰过期及超出最大数量的会话映射
+func SessionEvictTask() {
+	for {
+		time.Sleep(time.Minute)
+
+		if evicted := config.GlobalSessionManager.Evict(); evicted > 0 {
+			logger.SysLog(fmt.Sprintf("genspark2api SessionEvictTask evicted %d session(s)", evicted))
+		}
+	}
+}
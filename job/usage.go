@@ -0,0 +1,18 @@
+package job
+
+import (
+	"fmt"
+	"genspark2api/common/config"
+	logger "genspark2api/common/loggger"
+	"time"
+)
+
+// UsagePurgeTask 每天删除超出保留天数的用量记录
+func UsagePurgeTask() {
+	for {
+		if purged := config.PurgeUsageRecords(); purged > 0 {
+			logger.SysLog(fmt.Sprintf("genspark2api UsagePurgeTask purged %d usage record(s)", purged))
+		}
+		time.Sleep(24 * time.Hour)
+	}
+}
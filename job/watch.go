@@ -0,0 +1,76 @@
+package job
+
+import (
+	"fmt"
+	logger "genspark2api/common/loggger"
+	"github.com/fsnotify/fsnotify"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// 文件变化后等待写入完成再重载
+const reloadDebounce = 500 * time.Millisecond
+
+// watchPath 监听文件或目录,变化后调用 reload
+// path 为目录时由 isDirFile 判断目录中哪些文件的变化需要重载
+func watchPath(task string, path string, isDirFile func(name string) bool, reload func()) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		logger.SysError(fmt.Sprintf("genspark2api %s init watcher err: %v", task, err))
+		return
+	}
+	defer watcher.Close()
+
+	path = filepath.Clean(path)
+	info, err := os.Stat(path)
+	if err != nil {
+		logger.SysError(fmt.Sprintf("genspark2api %s stat %s err: %v", task, path, err))
+		return
+	}
+	// 监听文件所在目录,编辑器及 Kubernetes ConfigMap 通过重命名替换文件时仍能收到事件
+	dir := path
+	if !info.IsDir() {
+		dir = filepath.Dir(path)
+	}
+	if err := watcher.Add(dir); err != nil {
+		logger.SysError(fmt.Sprintf("genspark2api %s watch %s err: %v", task, dir, err))
+		return
+	}
+	logger.SysLog(fmt.Sprintf("genspark2api %s watching %s", task, path))
+
+	var timer <-chan time.Time
+	for {
+		select {
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return
+			}
+			if !isWatchedEvent(path, info.IsDir(), isDirFile, event) {
+				continue
+			}
+			timer = time.After(reloadDebounce)
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return
+			}
+			logger.SysError(fmt.Sprintf("genspark2api %s watcher err: %v", task, err))
+		case <-timer:
+			timer = nil
+			reload()
+		}
+	}
+}
+
+func isWatchedEvent(path string, isDir bool, isDirFile func(name string) bool, event fsnotify.Event) bool {
+	if event.Op == fsnotify.Chmod {
+		return false
+	}
+	name := filepath.Clean(event.Name)
+	if isDir {
+		return isDirFile(filepath.Base(name))
+	}
+	// ConfigMap 挂载的文件通过替换 ..data 软链接更新
+	return name == path || strings.HasPrefix(filepath.Base(name), "..data")
+}
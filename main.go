@@ -1,25 +1,64 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"genspark2api/check"
 	"genspark2api/common"
+	"genspark2api/common/alert"
 	"genspark2api/common/config"
+	"genspark2api/common/dialog"
 	logger "genspark2api/common/loggger"
+	"genspark2api/common/state"
+	"genspark2api/common/tracing"
+	"genspark2api/controller"
+	"genspark2api/job"
 	"genspark2api/middleware"
 	"genspark2api/router"
 	"genspark2api/yescaptcha"
 	"github.com/gin-gonic/gin"
+	"net/http"
 	"os"
+	"os/signal"
 	"strconv"
+	"syscall"
+	"time"
 )
 
+// shutdownTimeout 退出时等待进行中的请求完成的最长时间
+const shutdownTimeout = 10 * time.Second
+
 func main() {
+	if *common.ConfigFile != "" {
+		config.ConfigFile = *common.ConfigFile
+	}
+	if err := config.LoadConfigFile(); err != nil {
+		logger.FatalLog(fmt.Sprintf("配置有误:\n%v", err))
+	}
+	if *common.PrintConfig {
+		out, err := config.PrintConfig()
+		if err != nil {
+			logger.FatalLog(err.Error())
+		}
+		fmt.Print(out)
+		os.Exit(0)
+	}
+
+	logger.LogDir = *common.LogDir
 	logger.SetupLogger()
 	logger.SysLog(fmt.Sprintf("genspark2api %s starting...", common.Version))
 
 	check.CheckEnvVariable()
 
+	var shutdownTracing func(context.Context) error
+	if config.TraceEnable == 1 {
+		var err error
+		if shutdownTracing, err = tracing.Init(context.Background()); err != nil {
+			logger.FatalLog("failed to init tracing: " + err.Error())
+		}
+	}
+
 	if os.Getenv("GIN_MODE") != "debug" {
 		gin.SetMode(gin.ReleaseMode)
 	}
@@ -27,22 +66,96 @@ func main() {
 	var err error
 
 	common.InitTokenEncoders()
-	config.InitGSCookies()
+	state.OnError = func(err error) {
+		logger.SysError(err.Error())
+	}
+	if err = config.InitStateStore(); err != nil {
+		logger.FatalLog("failed to init state store: " + err.Error())
+	}
+	if config.ApiSecret != "" && !config.StatePersistent() {
+		logger.Warn(context.Background(), "未配置 STATE_DB_PATH 或 REDIS_CONN_STRING,会话映射等数据保存在内存中,重启后丢失,且不能通过管理接口创建 API Key")
+	}
+	if err = config.InitGSCookies(); err != nil {
+		logger.FatalLog("failed to load cookies: " + err.Error())
+	}
+	check.CheckModelChatMap()
+	if config.JwtEnabled() {
+		config.OnJwksError = func(err error) {
+			logger.SysError(err.Error())
+		}
+		if err = config.ReloadJwks(); err != nil {
+			logger.FatalLog("failed to load jwks: " + err.Error())
+		}
+		go job.JwksRefreshTask()
+	}
 	config.YescaptchaClient = yescaptcha.NewClient(config.YesCaptchaClientKey, nil)
 
 	config.GlobalSessionManager = config.NewSessionManager()
+	config.GlobalSessionManager.OnEvict = controller.OnSessionEvicted
+	if config.SessionTTL > 0 || config.SessionMaxEntries > 0 {
+		go job.SessionEvictTask()
+	}
+	if config.ModelChatProvision == 1 {
+		controller.ProvisionModelChats()
+	}
+	if config.UsageLedgerEnable == 1 && config.UsageRetentionDays > 0 {
+		go job.UsagePurgeTask()
+	}
+	if config.ProjectCleanupEnable == 1 {
+		go job.ProjectCleanupTask()
+	}
+	if err := dialog.Init(); err != nil {
+		logger.FatalLog(fmt.Sprintf("failed to init dialog record: %v", err))
+	}
+	if dialog.Enabled() {
+		go job.DialogRecordTask()
+	}
+	if err := alert.Init(); err != nil {
+		logger.FatalLog(fmt.Sprintf("failed to init alert: %v", err))
+	}
+	if alert.EventEnabled(alert.EventPoolLow) {
+		go job.AlertPoolTask()
+	}
+
+	if logger.HasLogFiles() {
+		if config.LogRotateInterval > 0 {
+			go job.LogRotateTask()
+		}
+		go job.LogReopenTask()
+	}
 
-	// 定时任务 每天9点整重载GS_COOKIES
-	//go job.LoadCookieTask()
+	// 监听cookie文件,变化后重载cookie池
+	if config.GSCookieFile != "" {
+		go job.CookieWatchTask()
+	}
+
+	// 监听配置文件,变化后重载可热重载的配置项
+	if config.ConfigFile != "" {
+		go job.ConfigWatchTask()
+	}
+
+	// 监听IP规则文件,变化后重载IP规则
+	if config.IpListFile != "" {
+		go job.IPListWatchTask()
+	}
 
 	server := gin.New()
+	// 仅信任来自 TRUSTED_PROXIES 的真实IP请求头,防止伪造客户端IP;未设置时保持 gin 默认信任任意来源的行为
+	if config.TrustedProxies != "" {
+		if err = server.SetTrustedProxies(config.SplitList(config.TrustedProxies)); err != nil {
+			logger.FatalLog("环境变量 TRUSTED_PROXIES 设置有误: " + err.Error())
+		}
+	} else if config.IpBlackList != "" || config.IpWhiteList != "" || config.IpRouteRules != "" || config.IpListFile != "" {
+		logger.SysLog("环境变量 TRUSTED_PROXIES 未设置,将信任任意来源的 " + config.RealIpHeader + " 请求头,客户端可伪造IP绕过IP规则,建议设置为反向代理的地址")
+	}
+	server.RemoteIPHeaders = config.SplitList(config.RealIpHeader)
 	server.Use(gin.Recovery())
 	server.Use(middleware.RequestId())
 	middleware.SetUpLogger(server)
 
 	router.SetRouter(server)
-	var port = os.Getenv("PORT")
-	if port == "" {
+	var port = strconv.Itoa(config.Port)
+	if config.Port == 0 {
 		port = strconv.Itoa(*common.Port)
 	}
 
@@ -52,10 +165,27 @@ func main() {
 
 	logger.SysLog("genspark2api start success. enjoy it! ^_^\n")
 
-	err = server.Run(":" + port)
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	httpServer := &http.Server{Addr: ":" + port, Handler: server}
+	go func() {
+		if err := httpServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.FatalLog("failed to start HTTP server: " + err.Error())
+		}
+	}()
+	<-ctx.Done()
+	stop()
 
-	if err != nil {
-		logger.FatalLog("failed to start HTTP server: " + err.Error())
+	// 收到退出信号后等待进行中的请求完成,并导出尚未发送的 span
+	logger.SysLog("genspark2api shutting down...")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err = httpServer.Shutdown(shutdownCtx); err != nil {
+		logger.SysError("failed to shutdown HTTP server: " + err.Error())
+	}
+	if shutdownTracing != nil {
+		if err = shutdownTracing(shutdownCtx); err != nil {
+			logger.SysError("failed to shutdown tracing: " + err.Error())
+		}
 	}
-
 }
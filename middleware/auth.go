@@ -1,7 +1,10 @@
 package middleware
 
 import (
+	"fmt"
 	"genspark2api/common/config"
+	"genspark2api/common/helper"
+	logger "genspark2api/common/loggger"
 	"genspark2api/model"
 	"github.com/gin-gonic/gin"
 	"github.com/samber/lo"
@@ -30,15 +33,10 @@ func authHelper(c *gin.Context) {
 func authHelperForOpenai(c *gin.Context) {
 	secret := c.Request.Header.Get("Authorization")
 	secret = strings.Replace(secret, "Bearer ", "", 1)
-	if isValidSecret(secret) {
-		c.JSON(http.StatusUnauthorized, model.OpenAIErrorResponse{
-			OpenAIError: model.OpenAIError{
-				Message: "authorization(api-secret)校验失败",
-				Type:    "invalid_request_error",
-				Code:    "invalid_authorization",
-			},
-		})
-		c.Abort()
+
+	tenant, err := resolveTenant(secret)
+	if err != nil {
+		abortWithOpenAIError(c, http.StatusUnauthorized, err.Error(), "invalid_request_error", "invalid_authorization")
 		return
 	}
 
@@ -46,16 +44,82 @@ func authHelperForOpenai(c *gin.Context) {
 		c.Request.Header.Set("Authorization", "")
 	}
 
+	if tenant.QuotaExceeded() {
+		abortWithOpenAIError(c, http.StatusTooManyRequests, "You exceeded your current monthly token quota.", "insufficient_quota", "insufficient_quota")
+		return
+	}
+	release, ok := config.AcquireTenantSlot(tenant)
+	if !ok {
+		abortWithOpenAIError(c, http.StatusTooManyRequests, "Too many concurrent requests for this api key.", "requests", "rate_limit_exceeded")
+		return
+	}
+	defer release()
+
+	if tenant.ID == config.TenantAnonymous {
+		// 未设置 API_SECRET 时按客户端IP限速
+		tenant.RateLimitKey = "ip:" + c.ClientIP()
+	}
+	c.Set(helper.TenantKey, tenant)
+	logger.SetField(c.Request.Context(), logger.FieldKey, tenant.ID)
 	c.Next()
 	return
 }
 
+// resolveTenant 根据请求的 Key 解析租户
+// API_SECRET 中的 Key 为默认租户,配置 JWT_JWKS 时 JWT 按 claim 映射为租户,其它 Key 从 API Key 存储中查找
+func resolveTenant(secret string) (*config.Tenant, error) {
+	if secret != "" && lo.Contains(config.ApiSecrets, secret) {
+		// 共用默认租户,但每个 Key 分别限速
+		return &config.Tenant{ID: config.TenantDefault, Name: config.TenantDefault, RateLimitKey: config.SecretRateLimitKey(secret)}, nil
+	}
+	if config.JwtEnabled() && config.LooksLikeJwt(secret) {
+		return config.ParseJwtTenant(secret)
+	}
+	if apiKey, ok := config.LookupApiKey(secret); ok {
+		if err := apiKey.Validate(); err != nil {
+			return nil, err
+		}
+		return apiKey.Tenant(), nil
+	}
+	// 启用 JWT 鉴权时不允许匿名访问
+	if config.ApiSecret == "" && !config.JwtEnabled() {
+		return &config.Tenant{ID: config.TenantAnonymous, Name: config.TenantAnonymous}, nil
+	}
+	return nil, fmt.Errorf("authorization(api-secret)校验失败")
+}
+
+func abortWithOpenAIError(c *gin.Context, status int, message, errType, code string) {
+	c.JSON(status, model.OpenAIErrorResponse{
+		OpenAIError: model.OpenAIError{
+			Message: message,
+			Type:    errType,
+			Code:    code,
+		},
+	})
+	c.Abort()
+}
+
 func Auth() func(c *gin.Context) {
 	return func(c *gin.Context) {
 		authHelper(c)
 	}
 }
 
+// AdminAuth 管理接口鉴权,未设置 API_SECRET 时禁止访问
+func AdminAuth() func(c *gin.Context) {
+	return func(c *gin.Context) {
+		if config.ApiSecret == "" {
+			c.JSON(http.StatusForbidden, gin.H{
+				"success": false,
+				"message": "未设置环境变量 API_SECRET,管理接口不可用",
+			})
+			c.Abort()
+			return
+		}
+		authHelper(c)
+	}
+}
+
 func OpenAIAuth() func(c *gin.Context) {
 	return func(c *gin.Context) {
 		authHelperForOpenai(c)
@@ -0,0 +1,71 @@
+package middleware
+
+import (
+	"bytes"
+	"fmt"
+	"genspark2api/common/config"
+	"genspark2api/common/dialog"
+	"genspark2api/common/helper"
+	logger "genspark2api/common/loggger"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// DialogRecord 记录对话的请求及回答,需在 UsageLedger 之后使用
+func DialogRecord() func(c *gin.Context) {
+	return func(c *gin.Context) {
+		if !dialog.Enabled() || c.Request.Method != http.MethodPost || c.Request.Body == nil {
+			c.Next()
+			return
+		}
+		if tenant, ok := c.Get(helper.TenantKey); ok && !tenant.(*config.Tenant).DialogRecordEnabled() {
+			c.Next()
+			return
+		}
+		body, err := io.ReadAll(c.Request.Body)
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+		if err != nil {
+			c.Next()
+			return
+		}
+		start := time.Now()
+
+		c.Next()
+
+		usage, ok := c.Get(helper.UsageKey)
+		if !ok || usage.(*config.UsageRecord).Model == "" {
+			return
+		}
+		u := usage.(*config.UsageRecord)
+		record := &dialog.Record{
+			Time:             start,
+			RequestId:        u.RequestId,
+			KeyId:            u.KeyId,
+			KeyName:          u.KeyName,
+			Path:             u.Path,
+			Model:            u.Model,
+			Stream:           u.Stream,
+			Cookie:           logger.GetField(c.Request.Context(), logger.FieldCookie),
+			Request:          body,
+			PromptTokens:     u.PromptTokens,
+			CompletionTokens: u.CompletionTokens,
+			Status:           c.Writer.Status(),
+			LatencyMs:        time.Since(start).Milliseconds(),
+		}
+		if completion, ok := c.Get(helper.CompletionKey); ok {
+			record.SetAnswer(completion.(fmt.Stringer).String())
+		}
+		if images, ok := c.Get(helper.ImagesKey); ok {
+			record.Images = images.([]string)
+		}
+		if first, ok := c.Get(helper.FirstTokenKey); ok {
+			record.FirstTokenMs = first.(time.Time).Sub(start).Milliseconds()
+		}
+		if err := dialog.Write(record); err != nil {
+			logger.Errorf(c.Request.Context(), "failed to write dialog record: %v", err)
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"genspark2api/common/config"
+	"genspark2api/common/helper"
+	"github.com/gin-gonic/gin"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// KeyRateLimit 按 API Key 及模型进行令牌桶限速,并返回 X-RateLimit-* 响应头
+// 先检查全部限制,均未超出时才扣除请求数,避免被拒绝的请求占用其它限制的额度
+// token 数在请求完成后按实际用量扣除,超出的部分需等待补足后才能继续请求
+func KeyRateLimit() func(c *gin.Context) {
+	return func(c *gin.Context) {
+		value, ok := c.Get(helper.TenantKey)
+		if !ok {
+			c.Next()
+			return
+		}
+		tenant := value.(*config.Tenant)
+		modelName, stream := peekModel(c)
+		// 与降级后扣除 token 时一致,按模型 id 限速
+		modelName = config.Current().Models.Resolve(modelName)
+
+		limit := tenant.RateLimit()
+		tenantKey := config.TenantRateLimitKey(tenant)
+		tokens := config.CheckTokens(tenantKey, limit.TPM)
+		requests := config.CheckRequest(tenantKey, limit.RPM)
+		if !requests.Allowed || !tokens.Allowed {
+			setRateLimitHeaders(c, requests, tokens)
+			abortTenantRateLimited(c, requests, tokens)
+			return
+		}
+		if result, errType := config.CheckModelRateLimit(modelName); !result.Allowed {
+			setRateLimitHeaders(c, requests, tokens)
+			abortModelRateLimited(c, modelName, result, errType)
+			return
+		}
+
+		if stream {
+			release, ok := config.AcquireTenantStream(tenant, limit.MaxStreams)
+			if !ok {
+				setRateLimitHeaders(c, requests, tokens)
+				abortWithOpenAIError(c, http.StatusTooManyRequests, fmt.Sprintf("Too many concurrent streams for this api key, limit: %d.", limit.MaxStreams), "requests", "rate_limit_exceeded")
+				return
+			}
+			defer release()
+		}
+
+		// 检查与扣除之间其它请求可能已用完额度,此时仍拒绝
+		requests = config.TakeRequest(tenantKey, limit.RPM)
+		setRateLimitHeaders(c, requests, tokens)
+		if !requests.Allowed {
+			abortTenantRateLimited(c, requests, tokens)
+			return
+		}
+		if result := config.TakeModelRequest(modelName); !result.Allowed {
+			abortModelRateLimited(c, modelName, result, "requests")
+			return
+		}
+
+		c.Next()
+
+		if usage, ok := c.Get(helper.UsageKey); ok {
+			record := usage.(*config.UsageRecord)
+			used := record.PromptTokens + record.CompletionTokens
+			config.ConsumeTokens(tenantKey, limit.TPM, used)
+			// 降级时按实际回答的模型扣除
+			if record.Model != "" {
+				modelName = record.Model
+			}
+			config.ConsumeModelTokens(modelName, used)
+		}
+	}
+}
+
+func abortTenantRateLimited(c *gin.Context, requests, tokens config.RateLimitResult) {
+	if !requests.Allowed {
+		abortRateLimited(c, requests, fmt.Sprintf("Rate limit reached for requests per minute, limit: %d.", requests.Limit), "requests")
+		return
+	}
+	abortRateLimited(c, tokens, fmt.Sprintf("Rate limit reached for tokens per minute, limit: %d.", tokens.Limit), "tokens")
+}
+
+func abortModelRateLimited(c *gin.Context, modelName string, result config.RateLimitResult, errType string) {
+	abortRateLimited(c, result, fmt.Sprintf("Rate limit reached for model %s %s per minute, limit: %d.", modelName, errType, result.Limit), errType)
+}
+
+// peekModel 读取请求体中的 model 及 stream 字段,并还原请求体供后续处理
+func peekModel(c *gin.Context) (string, bool) {
+	if c.Request.Body == nil || c.Request.Method != http.MethodPost {
+		return "", false
+	}
+	body, err := io.ReadAll(c.Request.Body)
+	c.Request.Body = io.NopCloser(bytes.NewReader(body))
+	if err != nil {
+		return "", false
+	}
+	var req struct {
+		Model  string `json:"model"`
+		Stream bool   `json:"stream"`
+	}
+	if err := json.Unmarshal(body, &req); err != nil {
+		return "", false
+	}
+	return req.Model, req.Stream
+}
+
+func setRateLimitHeaders(c *gin.Context, requests, tokens config.RateLimitResult) {
+	if requests.Limit > 0 {
+		c.Header("X-RateLimit-Limit-Requests", strconv.Itoa(requests.Limit))
+		c.Header("X-RateLimit-Remaining-Requests", strconv.FormatInt(requests.Remaining, 10))
+		c.Header("X-RateLimit-Reset-Requests", formatReset(requests.Reset))
+	}
+	if tokens.Limit > 0 {
+		c.Header("X-RateLimit-Limit-Tokens", strconv.Itoa(tokens.Limit))
+		c.Header("X-RateLimit-Remaining-Tokens", strconv.FormatInt(tokens.Remaining, 10))
+		c.Header("X-RateLimit-Reset-Tokens", formatReset(tokens.Reset))
+	}
+}
+
+// formatReset 与 OpenAI 一致的重置时间格式,如 1s、6m0s、20ms
+func formatReset(d time.Duration) string {
+	if d < time.Second {
+		return d.Round(time.Millisecond).String()
+	}
+	return d.Round(time.Second).String()
+}
+
+func abortRateLimited(c *gin.Context, result config.RateLimitResult, message, errType string) {
+	c.Header("Retry-After", strconv.Itoa(int(math.Max(math.Ceil(result.RetryAfter.Seconds()), 1))))
+	abortWithOpenAIError(c, http.StatusTooManyRequests, message, errType, "rate_limit_exceeded")
+}
@@ -1,25 +1,24 @@
 package middleware
 
 import (
-	"fmt"
-	"genspark2api/common/helper"
+	logger "genspark2api/common/loggger"
 	"github.com/gin-gonic/gin"
+	"log/slog"
+	"time"
 )
 
+// SetUpLogger 访问日志,请求 ID 及 key、model 等字段与应用日志一致
 func SetUpLogger(server *gin.Engine) {
-	server.Use(gin.LoggerWithFormatter(func(param gin.LogFormatterParams) string {
-		var requestID string
-		if param.Keys != nil {
-			requestID = param.Keys[helper.RequestIdKey].(string)
-		}
-		return fmt.Sprintf("[GIN] %s | %s | %3d | %13v | %15s | %7s %s\n",
-			param.TimeStamp.Format("2006/01/02 - 15:04:05"),
-			requestID,
-			param.StatusCode,
-			param.Latency,
-			param.ClientIP,
-			param.Method,
-			param.Path,
+	server.Use(func(c *gin.Context) {
+		start := time.Now()
+		path := c.Request.URL.Path
+		c.Next()
+		logger.Access(c.Request.Context(), "access",
+			slog.Int("status", c.Writer.Status()),
+			slog.Duration("latency", time.Since(start)),
+			slog.String("client_ip", c.ClientIP()),
+			slog.String("method", c.Request.Method),
+			slog.String("path", path),
 		)
-	}))
+	})
 }
@@ -0,0 +1,23 @@
+package middleware
+
+import (
+	"genspark2api/common/config"
+	"genspark2api/common/helper"
+	"genspark2api/common/metrics"
+	"github.com/gin-gonic/gin"
+	"time"
+)
+
+// Metrics 按路由、模型及状态码统计请求数及耗时
+func Metrics() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		var modelName string
+		if usage, ok := c.Get(helper.UsageKey); ok {
+			modelName = usage.(*config.UsageRecord).Model
+		}
+		metrics.ObserveRequest(c.FullPath(), modelName, c.Writer.Status(), time.Since(start))
+	}
+}
@@ -1,36 +1,26 @@
 package middleware
 
 import (
-	"genspark2api/common"
 	"genspark2api/common/config"
 	"github.com/gin-gonic/gin"
 	"net/http"
+	"strconv"
 )
 
 var timeFormat = "2006-01-02T15:04:05.000Z"
 
-var inMemoryRateLimiter common.InMemoryRateLimiter
-
-func memoryRateLimiter(c *gin.Context, maxRequestNum int, duration int64, mark string) {
+func storeRateLimiter(c *gin.Context, maxRequestNum int, duration int64, mark string) {
 	key := mark + c.ClientIP()
-	if !inMemoryRateLimiter.Request(key, maxRequestNum, duration) {
-		c.JSON(http.StatusTooManyRequests, gin.H{
-			"success": false,
-			"message": "请求过于频繁,请稍后再试",
-		})
-		c.Abort()
+	if !config.StateStore.Request(key, maxRequestNum, duration) {
+		c.Header("Retry-After", strconv.FormatInt(duration, 10))
+		abortWithOpenAIError(c, http.StatusTooManyRequests, "Too many requests from this IP, please try again later.", "requests", "rate_limit_exceeded")
 		return
 	}
 }
 
-func rateLimitFactory(maxRequestNum int, duration int64, mark string) func(c *gin.Context) {
-	// It's safe to call multi times.
-	inMemoryRateLimiter.Init(config.RateLimitKeyExpirationDuration)
+// RequestRateLimit 单IP请求限速,每次请求读取 REQUEST_RATE_LIMIT,修改配置文件后无需重启
+func RequestRateLimit() func(c *gin.Context) {
 	return func(c *gin.Context) {
-		memoryRateLimiter(c, maxRequestNum, duration, mark)
+		storeRateLimiter(c, config.Current().RequestRateLimitNum, config.RequestRateLimitDuration, "REQUEST_RATE_LIMIT")
 	}
 }
-
-func RequestRateLimit() func(c *gin.Context) {
-	return rateLimitFactory(config.RequestRateLimitNum, config.RequestRateLimitDuration, "REQUEST_RATE_LIMIT")
-}
@@ -3,6 +3,7 @@ package middleware
 import (
 	"context"
 	"genspark2api/common/helper"
+	logger "genspark2api/common/loggger"
 	"github.com/gin-gonic/gin"
 )
 
@@ -11,6 +12,7 @@ func RequestId() func(c *gin.Context) {
 		id := helper.GenRequestID()
 		c.Set(helper.RequestIdKey, id)
 		ctx := context.WithValue(c.Request.Context(), helper.RequestIdKey, id)
+		ctx = logger.WithFields(ctx)
 		c.Request = c.Request.WithContext(ctx)
 		c.Header(helper.RequestIdKey, id)
 		c.Next()
@@ -0,0 +1,62 @@
+package middleware
+
+import (
+	"fmt"
+	"genspark2api/common/config"
+	"genspark2api/common/helper"
+	"genspark2api/common/tracing"
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+	"go.opentelemetry.io/otel/trace"
+	"net/http"
+)
+
+// Tracing 为每个请求创建 span,并在响应头 X-Trace-Id 中返回 trace ID
+func Tracing() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := otel.GetTextMapPropagator().Extract(c.Request.Context(), propagation.HeaderCarrier(c.Request.Header))
+		route := c.FullPath()
+		name := c.Request.Method
+		if route != "" {
+			name += " " + route
+		}
+		ctx, span := tracing.Start(ctx, name,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				semconv.HTTPRequestMethodKey.String(c.Request.Method),
+				semconv.HTTPRoute(route),
+				semconv.URLPath(c.Request.URL.Path),
+				semconv.ClientAddress(c.ClientIP()),
+				tracing.AttrRequestId.String(c.GetString(helper.RequestIdKey)),
+			),
+		)
+		defer span.End()
+		if span.SpanContext().IsValid() {
+			c.Header("X-Trace-Id", span.SpanContext().TraceID().String())
+		}
+		c.Request = c.Request.WithContext(ctx)
+
+		c.Next()
+
+		status := c.Writer.Status()
+		span.SetAttributes(semconv.HTTPResponseStatusCode(status))
+		if status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, fmt.Sprintf("status %d", status))
+		}
+		if usage, ok := c.Get(helper.UsageKey); ok {
+			record := usage.(*config.UsageRecord)
+			if record.Model != "" {
+				operation := tracing.OperationChat
+				if record.Images > 0 {
+					operation = tracing.OperationImage
+				}
+				span.SetAttributes(tracing.GenAIAttributes(operation, record.Model)...)
+				span.SetAttributes(tracing.GenAIUsageAttributes(record.Model, record.PromptTokens, record.CompletionTokens)...)
+				span.SetAttributes(tracing.AttrImages.Int(record.Images))
+			}
+		}
+	}
+}
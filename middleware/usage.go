@@ -0,0 +1,34 @@
+package middleware
+
+import (
+	"genspark2api/common/config"
+	"genspark2api/common/helper"
+	"github.com/gin-gonic/gin"
+	"time"
+)
+
+// UsageLedger 记录每次请求的用量,模型及 token 数由处理函数写入请求上下文中的用量记录
+func UsageLedger() func(c *gin.Context) {
+	return func(c *gin.Context) {
+		record := &config.UsageRecord{
+			Time:      time.Now(),
+			RequestId: c.GetString(helper.RequestIdKey),
+			Path:      c.FullPath(),
+		}
+		if tenant, ok := c.Get(helper.TenantKey); ok {
+			record.KeyId = tenant.(*config.Tenant).ID
+			record.KeyName = tenant.(*config.Tenant).Name
+		}
+		c.Set(helper.UsageKey, record)
+
+		c.Next()
+
+		// 不记录未调用模型的请求(如模型列表、用量查询)
+		if record.Model == "" {
+			return
+		}
+		record.Status = c.Writer.Status()
+		record.LatencyMs = time.Since(record.Time).Milliseconds()
+		config.AddUsageRecord(record)
+	}
+}
@@ -4,11 +4,14 @@ type OpenAIChatCompletionRequest struct {
 	Model    string              `json:"model"`
 	Stream   bool                `json:"stream"`
 	Messages []OpenAIChatMessage `json:"messages"`
+	User     string              `json:"user"`
 	OpenAIChatCompletionExtraRequest
 }
 
 type OpenAIChatCompletionExtraRequest struct {
 	ChannelId *string `json:"channelId"`
+	// Fallback 为 false 时失败不降级到其它模型
+	Fallback *bool `json:"fallback,omitempty"`
 }
 
 type SessionState struct {
@@ -20,25 +23,22 @@ type SessionState struct {
 type OpenAIChatMessage struct {
 	Role         string        `json:"role"`
 	Content      interface{}   `json:"content"`
+	ToolCalls    interface{}   `json:"tool_calls,omitempty"`
+	ToolCallId   string        `json:"tool_call_id,omitempty"`
 	IsPrompt     bool          `json:"is_prompt"`
 	SessionState *SessionState `json:"session_state"`
 }
 
+// SystemMessagesProcess system 消息转换为 user 消息,assistant 消息标记为由 model 回答
 func (r *OpenAIChatCompletionRequest) SystemMessagesProcess(model string) {
-	if r.Messages == nil {
-		return
-	}
-
-	if model == "deep-seek-r1" {
-		for i := range r.Messages {
-			if r.Messages[i].Role == "system" {
-				r.Messages[i].Role = "user"
-			}
-			if r.Messages[i].Role == "assistant" {
-				r.Messages[i].IsPrompt = false
-				r.Messages[i].SessionState = &SessionState{
-					Models: []string{model},
-				}
+	for i := range r.Messages {
+		if r.Messages[i].Role == "system" {
+			r.Messages[i].Role = "user"
+		}
+		if r.Messages[i].Role == "assistant" {
+			r.Messages[i].IsPrompt = false
+			r.Messages[i].SessionState = &SessionState{
+				Models: []string{model},
 			}
 		}
 	}
@@ -152,10 +152,28 @@ type OpenAIModerationResponse struct {
 }
 
 type OpenaiModelResponse struct {
-	ID     string `json:"id"`
-	Object string `json:"object"`
-	//Created time.Time `json:"created"`
-	//OwnedBy string    `json:"owned_by"`
+	ID      string `json:"id"`
+	Object  string `json:"object"`
+	Created int64  `json:"created"`
+	OwnedBy string `json:"owned_by"`
+
+	// Type 模型类型 text/image/mixture
+	Type string `json:"type"`
+	// Parent 联网模型(-search)的基础模型
+	Parent string `json:"parent,omitempty"`
+	// Aliases 请求时可使用的别名
+	Aliases []string `json:"aliases,omitempty"`
+	// Models mixture 模型同时请求的文本模型
+	Models        []string                `json:"models,omitempty"`
+	ContextWindow int                     `json:"context_window,omitempty"`
+	Capabilities  OpenaiModelCapabilities `json:"capabilities"`
+}
+
+type OpenaiModelCapabilities struct {
+	Vision          bool `json:"vision"`
+	Reasoning       bool `json:"reasoning"`
+	Search          bool `json:"search"`
+	ImageGeneration bool `json:"image_generation"`
 }
 
 // ModelList represents a list of models.
@@ -6,24 +6,63 @@ import (
 	"genspark2api/controller"
 	"genspark2api/middleware"
 	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"strings"
 )
 
 func SetApiRouter(router *gin.Engine) {
+	if config.TraceEnable == 1 {
+		router.Use(middleware.Tracing())
+	}
+	if config.MetricsEnable == 1 {
+		router.Use(middleware.Metrics())
+	}
 	router.Use(middleware.CORS())
 	//router.Use(gzip.Gzip(gzip.DefaultCompression))
+
+	// 探针在IP访问控制及请求限速之前注册,不受其影响
+	router.GET("/", controller.Healthz)
+	router.GET(fmt.Sprintf("%s/healthz", ProcessPath(config.RoutePrefix)), controller.Healthz)
+	router.GET(fmt.Sprintf("%s/readyz", ProcessPath(config.RoutePrefix)), controller.Readyz)
+
 	router.Use(middleware.IPBlacklistMiddleware())
 	router.Use(middleware.RequestRateLimit())
 
-	router.GET("/")
+	if config.MetricsEnable == 1 {
+		router.GET(fmt.Sprintf("%s/metrics", ProcessPath(config.RoutePrefix)), gin.WrapH(promhttp.Handler()))
+	}
+
+	apiRouter := router.Group(fmt.Sprintf("%s/api", ProcessPath(config.RoutePrefix)))
+	apiRouter.Use(middleware.AdminAuth())
+	apiRouter.GET("/model/chat/map", controller.GetModelChatMap)
+	apiRouter.PUT("/model/chat/map", controller.SetModelChatMap)
+	apiRouter.DELETE("/model/chat/map", controller.DeleteModelChatMap)
+	apiRouter.POST("/init/model/chat/map", controller.InitModelChatMap)
+	apiRouter.GET("/keys", controller.ListApiKeys)
+	apiRouter.POST("/keys", controller.CreateApiKey)
+	apiRouter.GET("/keys/:id", controller.GetApiKey)
+	apiRouter.PUT("/keys/:id", controller.UpdateApiKey)
+	apiRouter.DELETE("/keys/:id", controller.DeleteApiKey)
+	apiRouter.GET("/ip/rules", controller.GetIPPolicy)
+	apiRouter.POST("/ip/rules/reload", controller.ReloadIPPolicy)
+	apiRouter.GET("/log/level", controller.GetLogLevel)
+	apiRouter.PUT("/log/level", controller.SetLogLevel)
+	apiRouter.POST("/log/rotate", controller.RotateLogFiles)
+	apiRouter.GET("/dialogs", controller.SearchDialogs)
+	apiRouter.GET("/status", controller.GetStatus)
+	apiRouter.GET("/config", controller.GetConfig)
+	apiRouter.POST("/config/reload", controller.ReloadConfig)
 
-	//router.GET("/api/init/model/chat/map", controller.InitModelChatMap)
 	//https://api.openai.com/v1/images/generations
 	v1Router := router.Group(fmt.Sprintf("%s/v1", ProcessPath(config.RoutePrefix)))
 	v1Router.Use(middleware.OpenAIAuth())
-	v1Router.POST("/chat/completions", controller.ChatForOpenAI)
-	v1Router.POST("/images/generations", controller.ImagesForOpenAI)
+	v1Router.Use(middleware.UsageLedger())
+	v1Router.Use(middleware.KeyRateLimit())
+	v1Router.POST("/chat/completions", middleware.DialogRecord(), controller.ChatForOpenAI)
+	v1Router.POST("/images/generations", middleware.DialogRecord(), controller.ImagesForOpenAI)
 	v1Router.GET("/models", controller.OpenaiModels)
+	v1Router.GET("/models/*id", controller.RetrieveModel)
+	v1Router.GET("/usage", controller.GetUsage)
 }
 
 func ProcessPath(path string) string {